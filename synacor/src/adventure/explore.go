@@ -0,0 +1,243 @@
+package adventure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"memory"
+	"snapshot"
+)
+
+type Exit struct {
+	Name string `json:"name"`
+
+	// To is the ID of the room the exit leads to, or -1 if it doesn't
+	// lead to a room (or killed the player), in which case Note holds
+	// what the game printed.
+	To   int    `json:"to"`
+	Note string `json:"note,omitempty"`
+}
+
+type MapRoom struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Items       []string `json:"items"`
+	Exits       []*Exit  `json:"exits"`
+
+	// AddrVal is the value of the map's RoomAddr word in the room, if the
+	// map was explored with ExploreOptions.KeyByAddr.
+	AddrVal uint16 `json:"addr_val,omitempty"`
+
+	// State is the game state on arrival in the room.
+	State *snapshot.Snapshot `json:"-"`
+}
+
+// Key returns the same key as Room.Key for the room's description.
+func (r *MapRoom) Key() string {
+	exits := []string{}
+	for _, exit := range r.Exits {
+		exits = append(exits, exit.Name)
+	}
+	return roomKey(r.Title, r.Description, exits)
+}
+
+type Map struct {
+	Rooms []*MapRoom `json:"rooms"`
+
+	// Copied from the ExploreOptions used to build the map, so rooms can
+	// be identified the same way when following it.
+	KeyByAddr bool   `json:"key_by_addr,omitempty"`
+	RoomAddr  uint16 `json:"room_addr,omitempty"`
+}
+
+// key returns the key identifying the room in g, described by room.
+func (m *Map) key(g *Game, room *Room) string {
+	if m.KeyByAddr {
+		return fmt.Sprint(g.RAM().Read(m.RoomAddr))
+	}
+	return room.Key()
+}
+
+// roomKey returns the key which identified mr when the map was explored.
+func (m *Map) roomKey(mr *MapRoom) string {
+	if m.KeyByAddr {
+		return fmt.Sprint(mr.AddrVal)
+	}
+	return mr.Key()
+}
+
+// ReadMap reads a map written by WriteJSON.
+func ReadMap(r io.Reader) (*Map, error) {
+	m := &Map{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+
+	if m.KeyByAddr && m.RoomAddr >= memory.Size {
+		return nil, fmt.Errorf("room address %v out of range", m.RoomAddr)
+	}
+	for i, room := range m.Rooms {
+		if room.ID != i {
+			return nil, fmt.Errorf("room %d has id %d", i, room.ID)
+		}
+		for _, exit := range room.Exits {
+			if exit.To >= len(m.Rooms) {
+				return nil, fmt.Errorf("room %d exit %v to unknown room %d", i, exit.Name, exit.To)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func ReadMapFromPath(path string) (*Map, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return ReadMap(fp)
+}
+
+type ExploreOptions struct {
+	MaxRooms int
+
+	// If KeyByAddr is set, rooms are identified by the value of the RAM
+	// word at RoomAddr rather than by their descriptions, which aren't
+	// always unique.
+	KeyByAddr bool
+	RoomAddr  uint16
+}
+
+// Explore maps the game breadth-first, starting with the room described
+// by out, the output that put the game in its current state. Each exit is
+// tried from a saved copy of the state on arrival in its room, so the
+// game is left in an unspecified state.
+func Explore(g *Game, out string, opts ExploreOptions) (*Map, error) {
+	if opts.KeyByAddr && opts.RoomAddr >= memory.Size {
+		return nil, fmt.Errorf("room address %v out of range", opts.RoomAddr)
+	}
+
+	m := &Map{KeyByAddr: opts.KeyByAddr, RoomAddr: opts.RoomAddr}
+	byKey := map[string]*MapRoom{}
+
+	addRoom := func(out string) (*MapRoom, bool) {
+		room, found := ParseRoom(out)
+		if !found {
+			return nil, false
+		}
+
+		key := m.key(g, room)
+		if mr, found := byKey[key]; found {
+			return mr, true
+		}
+
+		mr := &MapRoom{
+			ID:          len(m.Rooms),
+			Title:       room.Title,
+			Description: room.Description,
+			Items:       room.Items,
+			State:       g.Save(),
+		}
+		if m.KeyByAddr {
+			mr.AddrVal = g.RAM().Read(m.RoomAddr)
+		}
+		for _, name := range room.Exits {
+			mr.Exits = append(mr.Exits, &Exit{Name: name, To: -1})
+		}
+
+		m.Rooms = append(m.Rooms, mr)
+		byKey[key] = mr
+		return mr, true
+	}
+
+	if _, found := addRoom(out); !found {
+		return nil, fmt.Errorf("no room in initial output")
+	}
+
+	for i := 0; i < len(m.Rooms); i++ {
+		room := m.Rooms[i]
+		for _, exit := range room.Exits {
+			g.Restore(room.State)
+			out, err := g.Command("go " + exit.Name)
+			if err != nil && !errors.Is(err, ErrHalted) {
+				return nil, fmt.Errorf("%v: go %v: %v", room.Title, exit.Name, err)
+			}
+
+			if err == nil {
+				if dest, found := addRoom(out); found {
+					exit.To = dest.ID
+					continue
+				}
+			}
+
+			exit.Note = strings.Join(strings.Fields(out), " ")
+			if errors.Is(err, ErrHalted) {
+				exit.Note = "halted: " + exit.Note
+			}
+		}
+
+		if opts.MaxRooms > 0 && len(m.Rooms) >= opts.MaxRooms {
+			break
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Map) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func dotQuote(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, "\n", `\n`, -1)
+	return `"` + strings.Replace(str, `"`, `\"`, -1) + `"`
+}
+
+// WriteDOT writes the map as a Graphviz digraph. Exits which don't lead to
+// rooms are drawn to dashed nodes labeled with what happened.
+func (m *Map) WriteDOT(w io.Writer) error {
+	lines := []string{
+		"digraph map {",
+		"  node [shape=box];",
+	}
+
+	for _, room := range m.Rooms {
+		label := fmt.Sprintf("%d. %s", room.ID, room.Title)
+		if len(room.Items) > 0 {
+			label += "\n* " + strings.Join(room.Items, "\n* ")
+		}
+		lines = append(lines, fmt.Sprintf("  r%d [label=%s];", room.ID, dotQuote(label)))
+	}
+
+	for _, room := range m.Rooms {
+		for i, exit := range room.Exits {
+			if exit.To >= 0 {
+				lines = append(lines, fmt.Sprintf("  r%d -> r%d [label=%s];",
+					room.ID, exit.To, dotQuote(exit.Name)))
+				continue
+			}
+
+			note := exit.Note
+			if len(note) > 40 {
+				note = note[:40] + "..."
+			}
+			lines = append(lines,
+				fmt.Sprintf("  r%d_%d [label=%s style=dashed];", room.ID, i, dotQuote(note)),
+				fmt.Sprintf("  r%d -> r%d_%d [label=%s];", room.ID, room.ID, i, dotQuote(exit.Name)))
+		}
+	}
+	lines = append(lines, "}")
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
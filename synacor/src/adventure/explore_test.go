@@ -0,0 +1,106 @@
+package adventure
+
+import (
+	"strings"
+	"testing"
+
+	"instruction"
+	"util"
+)
+
+// startChallenge returns a game running the challenge binary, along with
+// its initial output.
+func startChallenge(t *testing.T) (*Game, string) {
+	image, err := util.ReadWordsFromPath("../../challenge.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGameFromImage(image)
+	out, err := g.Run()
+	if err != nil {
+		t.Fatalf("Run() = _, %v, want _, nil", err)
+	}
+	return g, out
+}
+
+func exploreChallenge(t *testing.T) *Map {
+	g, out := startChallenge(t)
+	m, err := Explore(g, out, ExploreOptions{})
+	if err != nil {
+		t.Fatalf("Explore() = _, %v, want _, nil", err)
+	}
+	return m
+}
+
+func TestExplore(t *testing.T) {
+	m := exploreChallenge(t)
+
+	titles := map[string]int{}
+	for _, room := range m.Rooms {
+		titles[room.Title]++
+	}
+
+	// Without a light, the player can't get past the grue, so only the
+	// rooms up to and including the maze are reachable.
+	for title, want := range map[string]int{"Foothills": 2, "Dark cave": 3, "Twisty passages": 8} {
+		if got := titles[title]; got != want {
+			t.Errorf("found %d %q rooms, want %d", got, title, want)
+		}
+	}
+
+	start := m.Rooms[0]
+	if start.Title != "Foothills" || len(start.Items) != 1 || start.Items[0] != "tablet" {
+		t.Errorf("start = %+v, want Foothills with tablet", start)
+	}
+	for _, exit := range start.Exits {
+		if exit.To < 0 {
+			t.Errorf("start exit %v leads nowhere: %v", exit.Name, exit.Note)
+		}
+	}
+
+	halted := 0
+	for _, room := range m.Rooms {
+		for _, exit := range room.Exits {
+			if exit.To < 0 && exit.Note == "halted: You have been eaten by a grue." {
+				halted++
+			}
+		}
+	}
+	if halted == 0 {
+		t.Errorf("no exits lead to the grue")
+	}
+}
+
+func TestExploreBadRoomAddr(t *testing.T) {
+	g, out := startChallenge(t)
+	opts := ExploreOptions{KeyByAddr: true, RoomAddr: 40000}
+	if _, err := Explore(g, out, opts); err == nil {
+		t.Errorf("Explore(%+v) = _, nil, want _, non-nil", opts)
+	}
+}
+
+func TestExploreFailedExit(t *testing.T) {
+	// Prints a room, then fails on the first input by popping an empty
+	// stack.
+	image := []uint16{}
+	for _, c := range "== Pit ==\nA pit.\n\nThere is 1 exit:\n- down\n\nWhat do you do?\n" {
+		image = append(image, instruction.OpOut, uint16(c))
+	}
+	r1 := instruction.RegArg(1)
+	image = append(image, instruction.OpIn, r1, instruction.OpPop, r1)
+
+	g := NewGameFromImage(image)
+	out, err := g.Run()
+	if err != nil {
+		t.Fatalf("Run() = _, %v, want _, nil", err)
+	}
+
+	m, err := Explore(g, out, ExploreOptions{})
+	if err != nil {
+		t.Fatalf("Explore() = _, %v, want _, nil", err)
+	}
+	if exit := m.Rooms[0].Exits[0]; exit.To != -1 || !strings.HasPrefix(exit.Note, "halted:") {
+		t.Errorf("exit = %+v, want halted note", exit)
+	}
+}
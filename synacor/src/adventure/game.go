@@ -0,0 +1,94 @@
+package adventure
+
+import (
+	"errors"
+	"fmt"
+
+	"device"
+	"instruction"
+	"memory"
+	"snapshot"
+	"vm"
+)
+
+// ErrHalted is returned when the game stops running, usually because the
+// player died.
+var ErrHalted = errors.New("vm halted")
+
+// DefaultMaxSteps bounds the number of instructions run per command.
+const DefaultMaxSteps = 50000000
+
+// Game runs the challenge in-process, a command at a time.
+type Game struct {
+	m        *vm.Machine
+	session  *device.Session
+	MaxSteps int
+}
+
+// NewGame creates a game that will resume from snap.
+func NewGame(snap *snapshot.Snapshot) *Game {
+	session := device.NewSession()
+	g := &Game{m: vm.New(session), session: session, MaxSteps: DefaultMaxSteps}
+	g.Restore(snap)
+	return g
+}
+
+// NewGameFromImage creates a game that will start the binary image from
+// the beginning.
+func NewGameFromImage(image []uint16) *Game {
+	snap := &snapshot.Snapshot{}
+	copy(snap.RAM[:], image)
+	return NewGame(snap)
+}
+
+// Save captures the game state. Saves are only meaningful between
+// commands.
+func (g *Game) Save() *snapshot.Snapshot {
+	return g.m.Snapshot(g.session.Pending())
+}
+
+func (g *Game) Restore(snap *snapshot.Snapshot) {
+	g.m.Restore(snap)
+	g.session.SetPending(snap.Input)
+	g.session.TakeOutput()
+}
+
+// RAM returns the game's memory, which may be inspected or modified
+// between commands.
+func (g *Game) RAM() *memory.RAM {
+	return g.m.RAM()
+}
+
+// Run executes until the game needs input, returning the output printed
+// along the way. It returns ErrHalted, along with the output, if the VM
+// halts instead, wrapped with the reason if it failed.
+func (g *Game) Run() (string, error) {
+	if g.m.Halted() {
+		return "", ErrHalted
+	}
+
+	// RAM may have been modified since the last command.
+	g.m.FlushCache()
+
+	steps := 0
+	err := g.m.Run(func(*vm.Step) bool {
+		steps++
+		return steps >= g.MaxSteps
+	})
+
+	switch {
+	case errors.Is(err, instruction.ErrNoInput):
+		return g.session.TakeOutput(), nil
+	case g.m.Halted() && g.m.Err() != nil:
+		return g.session.TakeOutput(), fmt.Errorf("%w: %v", ErrHalted, g.m.Err())
+	case g.m.Halted():
+		return g.session.TakeOutput(), ErrHalted
+	}
+	return g.session.TakeOutput(), fmt.Errorf("no input request after %d steps", g.MaxSteps)
+}
+
+// Command sends cmd to the game, and runs until it wants more input.
+func (g *Game) Command(cmd string) (string, error) {
+	g.session.Send(cmd)
+	return g.Run()
+}
@@ -0,0 +1,204 @@
+package adventure
+
+import (
+	"fmt"
+	"strings"
+
+	"astar"
+)
+
+// Goal is what the planner should achieve. Exactly one of Room or Item
+// must be set.
+type Goal struct {
+	Room string // reach a room with this title
+	Item string // obtain this item
+	Use  bool   // after obtaining Item, use it
+
+	// Execution stops as soon as this appears in the output. If empty,
+	// it defaults to the room header for Room goals and "Taken." for
+	// Item goals. Use goals have no default, and simply stop after the
+	// use command.
+	Text string
+}
+
+func (g Goal) stopText() string {
+	switch {
+	case g.Text != "":
+		return g.Text
+	case g.Room != "":
+		return "== " + g.Room + " =="
+	case !g.Use:
+		return "Taken."
+	default:
+		return ""
+	}
+}
+
+// roomGraph implements astar.Graph over room IDs.
+type roomGraph struct {
+	m       *Map
+	targets map[int]bool
+}
+
+func (g *roomGraph) Neighbors(id int) []int {
+	neighbors := []int{}
+	for _, exit := range g.m.Rooms[id].Exits {
+		if exit.To >= 0 {
+			neighbors = append(neighbors, exit.To)
+		}
+	}
+	return neighbors
+}
+
+func (g *roomGraph) Distance(from, to int) uint {
+	return 1
+}
+
+func (g *roomGraph) Estimate(id int) uint {
+	return 0
+}
+
+func (g *roomGraph) IsGoal(id int) bool {
+	return g.targets[id]
+}
+
+// Locate returns the map room g is in, described by out.
+func (m *Map) Locate(g *Game, out string) (*MapRoom, error) {
+	room, found := ParseRoom(out)
+	if !found {
+		return nil, fmt.Errorf("no room in output")
+	}
+
+	key := m.key(g, room)
+	var match *MapRoom
+	for _, mr := range m.Rooms {
+		if m.roomKey(mr) != key {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("room %v is ambiguous (%d or %d)", room.Title, match.ID, mr.ID)
+		}
+		match = mr
+	}
+	if match == nil {
+		return nil, fmt.Errorf("room %v isn't on the map", room.Title)
+	}
+	return match, nil
+}
+
+// Route returns the commands which move from the from room to the nearest
+// room for which target returns true.
+func (m *Map) Route(from *MapRoom, target func(room *MapRoom) bool) ([]string, *MapRoom, error) {
+	g := &roomGraph{m: m, targets: map[int]bool{}}
+	for _, room := range m.Rooms {
+		if target(room) {
+			g.targets[room.ID] = true
+		}
+	}
+	if len(g.targets) == 0 {
+		return nil, nil, fmt.Errorf("no matching rooms")
+	}
+
+	ids, _, err := astar.Search(from.ID, g, astar.Options[int]{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("no route from %v: %v", from.Title, err)
+	}
+
+	cmds := []string{}
+	for i := 1; i < len(ids); i++ {
+		for _, exit := range m.Rooms[ids[i-1]].Exits {
+			if exit.To == ids[i] {
+				cmds = append(cmds, "go "+exit.Name)
+				break
+			}
+		}
+	}
+
+	return cmds, m.Rooms[ids[len(ids)-1]], nil
+}
+
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan returns the commands which achieve goal from the game's current
+// state. The game is sent look and inv commands to find out where the
+// player is and what they're carrying.
+func (m *Map) Plan(g *Game, goal Goal) ([]string, error) {
+	if (goal.Room == "") == (goal.Item == "") {
+		return nil, fmt.Errorf("exactly one of room or item must be given")
+	}
+
+	out, err := g.Command("look")
+	if err != nil {
+		return nil, fmt.Errorf("look failed: %v", err)
+	}
+	here, err := m.Locate(g, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if goal.Room != "" {
+		cmds, _, err := m.Route(here, func(room *MapRoom) bool {
+			return room.Title == goal.Room
+		})
+		return cmds, err
+	}
+
+	out, err = g.Command("inv")
+	if err != nil {
+		return nil, fmt.Errorf("inv failed: %v", err)
+	}
+	inv, _ := ParseInventory(out)
+
+	cmds := []string{}
+	if !contains(inv, goal.Item) {
+		route, _, err := m.Route(here, func(room *MapRoom) bool {
+			return contains(room.Items, goal.Item)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't get to %v: %v", goal.Item, err)
+		}
+		cmds = append(route, "take "+goal.Item)
+	}
+	if goal.Use {
+		cmds = append(cmds, "use "+goal.Item)
+	}
+	return cmds, nil
+}
+
+// Execute plans a route to goal, and feeds it to the game. It returns the
+// commands sent and the output of the last one. It fails if the commands
+// run out before the goal's stop text appears.
+func (m *Map) Execute(g *Game, goal Goal) ([]string, string, error) {
+	cmds, err := m.Plan(g, goal)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Nothing to do if the goal is already satisfied.
+	if len(cmds) == 0 {
+		return cmds, "", nil
+	}
+
+	stop := goal.stopText()
+	var out string
+	for i, cmd := range cmds {
+		if out, err = g.Command(cmd); err != nil {
+			return cmds[:i+1], out, fmt.Errorf("%v: %v", cmd, err)
+		}
+		if stop != "" && strings.Contains(out, stop) {
+			return cmds[:i+1], out, nil
+		}
+	}
+
+	if stop != "" {
+		return cmds, out, fmt.Errorf("goal text %q never appeared", stop)
+	}
+	return cmds, out, nil
+}
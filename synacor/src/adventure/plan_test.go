@@ -0,0 +1,107 @@
+package adventure
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	m := exploreChallenge(t)
+
+	type testCase struct {
+		goal         Goal
+		expectedCmds []string
+		expectedOut  string
+	}
+
+	tests := []testCase{
+		{
+			goal:         Goal{Item: "tablet", Use: true},
+			expectedCmds: []string{"take tablet", "use tablet"},
+			expectedOut:  "on the tablet",
+		},
+		{
+			goal:         Goal{Room: "Rope bridge"},
+			expectedCmds: []string{"go doorway", "go north", "go north", "go bridge"},
+			expectedOut:  "== Rope bridge ==",
+		},
+		{
+			goal: Goal{Item: "empty lantern"},
+			expectedCmds: []string{"go doorway", "go north", "go north", "go bridge",
+				"go continue", "go down", "go east", "take empty lantern"},
+			expectedOut: "Taken.",
+		},
+		{
+			// Stops as soon as the text appears.
+			goal:         Goal{Room: "Moss cavern", Text: "bridge"},
+			expectedCmds: []string{"go doorway", "go north", "go north"},
+			expectedOut:  "bridge",
+		},
+	}
+
+	for _, test := range tests {
+		g, _ := startChallenge(t)
+		cmds, out, err := m.Execute(g, test.goal)
+		if err != nil || !reflect.DeepEqual(cmds, test.expectedCmds) || !strings.Contains(out, test.expectedOut) {
+			t.Errorf("Execute(%+v) = %v, %q, %v, want %v, output containing %q, nil",
+				test.goal, cmds, out, err, test.expectedCmds, test.expectedOut)
+		}
+	}
+
+	// The tablet is already held, so there's nothing to do.
+	g, _ := startChallenge(t)
+	g.Command("take tablet")
+	if cmds, _, err := m.Execute(g, Goal{Item: "tablet"}); err != nil || len(cmds) != 0 {
+		t.Errorf("Execute(tablet) when held = %v, _, %v, want [], _, nil", cmds, err)
+	}
+
+	if _, _, err := m.Execute(g, Goal{Item: "teleporter"}); err == nil {
+		t.Errorf("Execute(teleporter) = _, _, nil, want _, _, non-nil")
+	}
+}
+
+func TestExecuteKeyByAddr(t *testing.T) {
+	// The challenge keeps the current room's address at 2732.
+	g, out := startChallenge(t)
+	explored, err := Explore(g, out, ExploreOptions{KeyByAddr: true, RoomAddr: 2732})
+	if err != nil {
+		t.Fatalf("Explore() = _, %v, want _, nil", err)
+	}
+
+	var buf bytes.Buffer
+	if err := explored.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadMap(&buf)
+	if err != nil {
+		t.Fatalf("ReadMap() = _, %v, want _, nil", err)
+	}
+
+	g, _ = startChallenge(t)
+	goal := Goal{Item: "empty lantern"}
+	if _, out, err := m.Execute(g, goal); err != nil || !strings.Contains(out, "Taken.") {
+		t.Errorf("Execute(%+v) = _, %q, %v, want _, output containing \"Taken.\", nil", goal, out, err)
+	}
+}
+
+func TestLocateKeyByAddr(t *testing.T) {
+	out := "== Maze ==\nA maze.\n\nThere is 1 exit:\n- north\n\nWhat do you do?\n"
+	m := &Map{KeyByAddr: true, RoomAddr: 100}
+	for id := 0; id < 2; id++ {
+		m.Rooms = append(m.Rooms, &MapRoom{
+			ID:          id,
+			Title:       "Maze",
+			Description: "A maze.",
+			Exits:       []*Exit{{Name: "north", To: 1 - id}},
+			AddrVal:     uint16(10 + id),
+		})
+	}
+
+	g := NewGameFromImage(nil)
+	g.RAM().Write(100, 11)
+	if room, err := m.Locate(g, out); err != nil || room.ID != 1 {
+		t.Errorf("Locate() = %+v, %v, want room 1, nil", room, err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package adventure drives the text adventure in the challenge binary
+// from Go: running it in-process, parsing what it prints, and exploring
+// its map.
+package adventure
+
+import (
+	"regexp"
+	"strings"
+
+	"device"
+)
+
+type Room struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Items       []string `json:"items"`
+	Exits       []string `json:"exits"`
+}
+
+var (
+	titlePattern = regexp.MustCompile(`^== (.*) ==$`)
+	exitsPattern = regexp.MustCompile(`^There (?:is|are) \d+ exits?:$`)
+)
+
+const (
+	itemsHeader     = "Things of interest here:"
+	inventoryHeader = "Your inventory:"
+)
+
+// listItems returns the "- " prefixed lines in lines.
+func listItems(lines []string) []string {
+	items := []string{}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "- ") {
+			items = append(items, strings.TrimPrefix(line, "- "))
+		}
+	}
+	return items
+}
+
+// ParseRoom extracts the last room description in out, returning false if
+// there isn't one.
+func ParseRoom(out string) (*Room, bool) {
+	paras := device.Paragraphs(out)
+
+	start := -1
+	for i, para := range paras {
+		if titlePattern.MatchString(strings.SplitN(para, "\n", 2)[0]) {
+			start = i
+		}
+	}
+	if start == -1 {
+		return nil, false
+	}
+
+	lines := strings.SplitN(paras[start], "\n", 2)
+	room := &Room{
+		Title: titlePattern.FindStringSubmatch(lines[0])[1],
+		Items: []string{},
+		Exits: []string{},
+	}
+	if len(lines) == 2 {
+		room.Description = lines[1]
+	}
+
+	for _, para := range paras[start+1:] {
+		lines := strings.Split(para, "\n")
+		switch {
+		case lines[0] == itemsHeader:
+			room.Items = listItems(lines[1:])
+		case exitsPattern.MatchString(lines[0]):
+			room.Exits = listItems(lines[1:])
+		}
+	}
+
+	return room, true
+}
+
+func roomKey(title, description string, exits []string) string {
+	return strings.Join([]string{title, description, strings.Join(exits, ",")}, "\n")
+}
+
+// Key returns a string identifying the room, for telling whether two
+// descriptions are of the same room. Items aren't included, as they come
+// and go.
+func (r *Room) Key() string {
+	return roomKey(r.Title, r.Description, r.Exits)
+}
+
+// ParseInventory returns the items listed in the output of the inv
+// command, or false if out doesn't contain an inventory.
+func ParseInventory(out string) ([]string, bool) {
+	for _, para := range device.Paragraphs(out) {
+		lines := strings.Split(para, "\n")
+		if lines[0] == inventoryHeader {
+			return listItems(lines[1:]), true
+		}
+	}
+	return nil, false
+}
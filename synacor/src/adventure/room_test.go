@@ -0,0 +1,49 @@
+package adventure
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoom(t *testing.T) {
+	out := `You see no such item here.
+
+What do you do?
+
+
+== Foothills ==
+You find yourself standing at the base of an enormous mountain.
+A sign nearby reads "Keep out!"
+
+Things of interest here:
+- tablet
+
+There are 2 exits:
+- doorway
+- south
+
+What do you do?
+`
+
+	expected := &Room{
+		Title:       "Foothills",
+		Description: "You find yourself standing at the base of an enormous mountain.\nA sign nearby reads \"Keep out!\"",
+		Items:       []string{"tablet"},
+		Exits:       []string{"doorway", "south"},
+	}
+
+	room, found := ParseRoom(out)
+	if !found || !reflect.DeepEqual(room, expected) {
+		t.Errorf("ParseRoom() = %+v, %v, want %+v, true", room, found, expected)
+	}
+
+	out = "== Falling ==\nWhee!\n\nThere is 1 exit:\n- down\n"
+	expected = &Room{Title: "Falling", Description: "Whee!", Items: []string{}, Exits: []string{"down"}}
+	if room, found := ParseRoom(out); !found || !reflect.DeepEqual(room, expected) {
+		t.Errorf("ParseRoom() = %+v, %v, want %+v, true", room, found, expected)
+	}
+
+	if room, found := ParseRoom("Taken.\n\nWhat do you do?\n"); found {
+		t.Errorf("ParseRoom() = %+v, true, want _, false", room)
+	}
+}
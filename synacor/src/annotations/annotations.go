@@ -0,0 +1,396 @@
+// Package annotations keeps everything known about the challenge binary --
+// symbols, comments, and cross-references -- in one file, keyed by
+// address. It supersedes separate symtab and comments files, which drift
+// apart because comments refer to symbols by name.
+//
+// The file format is line-oriented, with # comments and blank lines
+// ignored:
+//
+//	sym NAME START[-END] [KIND]   a symbol (see symtab for kinds)
+//	note ADDR TEXT                a comment shown on the line at ADDR
+//	block START[-END]             a block comment shown above START,
+//	| TEXT                        one line per |
+//	xref FROM TO KIND             FROM refers to TO (call, jump, read, write)
+//
+// Write emits the records in address order.
+package annotations
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"comment"
+	"disasm"
+	"instruction"
+	"symtab"
+)
+
+type XRefKind int
+
+const (
+	XRefCall XRefKind = iota
+	XRefJump
+	XRefRead
+	XRefWrite
+)
+
+var xrefKindNames = []string{"call", "jump", "read", "write"}
+
+func (k XRefKind) String() string {
+	if int(k) < len(xrefKindNames) {
+		return xrefKindNames[k]
+	}
+	return fmt.Sprintf("xref%d", int(k))
+}
+
+func parseXRefKind(str string) (XRefKind, error) {
+	for i, name := range xrefKindNames {
+		if name == str {
+			return XRefKind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown xref kind %v", str)
+}
+
+type XRef struct {
+	From, To uint16
+	Kind     XRefKind
+}
+
+// DB is an annotation store. It implements comment.Registry, and its
+// symbols are available as a symtab.SymTab.
+type DB struct {
+	Symbols symtab.SymTab
+
+	singles map[int]string
+	blocks  map[int]*comment.Comment
+	xrefs   map[XRef]bool
+}
+
+func New() *DB {
+	return &DB{
+		Symbols: symtab.New(),
+		singles: map[int]string{},
+		blocks:  map[int]*comment.Comment{},
+		xrefs:   map[XRef]bool{},
+	}
+}
+
+func (db *DB) SetSingle(addr int, text string) {
+	db.singles[addr] = text
+}
+
+func (db *DB) SetBlock(start, end int, lines []string) {
+	db.blocks[start] = &comment.Comment{
+		Type:  comment.Block,
+		Start: start,
+		End:   end,
+		Lines: append([]string{}, lines...),
+	}
+}
+
+func (db *DB) AddXRef(x XRef) {
+	db.xrefs[x] = true
+}
+
+func (db *DB) GetSingle(line int) (string, bool) {
+	text, found := db.singles[line]
+	return text, found
+}
+
+func (db *DB) GetBlock(line int) *comment.Comment {
+	return db.blocks[line]
+}
+
+func (db *DB) Comments() []*comment.Comment {
+	out := []*comment.Comment{}
+	for addr, text := range db.singles {
+		out = append(out, &comment.Comment{Type: comment.Single, Start: addr, End: addr, Lines: []string{text}})
+	}
+	for _, block := range db.blocks {
+		out = append(out, block)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Start != out[j].Start {
+			return out[i].Start < out[j].Start
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
+
+// XRefs returns the cross-references ordered by source, then destination.
+func (db *DB) XRefs() []XRef {
+	out := []XRef{}
+	for x := range db.xrefs {
+		out = append(out, x)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return out
+}
+
+// XRefsTo returns the cross-references whose destination is addr.
+func (db *DB) XRefsTo(addr uint16) []XRef {
+	out := []XRef{}
+	for _, x := range db.XRefs() {
+		if x.To == addr {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// Import adds the symbols from st and the comments from cReg.
+func (db *DB) Import(st symtab.SymTab, cReg comment.Registry) error {
+	for _, ent := range st.Entries() {
+		if err := db.Symbols.AddEntry(ent); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range cReg.Comments() {
+		if c.Type == comment.Single {
+			db.SetSingle(c.Start, c.Lines[0])
+		} else {
+			db.SetBlock(c.Start, c.End, c.Lines)
+		}
+	}
+	return nil
+}
+
+// AddTraceXRefs adds cross-references for the literal jump and call
+// targets and memory operands of the traced instructions.
+func (db *DB) AddTraceXRefs(tr *disasm.Trace) {
+	for addr, inst := range tr.Insts {
+		if tgt, ok := inst.StaticTarget(); ok {
+			kind := XRefJump
+			if inst.Op.Code == instruction.OpCall {
+				kind = XRefCall
+			}
+			db.AddXRef(XRef{From: addr, To: tgt, Kind: kind})
+		}
+
+		switch inst.Op.Code {
+		case instruction.OpRmem:
+			if src := inst.Args[1]; !instruction.IsReg(src) {
+				db.AddXRef(XRef{From: addr, To: src, Kind: XRefRead})
+			}
+		case instruction.OpWmem:
+			if dest := inst.Args[0]; !instruction.IsReg(dest) {
+				db.AddXRef(XRef{From: addr, To: dest, Kind: XRefWrite})
+			}
+		}
+	}
+}
+
+func parseRange(str string) (int, int, error) {
+	parts := strings.SplitN(str, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad address %v", parts[0])
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return 0, 0, fmt.Errorf("bad address %v", parts[1])
+		}
+	}
+	return int(start), int(end), nil
+}
+
+var notePattern = regexp.MustCompile(`^note\s+(\S+)(?: (.*))?$`)
+
+func Read(r io.Reader) (*DB, error) {
+	db := New()
+
+	var block *comment.Comment
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "|") {
+			if block == nil {
+				return nil, fmt.Errorf("%d: | outside of block", lineNum)
+			}
+			text := strings.TrimPrefix(line[1:], " ")
+			block.Lines = append(block.Lines, text)
+			continue
+		}
+		block = nil
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := db.readRecord(line, &block); err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) readRecord(line string, block **comment.Comment) error {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "sym":
+		if len(fields) != 3 && len(fields) != 4 {
+			return fmt.Errorf("want sym NAME START[-END] [KIND]")
+		}
+		start, end, err := parseRange(fields[2])
+		if err != nil {
+			return err
+		}
+		kind := symtab.KindCode
+		if len(fields) == 4 {
+			if kind, err = symtab.ParseKind(fields[3]); err != nil {
+				return err
+			}
+		}
+		return db.Symbols.AddEntry(symtab.SymEnt{Name: fields[1], Start: uint(start), End: uint(end), Kind: kind})
+
+	case "note":
+		parts := notePattern.FindStringSubmatch(line)
+		if parts == nil {
+			return fmt.Errorf("want note ADDR TEXT")
+		}
+		addr, _, err := parseRange(parts[1])
+		if err != nil {
+			return err
+		}
+		if _, found := db.singles[addr]; found {
+			return fmt.Errorf("duplicate note at %d", addr)
+		}
+		db.SetSingle(addr, parts[2])
+
+	case "block":
+		if len(fields) != 2 {
+			return fmt.Errorf("want block START[-END]")
+		}
+		start, end, err := parseRange(fields[1])
+		if err != nil {
+			return err
+		}
+		if _, found := db.blocks[start]; found {
+			return fmt.Errorf("duplicate block at %d", start)
+		}
+		db.SetBlock(start, end, nil)
+		*block = db.blocks[start]
+
+	case "xref":
+		if len(fields) != 4 {
+			return fmt.Errorf("want xref FROM TO KIND")
+		}
+		from, _, err := parseRange(fields[1])
+		if err != nil {
+			return err
+		}
+		to, _, err := parseRange(fields[2])
+		if err != nil {
+			return err
+		}
+		kind, err := parseXRefKind(fields[3])
+		if err != nil {
+			return err
+		}
+		db.AddXRef(XRef{From: uint16(from), To: uint16(to), Kind: kind})
+
+	default:
+		return fmt.Errorf("unknown record %v", fields[0])
+	}
+
+	return nil
+}
+
+func ReadFromPath(path string) (*DB, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return Read(fp)
+}
+
+func rangeStr(start, end int) string {
+	if start == end {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
+
+// Write writes the database. Records are grouped by address, with
+// symbols first, then comments, then cross-references from the address.
+func (db *DB) Write(w io.Writer) error {
+	lines := map[int][]string{}
+	for _, ent := range db.Symbols.Entries() {
+		line := fmt.Sprintf("sym %s %s", ent.Name, rangeStr(int(ent.Start), int(ent.End)))
+		if ent.Kind != symtab.KindCode {
+			line += " " + ent.Kind.String()
+		}
+		lines[int(ent.Start)] = append(lines[int(ent.Start)], line)
+	}
+	for _, c := range db.Comments() {
+		if c.Type == comment.Single {
+			lines[c.Start] = append(lines[c.Start], "note "+strconv.Itoa(c.Start)+" "+c.Lines[0])
+			continue
+		}
+
+		lines[c.Start] = append(lines[c.Start], "block "+rangeStr(c.Start, c.End))
+		for _, text := range c.Lines {
+			lines[c.Start] = append(lines[c.Start], strings.TrimRight("| "+text, " "))
+		}
+	}
+	for _, x := range db.XRefs() {
+		lines[int(x.From)] = append(lines[int(x.From)], fmt.Sprintf("xref %d %d %v", x.From, x.To, x.Kind))
+	}
+
+	addrs := []int{}
+	for addr := range lines {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	bw := bufio.NewWriter(w)
+	for i, addr := range addrs {
+		// Symbols and blocks start new paragraphs.
+		if first := lines[addr][0]; i > 0 && !strings.HasPrefix(first, "note ") && !strings.HasPrefix(first, "xref ") {
+			fmt.Fprintln(bw)
+		}
+		for _, line := range lines[addr] {
+			fmt.Fprintln(bw, line)
+		}
+	}
+	return bw.Flush()
+}
+
+func (db *DB) WriteToPath(path string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,149 @@
+package annotations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"comment"
+	"disasm"
+	"instruction"
+	"symtab"
+)
+
+const testDB = `# test
+sym main 0-9
+note 0 start here
+block 3-5
+| loop:
+|
+|   body
+xref 3 0 jump
+
+sym buf 100-120 buffer
+sym field 104 data
+note 104
+`
+
+func TestReadWrite(t *testing.T) {
+	db, err := Read(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatalf("Read() = %v, want nil", err)
+	}
+
+	expectedSyms := []symtab.SymEnt{
+		{Name: "main", Start: 0, End: 9},
+		{Name: "buf", Start: 100, End: 120, Kind: symtab.KindBuffer},
+		{Name: "field", Start: 104, End: 104, Kind: symtab.KindData},
+	}
+	if syms := db.Symbols.Entries(); !reflect.DeepEqual(syms, expectedSyms) {
+		t.Errorf("Symbols = %v, want %v", syms, expectedSyms)
+	}
+
+	if text, found := db.GetSingle(0); !found || text != "start here" {
+		t.Errorf(`GetSingle(0) = %q, %v, want "start here", true`, text, found)
+	}
+	if text, found := db.GetSingle(104); !found || text != "" {
+		t.Errorf(`GetSingle(104) = %q, %v, want "", true`, text, found)
+	}
+	expectedBlock := &comment.Comment{Type: comment.Block, Start: 3, End: 5, Lines: []string{"loop:", "", "  body"}}
+	if block := db.GetBlock(3); !reflect.DeepEqual(block, expectedBlock) {
+		t.Errorf("GetBlock(3) = %v, want %v", block, expectedBlock)
+	}
+
+	expectedXRefs := []XRef{{From: 3, To: 0, Kind: XRefJump}}
+	if xrefs := db.XRefsTo(0); !reflect.DeepEqual(xrefs, expectedXRefs) {
+		t.Errorf("XRefsTo(0) = %v, want %v", xrefs, expectedXRefs)
+	}
+
+	var out strings.Builder
+	if err := db.Write(&out); err != nil {
+		t.Fatalf("Write() = %v, want nil", err)
+	}
+	want := "sym main 0-9\nnote 0 start here\n\nblock 3-5\n| loop:\n|\n|   body\nxref 3 0 jump\n\n" +
+		"sym buf 100-120 buffer\n\nsym field 104 data\nnote 104 \n"
+	if out.String() != want {
+		t.Errorf("Write() wrote %q, want %q", out.String(), want)
+	}
+
+	reread, err := Read(strings.NewReader(out.String()))
+	if err != nil || !reflect.DeepEqual(reread, db) {
+		t.Errorf("Read(Write()) = %v, %v, want %v, nil", reread, err, db)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, in := range []string{
+		"| orphan",
+		"sym a",
+		"sym a 1 nosuchkind",
+		"sym a 1-5\nsym b 3-7",
+		"note x hello",
+		"note 1 a\nnote 1 b",
+		"block 1\nblock 1",
+		"xref 1 2 fly",
+		"what 1",
+	} {
+		if _, err := Read(strings.NewReader(in)); err == nil {
+			t.Errorf("Read(%q) = nil, want error", in)
+		}
+	}
+}
+
+// Importing the symtab and comments files gives a database that the
+// disassembler renders identically.
+func TestImport(t *testing.T) {
+	st, err := symtab.ReadFromPath("../../symtab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cReg, err := comment.ReadFromPath("../../comments", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := New()
+	if err := db.Import(st, cReg); err != nil {
+		t.Fatalf("Import() = %v, want nil", err)
+	}
+
+	var out strings.Builder
+	if err := db.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	reread, err := Read(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Read(Write()) = %v, want nil", err)
+	}
+
+	if !reflect.DeepEqual(reread.Symbols.Entries(), st.Entries()) {
+		t.Errorf("imported symbols differ")
+	}
+	if !reflect.DeepEqual(reread.Comments(), cReg.Comments()) {
+		t.Errorf("imported comments differ")
+	}
+}
+
+func TestAddTraceXRefs(t *testing.T) {
+	image := []uint16{
+		instruction.OpCall, 6, // 0
+		instruction.OpRmem, instruction.RegArg(1), 20, // 2
+		instruction.OpHlt,                             // 5
+		instruction.OpWmem, 21, instruction.RegArg(1), // 6
+		instruction.OpJf, instruction.RegArg(1), 6, // 9
+		instruction.OpJmp, instruction.RegArg(2), // 12
+	}
+
+	db := New()
+	db.AddTraceXRefs(disasm.NewTrace(image, []uint16{0}))
+
+	expected := []XRef{
+		{From: 0, To: 6, Kind: XRefCall},
+		{From: 2, To: 20, Kind: XRefRead},
+		{From: 6, To: 21, Kind: XRefWrite},
+		{From: 9, To: 6, Kind: XRefJump},
+	}
+	if xrefs := db.XRefs(); !reflect.DeepEqual(xrefs, expected) {
+		t.Errorf("XRefs() = %v, want %v", xrefs, expected)
+	}
+}
@@ -0,0 +1,404 @@
+// Package assembler turns assembly text into binary images.
+//
+// The syntax is the one produced by the disassembler (see disasm), so
+// disassembler output can be edited and reassembled. Each line contains an
+// optional label followed by an optional statement, and may end with a //
+// comment.
+//
+// Labels are followed by a colon. A bare name (foo:) defines a symbol at
+// the current address. A number (1234:) or a name with an offset (foo+3:)
+// asserts the current address, which is how disassembler line prefixes are
+// treated.
+//
+// Statements are instructions or directives. Instruction arguments are
+// separated by commas and/or spaces, and may be registers (r1..r8),
+// numbers (decimal or 0x-prefixed hex), character literals ('a'), or
+// symbols with optional offsets (foo, foo+3). Anything enclosed in <> is
+// ignored, as it is a disassembler annotation. To match disassembler
+// output, a single-character argument to out is that character, so "out 5"
+// writes '5'. out also accepts a string literal, which expands to one out
+// per character.
+//
+// Directives:
+//
+//	.org addr          continue assembling at addr
+//	.word v, v, ...    raw words
+//	.ascii "str"       one word per character
+//	.string "str"      a length-prefixed string, as used by the challenge
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"instruction"
+	"patterns"
+	"symtab"
+)
+
+type Program struct {
+	Origin uint16   // the address of Words[0]
+	Words  []uint16 // unwritten gaps are zero-filled
+	Labels map[string]uint16
+}
+
+// item is a parsed statement, awaiting symbol resolution.
+type item struct {
+	lineNum int
+	addr    uint16
+
+	op       *instruction.Op
+	args     []string
+	literals []uint16 // pre-resolved words (strings, out literals)
+
+	words []string // .word arguments
+}
+
+func (it *item) size() int {
+	switch {
+	case it.literals != nil:
+		return len(it.literals)
+	case it.words != nil:
+		return len(it.words)
+	default:
+		return 1 + it.op.NumArgs
+	}
+}
+
+type assembler struct {
+	symTab symtab.SymTab
+	labels map[string]uint16
+	items  []*item
+}
+
+var (
+	labelPattern      = regexp.MustCompile(`^(` + patterns.NameWithOptionalOffset + `|\d+):(?:\s+|$)`)
+	annotationPattern = regexp.MustCompile(`<[^>]*>`)
+	argSepPattern     = regexp.MustCompile(`[\s,]+`)
+	regPattern        = regexp.MustCompile(`^r(\d+)$`)
+)
+
+// closingQuote returns the index of the quote closing a literal which
+// starts at str[0], or -1 if the literal isn't closed.
+func closingQuote(str string) int {
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case str[0]:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a trailing // comment, ignoring any inside quoted
+// literals. Unclosed quotes (as in "out \"") don't start literals.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"', '\'':
+			if end := closingQuote(line[i:]); end > 0 {
+				i += end
+			}
+		case '/':
+			if strings.HasPrefix(line[i:], "//") {
+				return line[0:i]
+			}
+		}
+	}
+	return line
+}
+
+func parseString(str string) ([]uint16, error) {
+	s, err := strconv.Unquote(strings.TrimSpace(str))
+	if err != nil {
+		return nil, fmt.Errorf("bad string %v: %v", str, err)
+	}
+
+	out := []uint16{}
+	for _, r := range s {
+		out = append(out, uint16(r))
+	}
+	return out, nil
+}
+
+// parseOutArg handles the special cases for out arguments. It returns nil
+// if the argument should be resolved normally.
+func parseOutArg(rest string) ([]uint16, error) {
+	// The disassembler renders a space as "out  ", which looks like an
+	// argument-less out once the surrounding whitespace is gone.
+	if len(rest) > 0 && strings.TrimSpace(rest) == "" {
+		return []uint16{instruction.OpOut, uint16(' ')}, nil
+	}
+
+	rest = strings.TrimSpace(rest)
+	if utf8.RuneCountInString(rest) == 1 {
+		r, _ := utf8.DecodeRuneInString(rest)
+		return []uint16{instruction.OpOut, uint16(r)}, nil
+	}
+
+	if strings.HasPrefix(rest, `"`) {
+		chars, err := parseString(rest)
+		if err != nil {
+			return nil, err
+		}
+		out := []uint16{}
+		for _, c := range chars {
+			out = append(out, instruction.OpOut, c)
+		}
+		return out, nil
+	}
+
+	return nil, nil
+}
+
+func (a *assembler) parseLine(lineNum int, line string, addr uint16) (uint16, error) {
+	line = stripComment(line)
+
+	trimmed := strings.TrimLeft(line, " \t")
+	if parts := labelPattern.FindStringSubmatch(trimmed); parts != nil {
+		if err := a.label(parts[1], addr); err != nil {
+			return 0, err
+		}
+		line = trimmed[len(parts[0]):]
+	}
+
+	if strings.TrimSpace(line) == "" {
+		return addr, nil
+	}
+
+	line = strings.TrimLeft(line, " \t")
+	fields := strings.SplitN(line, " ", 2)
+	name, rest := fields[0], ""
+	if len(fields) > 1 {
+		rest = fields[1]
+	}
+
+	it := &item{lineNum: lineNum, addr: addr}
+
+	switch name {
+	case ".org":
+		org, err := strconv.ParseUint(strings.TrimSpace(rest), 0, 16)
+		if err != nil {
+			return 0, fmt.Errorf("bad .org address %v", rest)
+		}
+		return uint16(org), nil
+
+	case ".word":
+		it.words = splitArgs(rest)
+		if len(it.words) == 0 {
+			return 0, fmt.Errorf(".word needs values")
+		}
+
+	case ".ascii", ".string":
+		chars, err := parseString(rest)
+		if err != nil {
+			return 0, err
+		}
+		if name == ".string" {
+			chars = append([]uint16{uint16(len(chars))}, chars...)
+		}
+		it.literals = chars
+
+	default:
+		op, found := instruction.LookupOpName(name)
+		if !found {
+			return 0, fmt.Errorf("unknown instruction %v", name)
+		}
+		it.op = &op
+
+		if op.Code == instruction.OpOut {
+			lits, err := parseOutArg(rest)
+			if err != nil {
+				return 0, err
+			}
+			it.literals = lits
+		}
+
+		if it.literals == nil {
+			it.args = splitArgs(rest)
+			if len(it.args) != op.NumArgs {
+				return 0, fmt.Errorf("%v wants %d args, got %d",
+					name, op.NumArgs, len(it.args))
+			}
+		}
+	}
+
+	a.items = append(a.items, it)
+
+	next := int(addr) + it.size()
+	if next > 65536 {
+		return 0, fmt.Errorf("address overflow")
+	}
+	return uint16(next), nil
+}
+
+func splitArgs(str string) []string {
+	str = strings.TrimSpace(annotationPattern.ReplaceAllString(str, ""))
+	if str == "" {
+		return []string{}
+	}
+	return argSepPattern.Split(str, -1)
+}
+
+func (a *assembler) label(label string, addr uint16) error {
+	if parts := patterns.NameWithOptionalOffsetPattern.FindStringSubmatch(label); parts != nil && parts[2] == "" {
+		if _, err := strconv.Atoi(label); err != nil {
+			// A bare name defines a label.
+			if _, found := a.labels[label]; found {
+				return fmt.Errorf("duplicate label %v", label)
+			}
+			a.labels[label] = addr
+			return nil
+		}
+	}
+
+	want, err := a.resolve(label, false)
+	if err != nil {
+		return err
+	}
+	if want != addr {
+		return fmt.Errorf("label %v is at %d, but current address is %d", label, want, addr)
+	}
+	return nil
+}
+
+// resolve turns an argument into a word. Symbols are looked up in the
+// labels defined so far and in the symbol table.
+func (a *assembler) resolve(arg string, regOK bool) (uint16, error) {
+	if parts := regPattern.FindStringSubmatch(arg); parts != nil {
+		num, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || num < 1 || num > 8 {
+			return 0, fmt.Errorf("bad register %v", arg)
+		}
+		if !regOK {
+			return 0, fmt.Errorf("register %v not allowed here", arg)
+		}
+		return instruction.RegArg(uint(num)), nil
+	}
+
+	if val, err := strconv.ParseUint(arg, 0, 16); err == nil {
+		return uint16(val), nil
+	}
+
+	if strings.HasPrefix(arg, "'") {
+		chars, err := parseString(`"` + strings.Trim(arg, "'") + `"`)
+		if err != nil || len(chars) != 1 {
+			return 0, fmt.Errorf("bad character literal %v", arg)
+		}
+		return chars[0], nil
+	}
+
+	parts := patterns.NameWithOptionalOffsetPattern.FindStringSubmatch(arg)
+	if parts == nil {
+		return 0, fmt.Errorf("unable to parse argument %v", arg)
+	}
+	symName, offStr := parts[1], parts[2]
+
+	addr, found := a.labels[symName]
+	if !found {
+		ent, found := a.symTab.LookupName(symName)
+		if !found {
+			return 0, fmt.Errorf("unknown symbol %v", symName)
+		}
+		addr = uint16(ent.Start)
+	}
+
+	if offStr == "" {
+		return addr, nil
+	}
+	off, err := strconv.ParseUint(offStr, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad offset in %v", arg)
+	}
+	return addr + uint16(off), nil
+}
+
+func (a *assembler) encode(it *item) ([]uint16, error) {
+	if it.literals != nil {
+		return it.literals, nil
+	}
+
+	if it.words != nil {
+		out := []uint16{}
+		for _, w := range it.words {
+			val, err := a.resolve(w, true)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, val)
+		}
+		return out, nil
+	}
+
+	out := []uint16{it.op.Code}
+	for i, arg := range it.args {
+		val, err := a.resolve(arg, true)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && it.op.RegDest && val <= 32767 {
+			return nil, fmt.Errorf("%v needs a register destination", it.op.Name)
+		}
+		out = append(out, val)
+	}
+	return out, nil
+}
+
+// Assemble reads assembly text, placing the first statement at org. Symbols
+// not defined by labels in the text are looked up in st.
+func Assemble(r io.Reader, org uint16, st symtab.SymTab) (*Program, error) {
+	a := &assembler{
+		symTab: st,
+		labels: map[string]uint16{},
+	}
+
+	addr := org
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var err error
+		if addr, err = a.parseLine(lineNum, scanner.Text(), addr); err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	prog := &Program{
+		Origin: org,
+		Words:  []uint16{},
+		Labels: a.labels,
+	}
+	if len(a.items) == 0 {
+		return prog, nil
+	}
+
+	lo, hi := int(a.items[0].addr), 0
+	for _, it := range a.items {
+		if int(it.addr) < lo {
+			lo = int(it.addr)
+		}
+		if end := int(it.addr) + it.size(); end > hi {
+			hi = end
+		}
+	}
+	prog.Origin = uint16(lo)
+	prog.Words = make([]uint16, hi-lo)
+
+	for _, it := range a.items {
+		words, err := a.encode(it)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", it.lineNum, err)
+		}
+		copy(prog.Words[int(it.addr)-lo:], words)
+	}
+
+	return prog, nil
+}
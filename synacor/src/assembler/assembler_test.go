@@ -0,0 +1,149 @@
+package assembler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"comment"
+	"disasm"
+	"symtab"
+	"util"
+)
+
+func TestAssemble(t *testing.T) {
+	st := symtab.New()
+	st.Add("print_string", 1518, 1527)
+
+	in := `
+		// a comment
+		start:  set r1 msg              // trailing comment
+		        call print_string
+		        jt r1 start <start>
+		start+8: add r2, r1, 0x10
+		        out "hi"
+		        out A
+		        out 5
+		        out 0x0a
+		        out r3
+		124:    out                     
+		        hlt
+		msg:    .string "a//b"
+		        .word 32768, 'c', msg+1
+		        .ascii "z"
+	`
+
+	prog, err := Assemble(strings.NewReader(in), 100, st)
+	if err != nil {
+		t.Fatalf("Assemble() = _, %v, want _, nil", err)
+	}
+
+	expected := &Program{
+		Origin: 100,
+		Words: []uint16{
+			1, 32768, 127, // 100: set r1 msg
+			17, 1518, // 103: call print_string
+			7, 32768, 100, // 105: jt r1 start
+			9, 32769, 32768, 16, // 108: add r2, r1, 0x10
+			19, 'h', 19, 'i', // 112: out "hi"
+			19, 'A', // 116
+			19, '5', // 118
+			19, 10, // 120
+			19, 32770, // 122
+			19, ' ', // 124
+			0,                     // 126: hlt
+			4, 'a', '/', '/', 'b', // 127: msg
+			32768, 'c', 128, // 132
+			'z', // 135
+		},
+		Labels: map[string]uint16{"start": 100, "msg": 127},
+	}
+
+	if !reflect.DeepEqual(prog, expected) {
+		t.Errorf("Assemble() = %+v, want %+v", prog, expected)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []string{
+		"foo r1",
+		"set 5 r1",
+		"add r1, r2",
+		"set r9 1",
+		"jmp nowhere",
+		"5: nop",
+		"a: nop\na: nop",
+		".string unquoted",
+	}
+
+	for _, test := range tests {
+		if _, err := Assemble(strings.NewReader(test), 0, &symtab.NoEntriesSymTab{}); err == nil {
+			t.Errorf("Assemble(%q) = _, nil, want _, non-nil", test)
+		}
+	}
+}
+
+// TestRoundTrip verifies that disassembling the challenge binary and
+// reassembling the result reproduces the binary exactly.
+func TestRoundTrip(t *testing.T) {
+	const binPath = "../../challenge.bin"
+
+	orig, err := util.ReadWordsFromPath(binPath)
+	if err != nil {
+		t.Fatalf("failed to read %v: %v", binPath, err)
+	}
+
+	st, err := symtab.ReadFromPath("../../symtab")
+	if err != nil {
+		t.Fatalf("failed to read symtab: %v", err)
+	}
+	cReg, err := comment.ReadFromPath("../../comments", st)
+	if err != nil {
+		t.Fatalf("failed to read comments: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		st    symtab.SymTab
+		cReg  comment.Registry
+		trace bool
+	}{
+		{"plain", &symtab.NoEntriesSymTab{}, &comment.NullRegistry{}, false},
+		{"annotated", st, cReg, false},
+		{"trace", &symtab.NoEntriesSymTab{}, &comment.NullRegistry{}, true},
+		{"trace_annotated", st, cReg, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bio, err := util.NewBinIO(binPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bio.Close()
+
+			var dis bytes.Buffer
+			if test.trace {
+				tr := disasm.NewTrace(orig, []uint16{0})
+				disasm.DumpTrace(&dis, tr, test.st, test.cReg, disasm.Options{Len: -1})
+			} else {
+				disasm.Dump(&dis, bio, test.st, test.cReg, disasm.Options{Len: -1})
+			}
+
+			prog, err := Assemble(&dis, 0, test.st)
+			if err != nil {
+				t.Fatalf("Assemble() = _, %v, want _, nil", err)
+			}
+
+			if !reflect.DeepEqual(prog.Words, orig) {
+				for i := range orig {
+					if i >= len(prog.Words) || prog.Words[i] != orig[i] {
+						t.Fatalf("mismatch at %d", i)
+					}
+				}
+				t.Fatalf("length mismatch: got %d, want %d", len(prog.Words), len(orig))
+			}
+		})
+	}
+}
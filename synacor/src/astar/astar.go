@@ -6,72 +6,147 @@
 package astar
 
 import (
+	"container/heap"
+	"errors"
 	"math"
-
-	"github.com/google/btree"
+	"time"
 )
 
-type ClientInterface interface {
-	AllNeighbors(start string) []string
-	EstimateDistance(start, end string) uint
-	NeighborDistance(n1, n2 string) uint
-	GoalReached(cand, goal string) bool
+// Graph describes a search space over states of type S.
+type Graph[S comparable] interface {
+	Neighbors(s S) []S
+	Distance(from, to S) uint
+
+	// Estimate returns a lower bound on the cost of reaching the
+	// nearest goal from s.
+	Estimate(s S) uint
+
+	IsGoal(s S) bool
 }
 
-type scoreMap map[string]uint
+type Options[S comparable] struct {
+	// If non-zero, the search fails with ErrBudgetExhausted after
+	// expanding this many nodes without reaching a goal.
+	MaxExpansions int
+
+	// If set, called with each node as it's expanded, for watching the
+	// search progress.
+	OnExpand func(s S, gScore, fScore uint)
+
+	// If set, filled in with statistics about the search.
+	Stats *Stats
+}
+
+type Stats struct {
+	Expanded    int // nodes taken from the open set
+	MaxFrontier int // largest size of the open set
+	Elapsed     time.Duration
+}
+
+var (
+	ErrNoPath          = errors.New("no path")
+	ErrBudgetExhausted = errors.New("expansion budget exhausted")
+)
 
-func (m *scoreMap) GetWithDefault(key string, def uint) uint {
-	if v, found := (*m)[key]; found {
+type scoreMap[S comparable] map[S]uint
+
+func (m scoreMap[S]) GetWithDefault(key S, def uint) uint {
+	if v, found := m[key]; found {
 		return v
-	} else {
-		return math.MaxUint32
 	}
+	return def
 }
 
-type fScoreItem struct {
-	Name  string
-	Value uint
+type openItem[S comparable] struct {
+	state  S
+	fScore uint
+	seq    uint64
+	index  int
 }
 
-func (i *fScoreItem) Less(x btree.Item) bool {
-	than := x.(*fScoreItem)
+// openHeap implements heap.Interface. Items are ordered by fScore, then by
+// insertion order.
+type openHeap[S comparable] []*openItem[S]
+
+func (h openHeap[S]) Len() int { return len(h) }
 
-	// We implement greater-than because we want the tree to store small-to-large
-	if i.Value > than.Value {
-		return true
-	} else if i.Value < than.Value {
-		return false
-	} else {
-		return i.Name > than.Name
+func (h openHeap[S]) Less(i, j int) bool {
+	if h[i].fScore != h[j].fScore {
+		return h[i].fScore < h[j].fScore
 	}
+	return h[i].seq < h[j].seq
 }
 
-type fScoreMap struct {
-	btree *btree.BTree
+func (h openHeap[S]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
 }
 
-func newFScoreMap() *fScoreMap {
-	return &fScoreMap{
-		btree: btree.New(2000),
-	}
+func (h *openHeap[S]) Push(x interface{}) {
+	item := x.(*openItem[S])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *openHeap[S]) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	item.index = -1
+	return item
+}
+
+// openSet is a priority queue of the nodes awaiting expansion, indexed by
+// node so scores can be lowered in place.
+type openSet[S comparable] struct {
+	heap    openHeap[S]
+	items   map[S]*openItem[S]
+	nextSeq uint64
 }
 
-func (m *fScoreMap) Walk(visitor func(item *fScoreItem) bool) {
-	m.btree.Descend(func(item btree.Item) bool {
-		return visitor(item.(*fScoreItem))
-	})
+func newOpenSet[S comparable]() *openSet[S] {
+	return &openSet[S]{items: map[S]*openItem[S]{}}
 }
 
-func (m *fScoreMap) Set(name string, value uint) {
-	m.btree.ReplaceOrInsert(&fScoreItem{Name: name, Value: value})
+func (o *openSet[S]) Len() int {
+	return len(o.heap)
 }
 
-func (m *fScoreMap) Delete(name string, value uint) {
-	m.btree.Delete(&fScoreItem{Name: name, Value: value})
+func (o *openSet[S]) Contains(s S) bool {
+	_, found := o.items[s]
+	return found
 }
 
-func reconstructPath(cameFrom map[string]string, current string) []string {
-	totalPath := []string{current}
+// Set adds s with the given score, or updates its score if it's already
+// present. An updated node is ordered as though newly inserted among nodes
+// with the same score.
+func (o *openSet[S]) Set(s S, fScore uint) {
+	seq := o.nextSeq
+	o.nextSeq++
+
+	if item, found := o.items[s]; found {
+		item.fScore = fScore
+		item.seq = seq
+		heap.Fix(&o.heap, item.index)
+		return
+	}
+
+	item := &openItem[S]{state: s, fScore: fScore, seq: seq}
+	o.items[s] = item
+	heap.Push(&o.heap, item)
+}
+
+// PopMin removes and returns the node with the lowest score.
+func (o *openSet[S]) PopMin() (S, uint) {
+	item := heap.Pop(&o.heap).(*openItem[S])
+	delete(o.items, item.state)
+	return item.state, item.fScore
+}
+
+func reconstructPath[S comparable](cameFrom map[S]S, current S) []S {
+	totalPath := []S{current}
 	for {
 		next, found := cameFrom[current]
 		if !found {
@@ -82,93 +157,105 @@ func reconstructPath(cameFrom map[string]string, current string) []string {
 		current = next
 
 	}
+
+	// Return the path start-first.
+	for i, j := 0, len(totalPath)-1; i < j; i, j = i+1, j-1 {
+		totalPath[i], totalPath[j] = totalPath[j], totalPath[i]
+	}
 	return totalPath
 }
 
-func AStar(start, goal string, client ClientInterface) []string {
-	//logger.LogF("astar start %v goal %v\n", start, goal)
+// Search finds the cheapest path from start to any goal in g. The path is
+// returned start-first, along with its cost.
+func Search[S comparable](start S, g Graph[S], opts Options[S]) ([]S, uint, error) {
+	stats := opts.Stats
+	if stats == nil {
+		stats = &Stats{}
+	}
+	*stats = Stats{}
+	startTime := time.Now()
+	defer func() { stats.Elapsed = time.Since(startTime) }()
 
-	closedSet := map[string]bool{}
-	openSet := map[string]bool{start: true}
-	cameFrom := map[string]string{}
+	closedSet := map[S]bool{}
+	cameFrom := map[S]S{}
 
-	gScore := scoreMap{}
+	gScore := scoreMap[S]{}
 	gScore[start] = 0
 
-	fScore := newFScoreMap()
-	fScore.Set(start, client.EstimateDistance(start, goal))
-
-	// A subset of fScore that contains only the nodes currently in openSet.
-	//
-	// One of the most expensive parts of this algorithm is searching fScore
-	// for the item from openSet that has the lowest value. The pseudocode
-	// from Wikipedia has a single fScore map that contains values from all
-	// nodes -- open and not open. If there are a lot of not open nodes
-	// whose scores are lower than those of the open nodes, the search for
-	// the open nodes with the lowest score will take longer and longer each
-	// time. This map stores only the scores that are part of openSet. It is
-	// a subset of fScore.
-	openFScore := newFScoreMap()
-	openFScore.Set(start, client.EstimateDistance(start, goal))
-
-	for round := 0; len(openSet) > 0; round++ {
-		// logger.LogF("===round %v\n", round)
-		// logger.LogF("closed set %v\n", closedSet)
-		// logger.LogF("open set %v\n", openSet)
-		// logger.LogF("gScore %+v\n", gScore)
-		// logger.LogF("fScore %+v\n", fScore)
-
-		current := ""
-		var currentFScore uint
-		openFScore.Walk(func(item *fScoreItem) bool {
-			if _, found := openSet[item.Name]; found {
-				current = item.Name
-				currentFScore = item.Value
-				return false
-			}
-			return true
-		})
-		if current == "" {
-			panic("nothing found in fscore")
+	open := newOpenSet[S]()
+	open.Set(start, g.Estimate(start))
+
+	for open.Len() > 0 {
+		if open.Len() > stats.MaxFrontier {
+			stats.MaxFrontier = open.Len()
+		}
+		if opts.MaxExpansions > 0 && stats.Expanded >= opts.MaxExpansions {
+			return nil, 0, ErrBudgetExhausted
 		}
 
-		// logger.LogF("current %v\n", current)
+		current, currentFScore := open.PopMin()
+		currentGScore := gScore.GetWithDefault(current, math.MaxUint32)
+		stats.Expanded++
+		if opts.OnExpand != nil {
+			opts.OnExpand(current, currentGScore, currentFScore)
+		}
 
-		if client.GoalReached(current, goal) {
-			return reconstructPath(cameFrom, current)
+		if g.IsGoal(current) {
+			return reconstructPath(cameFrom, current), currentGScore, nil
 		}
 
-		delete(openSet, current)
-		openFScore.Delete(current, currentFScore)
 		closedSet[current] = true
 
-		currentGScore := gScore.GetWithDefault(current, math.MaxUint32)
-
-		neighbors := client.AllNeighbors(current)
-		for _, neighbor := range neighbors {
+		for _, neighbor := range g.Neighbors(current) {
 			if _, found := closedSet[neighbor]; found {
 				continue
 			}
 
-			neighborGScore := currentGScore +
-				client.NeighborDistance(current, neighbor)
-
-			if _, found := openSet[neighbor]; !found {
-				openSet[neighbor] = true
-			} else if neighborGScore >= gScore.GetWithDefault(neighbor, math.MaxUint32) {
-				// logger.LogF("%v to %v isn't better\n", current, neighbor)
+			neighborGScore := currentGScore + g.Distance(current, neighbor)
+			if neighborGScore >= gScore.GetWithDefault(neighbor, math.MaxUint32) {
 				continue // not a better path
 			}
 
 			// this path is the best until now. record it!
 			cameFrom[neighbor] = current
 			gScore[neighbor] = neighborGScore
-
-			neighborFScore := neighborGScore + client.EstimateDistance(neighbor, goal)
-			fScore.Set(neighbor, neighborFScore)
-			openFScore.Set(neighbor, neighborFScore)
+			open.Set(neighbor, neighborGScore+g.Estimate(neighbor))
 		}
 	}
 
-	return nil // no path found
+	return nil, 0, ErrNoPath
+}
+
+// ClientInterface is the original string-based search interface. New
+// code should implement Graph and use Search.
+type ClientInterface interface {
+	AllNeighbors(start string) []string
+	EstimateDistance(start, end string) uint
+	NeighborDistance(n1, n2 string) uint
+	GoalReached(cand, goal string) bool
+}
+
+// clientGraph adapts a ClientInterface to Graph.
+type clientGraph struct {
+	client ClientInterface
+	goal   string
+}
+
+func (g *clientGraph) Neighbors(s string) []string   { return g.client.AllNeighbors(s) }
+func (g *clientGraph) Distance(from, to string) uint { return g.client.NeighborDistance(from, to) }
+func (g *clientGraph) Estimate(s string) uint        { return g.client.EstimateDistance(s, g.goal) }
+func (g *clientGraph) IsGoal(s string) bool          { return g.client.GoalReached(s, g.goal) }
+
+// AStar returns the path from start to goal, goal-first, or nil if there
+// isn't one.
+func AStar(start, goal string, client ClientInterface) []string {
+	path, _, err := Search[string](start, &clientGraph{client, goal}, Options[string]{})
+	if err != nil {
+		return nil
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
 }
@@ -3,6 +3,7 @@ package astar
 import (
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"testing"
@@ -83,26 +84,174 @@ func TestAStar(t *testing.T) {
 	}
 }
 
-func TestFScoreMap(t *testing.T) {
-	m := newFScoreMap()
-	m.Set("a", 6)
-	m.Set("b", 2)
-	m.Set("c", 1)
-	m.Set("d", 1)
-	m.Set("e", 1)
+type pos struct {
+	x, y int
+}
+
+// gridGraph is a grid with walls ('#'). Each step costs the digit in the
+// destination cell, or 1 if the cell isn't a digit.
+type gridGraph struct {
+	cells []string
+	goals map[pos]bool
+}
+
+func (g *gridGraph) Neighbors(p pos) []pos {
+	out := []pos{}
+	for _, d := range []pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		n := pos{p.x + d.x, p.y + d.y}
+		if n.y >= 0 && n.y < len(g.cells) && n.x >= 0 && n.x < len(g.cells[n.y]) && g.cells[n.y][n.x] != '#' {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (g *gridGraph) Distance(from, to pos) uint {
+	if c := g.cells[to.y][to.x]; c >= '0' && c <= '9' {
+		return uint(c - '0')
+	}
+	return 1
+}
+
+func (g *gridGraph) Estimate(p pos) uint {
+	best := uint(math.MaxUint32)
+	for goal := range g.goals {
+		dx, dy := goal.x-p.x, goal.y-p.y
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		if d := uint(dx + dy); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func (g *gridGraph) IsGoal(p pos) bool {
+	return g.goals[p]
+}
+
+func TestSearch(t *testing.T) {
+	cells := []string{
+		"....#....",
+		".##.#.##.",
+		".#..9..#.",
+		"...###...",
+	}
+
+	type testCase struct {
+		goals         []pos
+		opts          Options[pos]
+		expectedPath  []pos
+		expectedCost  uint
+		expectedError error
+	}
+
+	tests := []testCase{
+		{
+			goals:        []pos{{0, 3}},
+			expectedPath: []pos{{0, 0}, {0, 1}, {0, 2}, {0, 3}},
+			expectedCost: 3,
+		},
+		{
+			// The only way through is the expensive 9.
+			goals: []pos{{8, 0}},
+			expectedPath: []pos{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {3, 1}, {3, 2}, {4, 2},
+				{5, 2}, {5, 1}, {5, 0}, {6, 0}, {7, 0}, {8, 0}},
+			expectedCost: 20,
+		},
+		{
+			// The nearer of two goals.
+			goals:        []pos{{8, 0}, {3, 1}},
+			expectedPath: []pos{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {3, 1}},
+			expectedCost: 4,
+		},
+		{
+			goals:         []pos{{4, 0}},
+			expectedError: ErrNoPath,
+		},
+		{
+			goals:         []pos{{8, 3}},
+			opts:          Options[pos]{MaxExpansions: 5},
+			expectedError: ErrBudgetExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		g := &gridGraph{cells: cells, goals: map[pos]bool{}}
+		for _, goal := range test.goals {
+			g.goals[goal] = true
+		}
+
+		path, cost, err := Search(pos{0, 0}, g, test.opts)
+		if err != test.expectedError || cost != test.expectedCost || !reflect.DeepEqual(path, test.expectedPath) {
+			t.Errorf("Search(%v) = %v, %v, %v, want %v, %v, %v",
+				test.goals, path, cost, err, test.expectedPath, test.expectedCost, test.expectedError)
+		}
+	}
+}
+
+func TestOpenSet(t *testing.T) {
+	o := newOpenSet[string]()
+	o.Set("a", 6)
+	o.Set("b", 2)
+	o.Set("f", 9)
+	o.Set("c", 1)
+	o.Set("d", 1)
+	o.Set("e", 1)
+	o.Set("f", 1) // decrease-key
+
+	if !o.Contains("f") || o.Contains("g") {
+		t.Errorf("Contains(f), Contains(g) = %v, %v, want true, false",
+			o.Contains("f"), o.Contains("g"))
+	}
 
 	found := []string{}
-	m.Walk(func(item *fScoreItem) bool {
-		found = append(found, fmt.Sprintf("%v:%v", item.Name, item.Value))
-		return true
-	})
+	for o.Len() > 0 {
+		s, f := o.PopMin()
+		found = append(found, fmt.Sprintf("%v:%v", s, f))
+	}
 
-	expected := []string{"c:1", "d:1", "e:1", "b:2", "a:6"}
+	expected := []string{"c:1", "d:1", "e:1", "f:1", "b:2", "a:6"}
 	if !reflect.DeepEqual(expected, found) {
 		t.Errorf("got %v, wanted %v", found, expected)
 	}
 }
 
+func TestSearchStats(t *testing.T) {
+	g := &gridGraph{
+		cells: []string{"....", ".##.", "...."},
+		goals: map[pos]bool{{3, 2}: true},
+	}
+
+	expanded := []pos{}
+	stats := &Stats{}
+	opts := Options[pos]{
+		OnExpand: func(p pos, gScore, fScore uint) {
+			if gScore+g.Estimate(p) != fScore {
+				t.Errorf("OnExpand(%v, %v, %v): fScore should be %v",
+					p, gScore, fScore, gScore+g.Estimate(p))
+			}
+			expanded = append(expanded, p)
+		},
+		Stats: stats,
+	}
+
+	if _, cost, err := Search(pos{0, 0}, g, opts); err != nil || cost != 5 {
+		t.Fatalf("Search = _, %v, %v, want _, 5, nil", cost, err)
+	}
+
+	if stats.Expanded != len(expanded) || expanded[0] != (pos{0, 0}) || expanded[len(expanded)-1] != (pos{3, 2}) {
+		t.Errorf("Expanded = %v, callbacks %v", stats.Expanded, expanded)
+	}
+	if stats.MaxFrontier < 2 || stats.MaxFrontier > stats.Expanded+1 {
+		t.Errorf("MaxFrontier = %v, want between 2 and %v", stats.MaxFrontier, stats.Expanded+1)
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	//logger.Init(true)
@@ -0,0 +1,301 @@
+// Package cfg builds control-flow graphs and a call graph from a traced
+// binary, and exports them as Graphviz DOT or JSON.
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"comment"
+	"disasm"
+	"instruction"
+	"symtab"
+)
+
+type Inst struct {
+	Addr    uint16   `json:"addr"`
+	Text    string   `json:"text"`
+	Comment string   `json:"comment,omitempty"`
+	Block   []string `json:"block_comment,omitempty"`
+}
+
+// Block is a basic block. Calls don't end blocks, as they're assumed to
+// return.
+type Block struct {
+	Start uint16  `json:"start"`
+	End   uint16  `json:"end"`
+	Insts []*Inst `json:"insts"`
+
+	// Succs holds the addresses of successor blocks. Jumps out of the
+	// function (tail calls) are included.
+	Succs []uint16 `json:"succs"`
+
+	// Indirect is true if the block ends with a jump through a
+	// register.
+	Indirect bool `json:"indirect,omitempty"`
+}
+
+type Func struct {
+	Name   string   `json:"name"`
+	Start  uint16   `json:"start"`
+	End    uint16   `json:"end"`
+	Blocks []*Block `json:"blocks"`
+
+	// Calls holds the sorted entry points of statically-called
+	// functions.
+	Calls []uint16 `json:"calls"`
+
+	// IndirectCalls is true if the function calls through a register.
+	IndirectCalls bool `json:"indirect_calls,omitempty"`
+}
+
+type Program struct {
+	Funcs []*Func `json:"funcs"`
+
+	byStart map[uint16]*Func
+}
+
+func isBranch(code uint16) bool {
+	switch code {
+	case instruction.OpJmp, instruction.OpJt, instruction.OpJf:
+		return true
+	default:
+		return false
+	}
+}
+
+// Build constructs graphs for each function found by tr. Names come from
+// st, falling back to the sub_<addr> names assigned by the trace.
+func Build(tr *disasm.Trace, st symtab.SymTab, cReg comment.Registry) *Program {
+	st = tr.SymTab(st)
+
+	prog := &Program{byStart: map[uint16]*Func{}}
+	for _, tf := range tr.SortedFuncs() {
+		f := buildFunc(tr, tf, st, cReg)
+		prog.Funcs = append(prog.Funcs, f)
+		prog.byStart[f.Start] = f
+	}
+	return prog
+}
+
+func buildFunc(tr *disasm.Trace, tf *disasm.Func, st symtab.SymTab, cReg comment.Registry) *Func {
+	f := &Func{
+		Name:  funcName(tf.Start, st),
+		Start: tf.Start,
+		End:   tf.End,
+		Calls: []uint16{},
+	}
+
+	body := tr.Body(tf)
+	inBody := map[uint16]bool{}
+	for _, addr := range body {
+		inBody[addr] = true
+	}
+
+	leaders := map[uint16]bool{tf.Start: true}
+	calls := map[uint16]bool{}
+	for _, addr := range body {
+		inst := tr.Insts[addr]
+		if !isBranch(inst.Op.Code) {
+			if inst.Op.Code == instruction.OpCall {
+				if tgt, ok := inst.StaticTarget(); ok {
+					calls[tgt] = true
+				} else {
+					f.IndirectCalls = true
+				}
+			}
+			continue
+		}
+
+		if tgt, ok := inst.StaticTarget(); ok {
+			leaders[tgt] = true
+		}
+		leaders[inst.Next()] = true
+	}
+
+	for tgt := range calls {
+		f.Calls = append(f.Calls, tgt)
+	}
+	sort.Slice(f.Calls, func(i, j int) bool { return f.Calls[i] < f.Calls[j] })
+
+	var cur *Block
+	for _, addr := range body {
+		inst := tr.Insts[addr]
+
+		if cur == nil || leaders[addr] {
+			cur = &Block{Start: addr, Succs: []uint16{}}
+			f.Blocks = append(f.Blocks, cur)
+		}
+
+		cur.End = inst.Next() - 1
+		cur.Insts = append(cur.Insts, newInst(tr, addr, st, cReg))
+
+		next := inst.Next()
+		ends := isBranch(inst.Op.Code) || !inst.FallsThrough() || leaders[next] || !inBody[next]
+		if !ends {
+			continue
+		}
+
+		if isBranch(inst.Op.Code) {
+			if tgt, ok := inst.StaticTarget(); ok {
+				cur.Succs = append(cur.Succs, tgt)
+			} else {
+				cur.Indirect = true
+			}
+		}
+		if inst.FallsThrough() {
+			cur.Succs = append(cur.Succs, next)
+		}
+		cur = nil
+	}
+
+	return f
+}
+
+func newInst(tr *disasm.Trace, addr uint16, st symtab.SymTab, cReg comment.Registry) *Inst {
+	inst := &Inst{Addr: addr, Text: tr.Text(addr, st)}
+	if c, found := cReg.GetSingle(int(addr)); found {
+		inst.Comment = c
+	}
+	if block := cReg.GetBlock(int(addr)); block != nil {
+		inst.Block = block.Lines
+	}
+	return inst
+}
+
+func funcName(addr uint16, st symtab.SymTab) string {
+	if ent, found := st.LookupAddr(uint(addr)); found && ent.Start == uint(addr) {
+		return ent.Name
+	}
+	return fmt.Sprint(addr)
+}
+
+// Func returns the function whose name or start address is loc.
+func (p *Program) Func(loc string) (*Func, bool) {
+	for _, f := range p.Funcs {
+		if f.Name == loc || fmt.Sprint(f.Start) == loc {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func (p *Program) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+func dotQuote(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	return `"` + strings.Replace(str, `"`, `\"`, -1) + `"`
+}
+
+func blockLabel(b *Block) []string {
+	lines := []string{}
+	for _, inst := range b.Insts {
+		for _, line := range inst.Block {
+			lines = append(lines, "// "+line)
+		}
+		line := fmt.Sprintf("%5d: %s", inst.Addr, inst.Text)
+		if inst.Comment != "" {
+			line += "  // " + inst.Comment
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// leftJustified returns a quoted DOT label with each line left-justified.
+func leftJustified(lines []string) string {
+	label := ""
+	for _, line := range lines {
+		quoted := dotQuote(line)
+		label += quoted[1:len(quoted)-1] + `\l`
+	}
+	return `"` + label + `"`
+}
+
+// WriteFuncDOT writes the control-flow graph for f. Successors outside f
+// and register jump targets are drawn as dashed nodes.
+func (p *Program) WriteFuncDOT(w io.Writer, f *Func) error {
+	inFunc := map[uint16]bool{}
+	for _, b := range f.Blocks {
+		inFunc[b.Start] = true
+	}
+
+	lines := []string{
+		fmt.Sprintf("digraph %s {", dotQuote(f.Name)),
+		"  node [shape=box fontname=monospace];",
+	}
+	external := map[uint16]bool{}
+	indirect := false
+	for _, b := range f.Blocks {
+		lines = append(lines, fmt.Sprintf("  b%d [label=%s];", b.Start, leftJustified(blockLabel(b))))
+		for _, succ := range b.Succs {
+			lines = append(lines, fmt.Sprintf("  b%d -> b%d;", b.Start, succ))
+			if !inFunc[succ] {
+				external[succ] = true
+			}
+		}
+		if b.Indirect {
+			lines = append(lines, fmt.Sprintf("  b%d -> indirect;", b.Start))
+			indirect = true
+		}
+	}
+
+	ext := []uint16{}
+	for addr := range external {
+		ext = append(ext, addr)
+	}
+	sort.Slice(ext, func(i, j int) bool { return ext[i] < ext[j] })
+	for _, addr := range ext {
+		lines = append(lines, fmt.Sprintf("  b%d [label=%s style=dashed];", addr, dotQuote(p.name(addr))))
+	}
+	if indirect {
+		lines = append(lines, `  indirect [label="(indirect)" style=dashed];`)
+	}
+	lines = append(lines, "}")
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+func (p *Program) name(addr uint16) string {
+	if f, found := p.byStart[addr]; found {
+		return f.Name
+	}
+	return fmt.Sprint(addr)
+}
+
+// WriteCallGraphDOT writes the whole-program call graph. Functions that
+// call through a register get an edge to a single "indirect" node.
+func (p *Program) WriteCallGraphDOT(w io.Writer) error {
+	lines := []string{
+		"digraph calls {",
+		"  node [shape=box];",
+	}
+	indirect := false
+	for _, f := range p.Funcs {
+		lines = append(lines, fmt.Sprintf("  f%d [label=%s];", f.Start, dotQuote(f.Name)))
+	}
+	for _, f := range p.Funcs {
+		for _, callee := range f.Calls {
+			lines = append(lines, fmt.Sprintf("  f%d -> f%d;", f.Start, callee))
+		}
+		if f.IndirectCalls {
+			lines = append(lines, fmt.Sprintf("  f%d -> indirect;", f.Start))
+			indirect = true
+		}
+	}
+	if indirect {
+		lines = append(lines, `  indirect [label="(indirect)" style=dashed];`)
+	}
+	lines = append(lines, "}")
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
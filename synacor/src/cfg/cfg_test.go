@@ -0,0 +1,81 @@
+package cfg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"comment"
+	"disasm"
+	"symtab"
+)
+
+var image = []uint16{
+	17, 6, // 0: call 6
+	17, 32768, // 2: call r1
+	0,                  // 4: hlt
+	0,                  // 5: unreachable
+	9, 32768, 32768, 1, // 6: add r1 r1 1
+	7, 32768, 6, // 10: jt r1 6
+	18, // 13: ret
+}
+
+func TestBuild(t *testing.T) {
+	st := symtab.New()
+	st.Add("loop", 6, 13)
+
+	tr := disasm.NewTrace(image, []uint16{0})
+	prog := Build(tr, st, &comment.NullRegistry{})
+
+	type blockSummary struct {
+		Start, End uint16
+		Succs      []uint16
+	}
+	summarize := func(f *Func) []blockSummary {
+		out := []blockSummary{}
+		for _, b := range f.Blocks {
+			out = append(out, blockSummary{b.Start, b.End, b.Succs})
+		}
+		return out
+	}
+
+	if len(prog.Funcs) != 2 {
+		t.Fatalf("len(Funcs) = %v, want 2", len(prog.Funcs))
+	}
+
+	main, loop := prog.Funcs[0], prog.Funcs[1]
+	if main.Name != "sub_0" || !reflect.DeepEqual(main.Calls, []uint16{6}) || !main.IndirectCalls {
+		t.Errorf("main = %+v, want sub_0 calling [6] and indirect", main)
+	}
+	if got, want := summarize(main), []blockSummary{{0, 4, []uint16{}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("main blocks = %v, want %v", got, want)
+	}
+
+	if loop.Name != "loop" || len(loop.Calls) != 0 {
+		t.Errorf("loop = %+v, want loop with no calls", loop)
+	}
+	want := []blockSummary{
+		{6, 12, []uint16{6, 13}},
+		{13, 13, []uint16{}},
+	}
+	if got := summarize(loop); !reflect.DeepEqual(got, want) {
+		t.Errorf("loop blocks = %v, want %v", got, want)
+	}
+}
+
+func TestWriteCallGraphDOT(t *testing.T) {
+	tr := disasm.NewTrace(image, []uint16{0})
+	prog := Build(tr, &symtab.NoEntriesSymTab{}, &comment.NullRegistry{})
+
+	var out bytes.Buffer
+	if err := prog.WriteCallGraphDOT(&out); err != nil {
+		t.Fatalf("WriteCallGraphDOT() = %v, want nil", err)
+	}
+
+	for _, want := range []string{"f0 -> f6;", "f0 -> indirect;", `f6 [label="sub_6"];`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteCallGraphDOT() = %q, want it to contain %q", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"memory"
+	"patch"
+	"symtab"
+	"util"
+)
+
+var (
+	inputPath  = flag.String("input", "", "binary to patch")
+	outputPath = flag.String("output", "", "where to write the patched binary; if empty, only check that the patch applies")
+	patchPath  = flag.String("patch", "", "patch file")
+	symTabPath = flag.String("symtab", "", "path to symbol table")
+)
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		log.Fatalf("--input is required")
+	}
+	if *patchPath == "" {
+		log.Fatalf("--patch is required")
+	}
+
+	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
+	if *symTabPath != "" {
+		var err error
+		if symTab, err = symtab.ReadFromPath(*symTabPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	p, err := patch.ReadFromPath(*patchPath, symTab)
+	if err != nil {
+		log.Fatalf("%v:%v", *patchPath, err)
+	}
+
+	words, err := util.ReadWordsFromPath(*inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(words) > memory.Size {
+		log.Fatalf("%v is too big", *inputPath)
+	}
+
+	ram := &memory.RAM{}
+	copy(ram[:], words)
+	if err := p.Apply(ram, nil); err != nil {
+		log.Fatalf("patch doesn't apply: %v", err)
+	}
+
+	if len(p.Regs) > 0 {
+		log.Printf("registers aren't part of the binary; run with --init_reg=%v",
+			strings.Join(p.Regs, ","))
+	}
+
+	if *outputPath == "" {
+		return
+	}
+
+	// Hunks can't extend the binary, as the original words are checked.
+	if err := util.WriteWordsToPath(*outputPath, ram[:len(words)]); err != nil {
+		log.Fatalf("failed to write %v: %v", *outputPath, err)
+	}
+}
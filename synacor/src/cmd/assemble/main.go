@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"assembler"
+	"symtab"
+	"util"
+)
+
+var (
+	inputPath  = flag.String("input", "", "assembly source")
+	outputPath = flag.String("output", "", "where to write the binary")
+	symTabPath = flag.String("symtab", "", "path to symbol table")
+	org        = flag.Uint("org", 0, "address of the first statement")
+)
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		log.Fatalf("--input is required")
+	}
+	if *outputPath == "" {
+		log.Fatalf("--output is required")
+	}
+
+	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
+	if *symTabPath != "" {
+		var err error
+		if symTab, err = symtab.ReadFromPath(*symTabPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fp, err := os.Open(*inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fp.Close()
+
+	prog, err := assembler.Assemble(fp, uint16(*org), symTab)
+	if err != nil {
+		log.Fatalf("%v:%v", *inputPath, err)
+	}
+
+	// Binaries are loaded at address 0, so anything before the origin
+	// is zero-filled.
+	words := append(make([]uint16, prog.Origin), prog.Words...)
+	if err := util.WriteWordsToPath(*outputPath, words); err != nil {
+		log.Fatalf("failed to write %v: %v", *outputPath, err)
+	}
+}
@@ -1,19 +1,95 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 )
 
 var (
-	coins = map[int]string{
-		2: "red",
-		7: "concave",
-		3: "corroded",
-		9: "blue",
-		5: "shiny",
+	equationFlag = flag.String("equation", "_ + _ * _^2 + _^3 - _ = 399",
+		"the equation, as shown in the ruins")
+	coinFlags coinSpecs
+
+	defaultCoins = coinSpecs{"red=2", "concave=7", "corroded=3", "blue=9", "shiny=5"}
+)
+
+func init() {
+	flag.Var(&coinFlags, "coin",
+		"a coin, as name=value where the value is a number or the coin's description; repeat for each coin")
+}
+
+// coinSpecs collects the values of a repeated flag. Descriptions can
+// contain commas, so each coin needs its own flag.
+type coinSpecs []string
+
+func (c *coinSpecs) String() string {
+	return strings.Join(*c, " ")
+}
+
+func (c *coinSpecs) Set(spec string) error {
+	*c = append(*c, spec)
+	return nil
+}
+
+type Coin struct {
+	Name  string
+	Value int
+}
+
+var (
+	numberWords = map[string]int{
+		"one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+		"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+	}
+	shapeSides = map[string]int{
+		"triangle": 3, "square": 4, "pentagon": 5, "hexagon": 6,
+		"heptagon": 7, "octagon": 8, "nonagon": 9, "decagon": 10,
 	}
 )
 
+// coinValue returns the value of a coin given either the value itself or
+// the coin's description, in which the value is a count of dots ("It has
+// two dots on one side.") or a polygon.
+func coinValue(desc string) (int, error) {
+	if val, err := strconv.Atoi(strings.TrimSpace(desc)); err == nil {
+		return val, nil
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(desc)) {
+		word = strings.Trim(word, ".,;:!")
+		if val, found := numberWords[word]; found {
+			return val, nil
+		}
+		if val, found := shapeSides[word]; found {
+			return val, nil
+		}
+		if val, err := strconv.Atoi(word); err == nil {
+			return val, nil
+		}
+	}
+	return 0, fmt.Errorf("no value in %q", desc)
+}
+
+func ParseCoins(specs []string) ([]Coin, error) {
+	coins := []Coin{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad coin %q; want name=value", spec)
+		}
+
+		val, err := coinValue(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("coin %v: %v", parts[0], err)
+		}
+		coins = append(coins, Coin{Name: strings.TrimSpace(parts[0]), Value: val})
+	}
+	return coins, nil
+}
+
 type Permuter struct {
 	values []int
 	used   []bool // necessary?
@@ -91,28 +167,77 @@ func (p *Permuter) Advance() bool {
 	return true
 }
 
-func verify(v []int) bool {
-	return v[0]+(v[1]*v[2]*v[2])+v[3]*v[3]*v[3]-v[4] == 399
-}
-
-func main() {
-	values := []int{}
-	for k := range coins {
-		values = append(values, k)
+// Solve returns each ordering of coins which, placed in the equation's
+// holes left to right, makes it hold.
+func Solve(eq *Equation, coins []Coin) ([][]Coin, error) {
+	if eq.NumHoles != len(coins) {
+		return nil, fmt.Errorf("equation has %d holes but there are %d coins",
+			eq.NumHoles, len(coins))
 	}
 
-	permuter := NewPermuter(values)
+	idxs := []int{}
+	for i := range coins {
+		idxs = append(idxs, i)
+	}
 
+	solutions := [][]Coin{}
+	vals := make([]int, len(coins))
+	permuter := NewPermuter(idxs)
 	for {
-		values := permuter.Values()
-		if verify(values) {
-			for _, value := range values {
-				fmt.Println(coins[value])
+		order := permuter.Values()
+		for i, idx := range order {
+			vals[i] = coins[idx].Value
+		}
+
+		if eq.Holds(vals) {
+			solution := []Coin{}
+			for _, idx := range order {
+				solution = append(solution, coins[idx])
 			}
+			solutions = append(solutions, solution)
 		}
 
 		if !permuter.Advance() {
 			break
 		}
 	}
+
+	return solutions, nil
+}
+
+func main() {
+	flag.Parse()
+
+	eq, err := ParseEquation(*equationFlag)
+	if err != nil {
+		log.Fatalf("bad equation: %v", err)
+	}
+
+	specs := coinFlags
+	if len(specs) == 0 {
+		specs = defaultCoins
+	}
+	coins, err := ParseCoins(specs)
+	if err != nil {
+		log.Fatalf("bad coins: %v", err)
+	}
+
+	solutions, err := Solve(eq, coins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(solutions) == 0 {
+		log.Fatalf("no solution to %v", eq)
+	}
+	if len(solutions) > 1 {
+		log.Printf("%d solutions; using the first", len(solutions))
+	}
+
+	for _, coin := range solutions[0] {
+		name := coin.Name
+		if !strings.HasSuffix(name, " coin") {
+			name += " coin"
+		}
+		fmt.Println("use " + name)
+	}
 }
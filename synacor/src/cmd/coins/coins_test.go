@@ -60,3 +60,92 @@ func TestPermuter(t *testing.T) {
 		t.Errorf("expected 120 permutations, got %v", numPerms)
 	}
 }
+
+func TestParseEquation(t *testing.T) {
+	tests := []struct {
+		in       string
+		vals     []int
+		expected string
+		holds    bool
+	}{
+		{"_ + _ * _^2 + _^3 - _ = 399", []int{9, 2, 5, 7, 3},
+			"(((_ + (_ * (_ ^ 2))) + (_ ^ 3)) - _) = 399", true},
+		{"_ + _ * _^2 + _^3 - _ = 399", []int{2, 9, 5, 7, 3},
+			"(((_ + (_ * (_ ^ 2))) + (_ ^ 3)) - _) = 399", false},
+		{"2^_^2 = (_ - 1) * 4", []int{3, 129}, "(2 ^ (_ ^ 2)) = ((_ - 1) * 4)", true},
+	}
+
+	for _, test := range tests {
+		eq, err := ParseEquation(test.in)
+		if err != nil {
+			t.Errorf("ParseEquation(%q) = %v, want nil", test.in, err)
+			continue
+		}
+		if eq.String() != test.expected || eq.NumHoles != len(test.vals) {
+			t.Errorf("ParseEquation(%q) = %v (%d holes), want %v (%d holes)",
+				test.in, eq, eq.NumHoles, test.expected, len(test.vals))
+		}
+		if holds := eq.Holds(test.vals); holds != test.holds {
+			t.Errorf("%v.Holds(%v) = %v, want %v", eq, test.vals, holds, test.holds)
+		}
+	}
+
+	for _, in := range []string{"_ + _", "_ + = 3", "(_ = 3", "_ = 3 3", "x = 3"} {
+		if _, err := ParseEquation(in); err == nil {
+			t.Errorf("ParseEquation(%q) = nil, want error", in)
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	coins, err := ParseCoins([]string{"red=It has two dots on one side.", "concave=seven dots",
+		"corroded=a triangle", "blue=9", "shiny=pentagon"})
+	if err != nil {
+		t.Fatalf("ParseCoins = %v, want nil", err)
+	}
+
+	eq, err := ParseEquation("_ + _ * _^2 + _^3 - _ = 399")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solutions, err := Solve(eq, coins)
+	expected := [][]Coin{{{"blue", 9}, {"red", 2}, {"shiny", 5}, {"concave", 7}, {"corroded", 3}}}
+	if err != nil || !reflect.DeepEqual(solutions, expected) {
+		t.Errorf("Solve(%v, %v) = %v, %v, want %v, nil", eq, coins, solutions, err, expected)
+	}
+
+	if _, err := Solve(eq, coins[1:]); err == nil {
+		t.Errorf("Solve with 4 coins = nil, want error")
+	}
+}
+
+func TestParseCoinsRealDescriptions(t *testing.T) {
+	// As printed by the game when looking at each coin.
+	coins, err := ParseCoins([]string{
+		"red coin=This coin is made of a red metal.  It has two dots on one side.",
+		"concave coin=This coin is slightly rounded, almost like a tiny bowl.  It has seven dots on one side.",
+		"corroded coin=This coin is somewhat corroded.  It has a triangle on one side.",
+		"blue coin=This coin is made of a blue metal.  It has nine dots on one side.",
+		"shiny coin=This coin is somehow still quite shiny.  It has a pentagon on one side.",
+	})
+	if err != nil {
+		t.Fatalf("ParseCoins = %v, want nil", err)
+	}
+
+	expected := []Coin{{"red coin", 2}, {"concave coin", 7}, {"corroded coin", 3},
+		{"blue coin", 9}, {"shiny coin", 5}}
+	if !reflect.DeepEqual(coins, expected) {
+		t.Errorf("ParseCoins = %v, want %v", coins, expected)
+	}
+
+	eq, err := ParseEquation("_ + _ * _^2 + _^3 - _ = 399")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solutions, err := Solve(eq, coins)
+	if err != nil || len(solutions) != 1 {
+		t.Errorf("Solve(%v, %v) = %v, %v, want 1 solution", eq, coins, solutions, err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Expr is a node in the parsed form of one side of the equation. Holes
+// (the _ spaces coins go in) are numbered left to right, and take their
+// values from vals.
+type Expr interface {
+	Eval(vals []int) int
+	String() string
+}
+
+type Hole int
+
+func (h Hole) Eval(vals []int) int { return vals[h] }
+func (h Hole) String() string      { return "_" }
+
+type Num int
+
+func (n Num) Eval(vals []int) int { return int(n) }
+func (n Num) String() string      { return strconv.Itoa(int(n)) }
+
+type BinOp struct {
+	Op          byte
+	Left, Right Expr
+}
+
+func (b *BinOp) Eval(vals []int) int {
+	l, r := b.Left.Eval(vals), b.Right.Eval(vals)
+	switch b.Op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	case '/':
+		if r == 0 {
+			return 0
+		}
+		return l / r
+	case '^':
+		out := 1
+		for i := 0; i < r; i++ {
+			out *= l
+		}
+		return out
+	default:
+		panic(fmt.Sprintf("unknown op %c", b.Op))
+	}
+}
+
+func (b *BinOp) String() string {
+	return fmt.Sprintf("(%v %c %v)", b.Left, b.Op, b.Right)
+}
+
+type Equation struct {
+	Left, Right Expr
+	NumHoles    int
+}
+
+func (e *Equation) Holds(vals []int) bool {
+	return e.Left.Eval(vals) == e.Right.Eval(vals)
+}
+
+func (e *Equation) String() string {
+	return fmt.Sprintf("%v = %v", e.Left, e.Right)
+}
+
+type parser struct {
+	toks     []string
+	numHoles int
+}
+
+func tokenize(str string) ([]string, error) {
+	toks := []string{}
+	for i := 0; i < len(str); {
+		c := rune(str[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(str) && unicode.IsDigit(rune(str[j])) {
+				j++
+			}
+			toks = append(toks, str[i:j])
+			i = j
+		case strings.ContainsRune("_+-*/^()=", c):
+			toks = append(toks, str[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return toks, nil
+}
+
+func (p *parser) peek() string {
+	if len(p.toks) == 0 {
+		return ""
+	}
+	return p.toks[0]
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.toks = p.toks[1:]
+	}
+	return tok
+}
+
+// binary parses a left-associative chain of the operators in ops, with
+// operands parsed by sub.
+func (p *parser) binary(ops string, sub func() (Expr, error)) (Expr, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+
+	for tok := p.peek(); tok != "" && strings.Contains(ops, tok); tok = p.peek() {
+		p.next()
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinOp{Op: tok[0], Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) sum() (Expr, error) {
+	return p.binary("+-", p.product)
+}
+
+func (p *parser) product() (Expr, error) {
+	return p.binary("*/", p.power)
+}
+
+// power is right-associative.
+func (p *parser) power() (Expr, error) {
+	base, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() != "^" {
+		return base, nil
+	}
+	p.next()
+
+	exp, err := p.power()
+	if err != nil {
+		return nil, err
+	}
+	return &BinOp{Op: '^', Left: base, Right: exp}, nil
+}
+
+func (p *parser) operand() (Expr, error) {
+	tok := p.next()
+	switch {
+	case tok == "_":
+		p.numHoles++
+		return Hole(p.numHoles - 1), nil
+	case tok == "(":
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	case tok != "" && unicode.IsDigit(rune(tok[0])):
+		val, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, err
+		}
+		return Num(val), nil
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of equation")
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+}
+
+// ParseEquation parses an equation like the one in the ruins, e.g.
+// "_ + _ * _^2 + _^3 - _ = 399".
+func ParseEquation(str string) (*Equation, error) {
+	toks, err := tokenize(str)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok != "=" {
+		return nil, fmt.Errorf("expected =, got %q", tok)
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("unexpected %q after equation", tok)
+	}
+
+	return &Equation{Left: left, Right: right, NumHoles: p.numHoles}, nil
+}
@@ -4,10 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
+	"annotations"
+	"cfg"
 	"comment"
-	"instruction"
-	"reader"
+	"decompile"
+	"disasm"
+	"profile"
 	"symtab"
 	"util"
 )
@@ -17,125 +22,120 @@ var (
 	lenFlag      = flag.Int("len", -1, "Number of shorts to print")
 	commentsPath = flag.String("comments", "", "path to comment registry")
 	symTabPath   = flag.String("symtab", "", "path to symbol table")
+	annotPath    = flag.String("annotations", "", "path to annotations; replaces --symtab and --comments")
 	full         = flag.Bool("full", false, "include read bytes and raw addrs")
+	trace        = flag.Bool("trace", false, "only disassemble code reachable from --entry")
+	entryFlag    = flag.String("entry", "0", "comma-separated trace entry points")
+	symTabOut    = flag.String("symtab_out", "", "with --trace, write discovered functions here")
+	symTabMerge  = flag.Bool("symtab_merge", false, "with --symtab_out, write --symtab too, with discovered functions added")
+	graph        = flag.String("graph", "", "instead of disassembling, write a graph (cfg or calls); implies --trace")
+	graphFormat  = flag.String("graph_format", "dot", "graph output format (dot or json)")
+	funcFlag     = flag.String("func", "", "with --graph=cfg or --decompile, only write this function, tracing it if --entry doesn't reach it")
+	decompFlag   = flag.Bool("decompile", false, "after the disassembly, write traced functions as pseudo-C; implies --trace")
+	coveragePath = flag.String("coverage", "", "path to vm --coverage output; marks never-executed instructions")
 )
 
-type SaverReader struct {
-	r  reader.Short
-	vr [5]uint16
-	n  int
-}
+func main() {
+	flag.Parse()
 
-func NewSaverReader(r reader.Short) *SaverReader {
-	return &SaverReader{
-		r: r,
+	if *inputPath == "" {
+		log.Fatalf("--input is required")
 	}
-}
-
-func (sr *SaverReader) Read() (uint16, error) {
-	v, err := sr.r.Read()
-	if err != nil {
-		return 0, err
+	if *graph != "" && *graph != "cfg" && *graph != "calls" {
+		log.Fatalf("--graph must be cfg or calls")
 	}
-
-	if sr.n < len(sr.vr) {
-		sr.vr[sr.n] = v
-		sr.n++
+	if *graphFormat != "dot" && *graphFormat != "json" {
+		log.Fatalf("--graph_format must be dot or json")
+	}
+	if *lenFlag != -1 && (*trace || *graph != "" || *decompFlag) {
+		log.Fatalf("--len can't be used with --trace, --graph or --decompile")
 	}
 
-	return v, err
-}
-
-func (sr *SaverReader) Off() uint16 {
-	return sr.r.Off()
-}
-
-// Returns a slice containing the last values read. Invalidated upon next Read
-// call.
-func (sr *SaverReader) ValuesRead() []uint16 {
-	values := sr.vr[0:sr.n]
-	sr.n = 0
-	return values
-}
-
-func dump(sr reader.Short, st symtab.SymTab, cReg comment.Registry) {
-	saverReader := NewSaverReader(sr)
+	bio, err := util.NewBinIO(*inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bio.Close()
 
-	var curBlock *comment.Comment
+	symTab, commentRegistry := readAnnotationsOrDie()
 
-	for i := 0; *lenFlag == -1 || i < *lenFlag; i++ {
-		addr := sr.Off()
+	opts := disasm.Options{Len: *lenFlag, Full: *full}
+	if *coveragePath != "" {
+		if opts.Coverage, err = profile.ReadCoverageFromPath(*coveragePath); err != nil {
+			log.Fatalf("failed to read coverage: %v", err)
+		}
+	}
 
-		if block := cReg.GetBlock(int(addr)); block != nil {
-			if curBlock != nil {
-				panic("nested block")
-			}
-			curBlock = block
+	if !*trace && *graph == "" && !*decompFlag {
+		disasm.Dump(os.Stdout, bio, symTab, commentRegistry, opts)
+		return
+	}
 
-			padLen := 33
-			if *full {
-				padLen += 33 // 8 + 4*6 + 1
-			}
+	image, err := util.ReadWordsFromPath(*inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			fmt.Println()
-			for _, line := range block.Lines {
-				fmt.Printf("%*s// %s\n", padLen, "", line)
-			}
+	entries := []uint16{}
+	for _, loc := range strings.Split(*entryFlag, ",") {
+		addr, err := util.NameToAddr(loc, symTab)
+		if err != nil {
+			log.Fatalf("bad entry %v: %v", loc, err)
 		}
+		entries = append(entries, addr)
+	}
 
-		inst, numRead, instErr := instruction.Read(saverReader)
-		if numRead == 0 {
-			break
+	// Trace the function named by --func even if --entry doesn't reach it.
+	if *funcFlag != "" {
+		if ent, found := symTab.LookupName(*funcFlag); found && ent.Kind == symtab.KindCode {
+			entries = append(entries, uint16(ent.Start))
 		}
+	}
 
-		fmt.Printf("%30s:  ", util.AddrToName(addr, st))
-
-		if *full {
-			fmt.Printf("%5d:  ", addr)
-
-			vr := saverReader.ValuesRead()
-			if len(vr) > 4 {
-				panic("long read")
-			}
-			for _, v := range vr {
-				fmt.Printf("%5d ", v)
-			}
-			for i := len(vr); i < 4; i++ {
-				fmt.Print("      ")
-			}
+	tr := disasm.NewTrace(image, entries)
 
-			fmt.Print(" ")
+	if *symTabOut != "" {
+		if err := writeSymTab(tr, symTab); err != nil {
+			log.Fatalf("failed to write symtab: %v", err)
 		}
+	}
 
-		if instErr != nil {
-			fmt.Println("error: ", instErr)
-		} else {
-			fmt.Printf("%-30s", inst.ToString(st))
-			if comment, found := cReg.GetSingle(int(addr)); found {
-				fmt.Printf("// %s", comment)
-			}
-			fmt.Println()
+	if *graph != "" {
+		if err := writeGraph(tr, symTab, commentRegistry); err != nil {
+			log.Fatalf("failed to write graph: %v", err)
 		}
+		return
+	}
 
-		if curBlock != nil && int(addr) == curBlock.End {
-			fmt.Println()
-			curBlock = nil
+	// Find the functions to decompile first, so a bad --func fails
+	// before the disassembly is written.
+	var decompFuncs []*cfg.Func
+	if *decompFlag {
+		if decompFuncs, err = selectedFuncs(cfg.Build(tr, symTab, commentRegistry)); err != nil {
+			log.Fatalf("failed to decompile: %v", err)
 		}
 	}
-}
 
-func main() {
-	flag.Parse()
+	disasm.DumpTrace(os.Stdout, tr, symTab, commentRegistry, opts)
 
-	if *inputPath == "" {
-		log.Fatalf("--input is required")
+	if *decompFlag {
+		if err := writeDecompiled(tr, decompFuncs, symTab); err != nil {
+			log.Fatalf("failed to decompile: %v", err)
+		}
 	}
+}
 
-	bio, err := util.NewBinIO(*inputPath)
-	if err != nil {
-		log.Fatal(err)
+func readAnnotationsOrDie() (symtab.SymTab, comment.Registry) {
+	if *annotPath != "" {
+		if *symTabPath != "" || *commentsPath != "" {
+			log.Fatalf("--annotations can't be used with --symtab or --comments")
+		}
+		db, err := annotations.ReadFromPath(*annotPath)
+		if err != nil {
+			log.Fatalf("failed to read annotations: %v", err)
+		}
+		return db.Symbols, db
 	}
-	defer bio.Close()
 
 	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
 	if *symTabPath != "" {
@@ -153,5 +153,77 @@ func main() {
 		}
 	}
 
-	dump(bio, symTab, commentRegistry)
+	return symTab, commentRegistry
+}
+
+func writeSymTab(tr *disasm.Trace, symTab symtab.SymTab) error {
+	if !*symTabMerge {
+		fp, err := os.Create(*symTabOut)
+		if err != nil {
+			return err
+		}
+		if err := tr.WriteSymTab(fp, symTab); err != nil {
+			fp.Close()
+			return err
+		}
+		return fp.Close()
+	}
+
+	if *symTabPath == "" {
+		return fmt.Errorf("--symtab_merge requires --symtab")
+	}
+	for _, ent := range tr.FuncNames(symTab).Entries() {
+		if err := symTab.AddEntry(ent); err != nil {
+			log.Printf("not adding discovered function: %v", err)
+		}
+	}
+	return symtab.WriteToPath(*symTabOut, symTab)
+}
+
+// selectedFuncs returns the function named by --func, or all of them.
+func selectedFuncs(prog *cfg.Program) ([]*cfg.Func, error) {
+	if *funcFlag == "" {
+		return prog.Funcs, nil
+	}
+	f, found := prog.Func(*funcFlag)
+	if !found {
+		return nil, fmt.Errorf("no traced function %v", *funcFlag)
+	}
+	return []*cfg.Func{f}, nil
+}
+
+func writeDecompiled(tr *disasm.Trace, funcs []*cfg.Func, symTab symtab.SymTab) error {
+	for _, f := range funcs {
+		fmt.Println()
+		if err := decompile.Write(os.Stdout, tr, f, symTab); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGraph(tr *disasm.Trace, symTab symtab.SymTab, commentRegistry comment.Registry) error {
+	prog := cfg.Build(tr, symTab, commentRegistry)
+
+	funcs, err := selectedFuncs(prog)
+	if err != nil {
+		return err
+	}
+
+	if *graphFormat == "json" {
+		if *graph == "cfg" {
+			prog.Funcs = funcs
+		}
+		return prog.WriteJSON(os.Stdout)
+	}
+
+	if *graph == "calls" {
+		return prog.WriteCallGraphDOT(os.Stdout)
+	}
+	for _, f := range funcs {
+		if err := prog.WriteFuncDOT(os.Stdout, f); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,115 @@
+// Explores the adventure's map by running the challenge in-process,
+// trying every exit from every room, and writing the resulting room graph.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"adventure"
+	"input"
+	"memory"
+	"snapshot"
+	"util"
+)
+
+var (
+	ramPath     = flag.String("ram", "", "challenge binary")
+	restorePath = flag.String("restore", "", "start from this snapshot instead of --ram")
+	scriptPath  = flag.String("script", "", "commands to run before exploring (directives are ignored)")
+	jsonPath    = flag.String("json", "", "where to write the map as JSON")
+	dotPath     = flag.String("dot", "", "where to write the map as DOT")
+	maxRooms    = flag.Int("max_rooms", 1000, "stop after finding this many rooms")
+	roomAddr    = flag.Int("room_addr", -1, "if set, identify rooms by the value at this RAM address")
+)
+
+func newGameOrDie() *adventure.Game {
+	if *restorePath != "" {
+		snap, err := snapshot.ReadFromPath(*restorePath)
+		if err != nil {
+			log.Fatalf("failed to read snapshot: %v", err)
+		}
+		return adventure.NewGame(snap)
+	}
+
+	if *ramPath == "" {
+		log.Fatalf("--ram or --restore is required")
+	}
+	image, err := util.ReadWordsFromPath(*ramPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return adventure.NewGameFromImage(image)
+}
+
+func writeOrDie(path string, write func(fp *os.File) error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := write(fp); err != nil {
+		log.Fatalf("failed to write %v: %v", path, err)
+	}
+	if err := fp.Close(); err != nil {
+		log.Fatalf("failed to write %v: %v", path, err)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *jsonPath == "" && *dotPath == "" {
+		log.Fatalf("--json or --dot is required")
+	}
+
+	game := newGameOrDie()
+
+	out, err := game.Run()
+	if err != nil {
+		log.Fatalf("failed to start game: %v", err)
+	}
+
+	if *scriptPath != "" {
+		script, err := input.ReadScriptFromPath(*scriptPath)
+		if err != nil {
+			log.Fatalf("failed to read script: %v", err)
+		}
+		for _, ent := range script.Entries {
+			if ent.Directive != nil {
+				continue
+			}
+			if out, err = game.Command(ent.Line); err != nil {
+				log.Fatalf("script command %q failed: %v", ent.Line, err)
+			}
+		}
+
+		// The last script command may not have printed a room.
+		if out, err = game.Command("look"); err != nil {
+			log.Fatalf("look failed: %v", err)
+		}
+	}
+
+	opts := adventure.ExploreOptions{MaxRooms: *maxRooms}
+	if *roomAddr != -1 {
+		if *roomAddr < 0 || *roomAddr >= memory.Size {
+			log.Fatalf("--room_addr must be in [0,%d)", memory.Size)
+		}
+		opts.KeyByAddr = true
+		opts.RoomAddr = uint16(*roomAddr)
+	}
+
+	m, err := adventure.Explore(game, out, opts)
+	if err != nil {
+		log.Fatalf("exploration failed: %v", err)
+	}
+
+	if *jsonPath != "" {
+		writeOrDie(*jsonPath, func(fp *os.File) error { return m.WriteJSON(fp) })
+	}
+	if *dotPath != "" {
+		writeOrDie(*dotPath, func(fp *os.File) error { return m.WriteDOT(fp) })
+	}
+
+	log.Printf("found %d rooms", len(m.Rooms))
+}
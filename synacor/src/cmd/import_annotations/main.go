@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"annotations"
+	"comment"
+	"disasm"
+	"symtab"
+	"util"
+)
+
+var (
+	symTabPath   = flag.String("symtab", "", "symbol table to import")
+	commentsPath = flag.String("comments", "", "comment registry to import")
+	xrefInput    = flag.String("xref_input", "", "if set, trace this binary and record cross-references")
+	entryFlag    = flag.String("entry", "0", "with --xref_input, comma-separated trace entry points")
+	outputPath   = flag.String("output", "", "where to write the annotations")
+)
+
+func main() {
+	flag.Parse()
+
+	if *outputPath == "" {
+		log.Fatalf("--output is required")
+	}
+
+	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
+	if *symTabPath != "" {
+		var err error
+		if symTab, err = symtab.ReadFromPath(*symTabPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var commentRegistry comment.Registry = &comment.NullRegistry{}
+	if *commentsPath != "" {
+		var err error
+		if commentRegistry, err = comment.ReadFromPath(*commentsPath, symTab); err != nil {
+			log.Fatalf("failed to read comment registry: %v", err)
+		}
+	}
+
+	db := annotations.New()
+	if err := db.Import(symTab, commentRegistry); err != nil {
+		log.Fatalf("failed to import: %v", err)
+	}
+
+	if *xrefInput != "" {
+		image, err := util.ReadWordsFromPath(*xrefInput)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		entries := []uint16{}
+		for _, loc := range strings.Split(*entryFlag, ",") {
+			addr, err := util.NameToAddr(loc, symTab)
+			if err != nil {
+				log.Fatalf("bad entry %v: %v", loc, err)
+			}
+			entries = append(entries, addr)
+		}
+
+		db.AddTraceXRefs(disasm.NewTrace(image, entries))
+	}
+
+	if err := db.WriteToPath(*outputPath); err != nil {
+		log.Fatalf("failed to write annotations: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Plans and executes the commands needed to reach a room or obtain (and
+// optionally use) an item, using a map written by explore.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"adventure"
+	"input"
+	"snapshot"
+	"util"
+)
+
+var (
+	mapPath      = flag.String("map", "", "map JSON written by explore")
+	ramPath      = flag.String("ram", "", "challenge binary")
+	restorePath  = flag.String("restore", "", "start from this snapshot instead of --ram")
+	scriptPath   = flag.String("script", "", "commands to run before planning (directives are ignored)")
+	roomFlag     = flag.String("room", "", "go to the nearest room with this title")
+	itemFlag     = flag.String("item", "", "obtain this item")
+	useFlag      = flag.Bool("use", false, "with --item, use the item once obtained")
+	textFlag     = flag.String("text", "", "stop when this text appears in the output")
+	snapshotPath = flag.String("snapshot", "", "where to write a snapshot once the goal is reached")
+)
+
+func newGameOrDie() *adventure.Game {
+	if *restorePath != "" {
+		snap, err := snapshot.ReadFromPath(*restorePath)
+		if err != nil {
+			log.Fatalf("failed to read snapshot: %v", err)
+		}
+		return adventure.NewGame(snap)
+	}
+
+	if *ramPath == "" {
+		log.Fatalf("--ram or --restore is required")
+	}
+	image, err := util.ReadWordsFromPath(*ramPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return adventure.NewGameFromImage(image)
+}
+
+func main() {
+	flag.Parse()
+
+	if *mapPath == "" {
+		log.Fatalf("--map is required")
+	}
+	m, err := adventure.ReadMapFromPath(*mapPath)
+	if err != nil {
+		log.Fatalf("failed to read map: %v", err)
+	}
+
+	game := newGameOrDie()
+	if _, err := game.Run(); err != nil {
+		log.Fatalf("failed to start game: %v", err)
+	}
+
+	if *scriptPath != "" {
+		script, err := input.ReadScriptFromPath(*scriptPath)
+		if err != nil {
+			log.Fatalf("failed to read script: %v", err)
+		}
+		for _, ent := range script.Entries {
+			if ent.Directive != nil {
+				continue
+			}
+			if _, err := game.Command(ent.Line); err != nil {
+				log.Fatalf("script command %q failed: %v", ent.Line, err)
+			}
+		}
+	}
+
+	goal := adventure.Goal{
+		Room: *roomFlag,
+		Item: *itemFlag,
+		Use:  *useFlag,
+		Text: *textFlag,
+	}
+
+	cmds, out, err := m.Execute(game, goal)
+	for _, cmd := range cmds {
+		fmt.Println(cmd)
+	}
+	fmt.Print(out)
+	if err != nil {
+		log.Fatalf("failed to reach goal: %v", err)
+	}
+
+	if *snapshotPath != "" {
+		if err := snapshot.WriteToPath(*snapshotPath, game.Save()); err != nil {
+			log.Fatalf("failed to write snapshot: %v", err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"adventure"
+	"annotations"
+	"comment"
+	"datascan"
+	"symtab"
+	"util"
+)
+
+var (
+	inputPath   = flag.String("input", "", "input in binary format")
+	emulate     = flag.Bool("emulate", false, "run the input until it first asks for input, and scan the resulting RAM")
+	symTabPath  = flag.String("symtab", "", "path to symbol table, which is included in the output")
+	annotPath   = flag.String("annotations", "", "path to annotations; replaces --symtab, and is included in the output")
+	outputPath  = flag.String("output", "", "where to write the results")
+	format      = flag.String("format", "annotations", "output format (annotations or symtab)")
+	minLen      = flag.Int("min_len", 4, "shortest plaintext string reported, unless referenced by a table")
+	minEntries  = flag.Int("min_entries", 2, "smallest string table reported")
+	iterateFlag = flag.String("iterate", "word_iterate", "word iteration function used to print encrypted strings; empty to skip them")
+	visitorFlag = flag.String("visitor", "xor_visitor", "visitor used to print encrypted strings")
+)
+
+func readImage() []uint16 {
+	image, err := util.ReadWordsFromPath(*inputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*emulate {
+		return image
+	}
+
+	g := adventure.NewGameFromImage(image)
+	if _, err := g.Run(); err != nil {
+		log.Fatalf("emulation failed: %v", err)
+	}
+	ram := g.RAM()
+	return ram[:]
+}
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		log.Fatalf("--input is required")
+	}
+	if *outputPath == "" {
+		log.Fatalf("--output is required")
+	}
+	if *format != "annotations" && *format != "symtab" {
+		log.Fatalf("--format must be annotations or symtab")
+	}
+
+	db := annotations.New()
+	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
+	switch {
+	case *annotPath != "":
+		var err error
+		if db, err = annotations.ReadFromPath(*annotPath); err != nil {
+			log.Fatalf("failed to read annotations: %v", err)
+		}
+		symTab = db.Symbols
+	case *symTabPath != "":
+		st, err := symtab.ReadFromPath(*symTabPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.Import(st, &comment.NullRegistry{}); err != nil {
+			log.Fatalf("failed to import symtab: %v", err)
+		}
+		symTab = db.Symbols
+	}
+
+	opts := datascan.Options{MinLen: *minLen, MinEntries: *minEntries}
+	if *iterateFlag != "" {
+		var err error
+		if opts.Iterate, err = util.NameToAddr(*iterateFlag, symTab); err != nil {
+			log.Fatalf("bad --iterate %v: %v", *iterateFlag, err)
+		}
+		if opts.Visitor, err = util.NameToAddr(*visitorFlag, symTab); err != nil {
+			log.Fatalf("bad --visitor %v: %v", *visitorFlag, err)
+		}
+	}
+
+	res := datascan.Scan(readImage(), opts)
+	for _, err := range res.Annotate(db, symTab) {
+		log.Printf("skipping: %v", err)
+	}
+	log.Printf("found %d strings and %d tables", len(res.Strings), len(res.Tables))
+
+	if *format == "symtab" {
+		if err := symtab.WriteToPath(*outputPath, db.Symbols); err != nil {
+			log.Fatalf("failed to write symtab: %v", err)
+		}
+		return
+	}
+	if err := db.WriteToPath(*outputPath); err != nil {
+		log.Fatalf("failed to write annotations: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -38,7 +39,8 @@ func TestNeighbors(t *testing.T) {
 		{Orb{Pos{3, 0}, 100}, nil},
 		{Orb{Pos{0, 1}, 100},
 			[]Orb{
-				Orb{Pos: Pos{X: 1, Y: 0}, Val: 108},
+				// Through either of the stars.
+				Orb{Pos: Pos{X: 1, Y: 0}, Val: 800},
 				Orb{Pos: Pos{X: 1, Y: 0}, Val: 800},
 				Orb{Pos: Pos{X: 1, Y: 2}, Val: 104},
 				Orb{Pos: Pos{X: 1, Y: 2}, Val: 400},
@@ -53,6 +55,11 @@ func TestNeighbors(t *testing.T) {
 			}},
 	}
 
+	board, _, _, err := ParseBoard(strings.NewReader(defaultGrid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("%d: %+v", i, test.start),
 			func(t *testing.T) {
@@ -66,3 +73,45 @@ func TestNeighbors(t *testing.T) {
 			})
 	}
 }
+
+func TestParseBoard(t *testing.T) {
+	board, start, end, err := ParseBoard(strings.NewReader("\n 5 * A\n D7 + 3\n"))
+	if err != nil {
+		t.Fatalf("ParseBoard = %v, want nil", err)
+	}
+
+	expected := [][]Cell{
+		{{CELL_VALUE, 5}, {CELL_STAR, 0}, {CELL_START, 0}},
+		{{CELL_END, 7}, {CELL_PLUS, 0}, {CELL_VALUE, 3}},
+	}
+	if !reflect.DeepEqual(board.cells, expected) || start != (Pos{2, 0}) || end != (Pos{0, 1}) {
+		t.Errorf("ParseBoard = %v, %v, %v, want %v, %v, %v",
+			board.cells, start, end, expected, Pos{2, 0}, Pos{0, 1})
+	}
+
+	for _, grid := range []string{
+		"A + 1\n2 D3",  // ragged
+		"A + 1\n2 - 3", // no door
+		"A + x\n2 - D3",
+		"A + A\n2 - D3",
+	} {
+		if _, _, _, err := ParseBoard(strings.NewReader(grid)); err == nil {
+			t.Errorf("ParseBoard(%q) = nil, want error", grid)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	board, startPos, _, err := ParseBoard(strings.NewReader(defaultGrid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := Orb{startPos, 22}
+	path := []Orb{start, {Pos{1, 2}, 26}, {Pos{2, 1}, 15}}
+	cmds := commands(board.route(path))
+	expected := []string{"go north", "go east", "go east", "go north"}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("commands(%v) = %v, want %v", path, cmds, expected)
+	}
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 
 	"astar"
 )
 
+var (
+	gridPath = flag.String("grid", "", "path to the vault grid; the challenge's vault if empty")
+	startVal = flag.Int("start", 22, "value of the orb at the start")
+	goalVal  = flag.Int("goal", 30, "value the orb must have at the door")
+	format   = flag.String("format", "commands", "output format: commands, script (for vm --script), or verbose")
+)
+
 type CellType int
 
 const (
@@ -61,29 +72,6 @@ type Orb struct {
 	Val int
 }
 
-func OrbFromString(str string) Orb {
-	parts := strings.Split(str, ",")
-
-	x, err := strconv.Atoi(parts[0])
-	if err != nil {
-		panic("bad x")
-	}
-	y, err := strconv.Atoi(parts[1])
-	if err != nil {
-		panic("bad y")
-	}
-	val, err := strconv.Atoi(parts[2])
-	if err != nil {
-		panic("bad val")
-	}
-
-	return Orb{Pos{x, y}, val}
-}
-
-func (o *Orb) ToString() string {
-	return fmt.Sprintf("%d,%d,%d", o.Pos.X, o.Pos.Y, o.Val)
-}
-
 type Board struct {
 	cells [][]Cell
 }
@@ -164,20 +152,95 @@ func (b *Board) Neighbors(start Orb) []Orb {
 	return out
 }
 
-var (
-	board = &Board{
-		cells: [][]Cell{
-			// *  8  - D1
-			// 4  * 11  *
-			// +  4  - 18
-			// A  -  9  *
-			[]Cell{Cell{CELL_STAR, 0}, Cell{CELL_VALUE, 8}, Cell{CELL_MINUS, 0}, Cell{CELL_END, 1}},
-			[]Cell{Cell{CELL_VALUE, 4}, Cell{CELL_STAR, 0}, Cell{CELL_VALUE, 11}, Cell{CELL_STAR, 0}},
-			[]Cell{Cell{CELL_PLUS, 0}, Cell{CELL_VALUE, 4}, Cell{CELL_MINUS, 0}, Cell{CELL_VALUE, 18}},
-			[]Cell{Cell{CELL_START, 0}, Cell{CELL_MINUS, 0}, Cell{CELL_VALUE, 9}, Cell{CELL_STAR, 0}},
-		},
+// The vault in the challenge. A is the start, where the orb is picked up,
+// and D1 is the door, which has value 1.
+const defaultGrid = `
+*  8  - D1
+4  * 11  *
++  4  - 18
+A  -  9  *
+`
+
+// ParseBoard reads a grid of whitespace-separated cells, one row per line.
+// Cells are operators (+, -, *), values, the start (A), or the door (D
+// followed by its value). It returns the board along with the positions of
+// the start and the door.
+func ParseBoard(r io.Reader) (*Board, Pos, Pos, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, Pos{}, Pos{}, err
 	}
-)
+
+	board := &Board{}
+	var start, end *Pos
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		y := len(board.cells)
+		if y > 0 && len(fields) != len(board.cells[0]) {
+			return nil, Pos{}, Pos{}, fmt.Errorf("row %d has %d cells, want %d",
+				y+1, len(fields), len(board.cells[0]))
+		}
+
+		row := []Cell{}
+		for x, field := range fields {
+			cell, err := parseCell(field)
+			if err != nil {
+				return nil, Pos{}, Pos{}, fmt.Errorf("row %d: %v", y+1, err)
+			}
+
+			switch cell.Type {
+			case CELL_START:
+				if start != nil {
+					return nil, Pos{}, Pos{}, fmt.Errorf("multiple starts")
+				}
+				start = &Pos{x, y}
+			case CELL_END:
+				if end != nil {
+					return nil, Pos{}, Pos{}, fmt.Errorf("multiple doors")
+				}
+				end = &Pos{x, y}
+			}
+
+			row = append(row, cell)
+		}
+		board.cells = append(board.cells, row)
+	}
+
+	if start == nil || end == nil {
+		return nil, Pos{}, Pos{}, fmt.Errorf("grid needs a start (A) and a door (D)")
+	}
+
+	return board, *start, *end, nil
+}
+
+func parseCell(str string) (Cell, error) {
+	switch {
+	case str == "+":
+		return Cell{CELL_PLUS, 0}, nil
+	case str == "-":
+		return Cell{CELL_MINUS, 0}, nil
+	case str == "*":
+		return Cell{CELL_STAR, 0}, nil
+	case str == "A":
+		return Cell{CELL_START, 0}, nil
+	case strings.HasPrefix(str, "D"):
+		val, err := strconv.Atoi(str[1:])
+		if err != nil {
+			return Cell{}, fmt.Errorf("bad door %q", str)
+		}
+		return Cell{CELL_END, val}, nil
+	}
+
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		return Cell{}, fmt.Errorf("bad cell %q", str)
+	}
+	return Cell{CELL_VALUE, val}, nil
+}
 
 func abs(v int) int {
 	if v < 0 {
@@ -190,31 +253,26 @@ func dist(x1, y1, x2, y2 int) int {
 	return abs(x1-x2) + abs(y1-y2)
 }
 
-type astarHelper struct{}
-
-func (h *astarHelper) AllNeighbors(start string) []string {
-	so := OrbFromString(start)
-
-	out := []string{}
-	for _, neighbor := range board.Neighbors(so) {
-		out = append(out, neighbor.ToString())
-	}
-	return out
+// orbGraph implements astar.Graph, searching for a path to goal.
+type orbGraph struct {
+	board *Board
+	goal  Orb
 }
 
-func (h *astarHelper) EstimateDistance(start, end string) uint {
-	so := OrbFromString(start)
-	eo := OrbFromString(end)
-
-	return uint(dist(so.Pos.X, so.Pos.Y, eo.Pos.X, eo.Pos.Y))
+func (g *orbGraph) Neighbors(o Orb) []Orb {
+	return g.board.Neighbors(o)
 }
 
-func (h *astarHelper) NeighborDistance(n1, n2 string) uint {
+func (g *orbGraph) Distance(from, to Orb) uint {
 	return 1
 }
 
-func (h *astarHelper) GoalReached(cand, goal string) bool {
-	return cand == goal
+func (g *orbGraph) Estimate(o Orb) uint {
+	return uint(dist(o.Pos.X, o.Pos.Y, g.goal.Pos.X, g.goal.Pos.Y))
+}
+
+func (g *orbGraph) IsGoal(o Orb) bool {
+	return o == g.goal
 }
 
 func advance(from, to Pos) []Pos {
@@ -238,73 +296,108 @@ func advance(from, to Pos) []Pos {
 	}
 }
 
-func checkOpPos(start Orb, cand Pos, end Orb) bool {
-	typ := board.get(cand).Type
+func (b *Board) checkOpPos(start Orb, cand Pos, end Orb) bool {
+	typ := b.get(cand).Type
 	if !typ.IsOp() {
 		//fmt.Printf("rejecting cand %+v; not op: %+v\n", cand, board.get(cand))
 		return false
 	}
 
 	//fmt.Printf("cand %+v\n", cand)
-	o := makeOrb(start, end.Pos, typ, board.get(end.Pos).Val)
+	o := makeOrb(start, end.Pos, typ, b.get(end.Pos).Val)
 	//fmt.Printf("start %+v cand %+v end %+v o %+v\n", start, cand, end, o)
 	return end.Val == o.Val
 }
 
-func findOpPos(start, end Orb) Pos {
+func (b *Board) findOpPos(start, end Orb) Pos {
 	for _, cand := range advance(start.Pos, end.Pos) {
-		if checkOpPos(start, cand, end) {
+		if b.checkOpPos(start, cand, end) {
 			return cand
 		}
 	}
 	panic("no route")
 }
 
-func main() {
-	start := Orb{Pos{0, 3}, 22}
-	goal := Orb{Pos{3, 0}, 30}
+func readBoard() (*Board, Pos, Pos, error) {
+	if *gridPath == "" {
+		return ParseBoard(strings.NewReader(defaultGrid))
+	}
 
-	path := astar.AStar(start.ToString(), goal.ToString(), &astarHelper{})
-	if path == nil {
-		log.Fatalf("no path")
+	fp, err := os.Open(*gridPath)
+	if err != nil {
+		return nil, Pos{}, Pos{}, err
 	}
+	defer fp.Close()
+
+	return ParseBoard(fp)
+}
 
+// route returns the orbs along path, interleaved with the operator cells
+// between them.
+func (b *Board) route(path []Orb) []Pos {
 	posns := []Pos{}
-	fmt.Println(path)
-	for i := len(path) - 1; i >= 0; i-- {
-		elem := OrbFromString(path[i])
+	for i, elem := range path {
 		posns = append(posns, elem.Pos)
-
-		fmt.Println(elem)
-		if i == 0 {
-			continue
+		if i < len(path)-1 {
+			posns = append(posns, b.findOpPos(elem, path[i+1]))
 		}
-
-		next := OrbFromString(path[i-1])
-		//fmt.Printf("start %v, next %v\n", start, next)
-
-		opPos := findOpPos(elem, next)
-		fmt.Println(opPos)
-		posns = append(posns, opPos)
 	}
+	return posns
+}
 
-	for i := range posns {
-		if i == len(posns)-1 {
-			continue
-		}
-
-		elem := posns[i]
-		next := posns[i+1]
+func commands(posns []Pos) []string {
+	cmds := []string{}
+	for i := 0; i < len(posns)-1; i++ {
+		elem, next := posns[i], posns[i+1]
 
-		fmt.Print("go ")
 		if elem.X < next.X {
-			fmt.Println("east")
+			cmds = append(cmds, "go east")
 		} else if elem.X > next.X {
-			fmt.Println("west")
+			cmds = append(cmds, "go west")
 		} else if elem.Y < next.Y {
-			fmt.Println("south")
+			cmds = append(cmds, "go south")
 		} else {
-			fmt.Println("north")
+			cmds = append(cmds, "go north")
 		}
 	}
+	return cmds
+}
+
+func main() {
+	flag.Parse()
+
+	board, startPos, endPos, err := readBoard()
+	if err != nil {
+		log.Fatalf("failed to read grid: %v", err)
+	}
+
+	start := Orb{startPos, *startVal}
+	goal := Orb{endPos, *goalVal}
+
+	path, _, err := astar.Search(start, &orbGraph{board, goal}, astar.Options[Orb]{})
+	if err != nil {
+		log.Fatalf("no path: %v", err)
+	}
+
+	posns := board.route(path)
+	cmds := commands(posns)
+
+	switch *format {
+	case "commands":
+		fmt.Println(strings.Join(cmds, "\n"))
+	case "script":
+		fmt.Printf("# vault: orb %d to %d in %d moves\n", start.Val, goal.Val, len(cmds))
+		fmt.Println(strings.Join(cmds, "\n"))
+	case "verbose":
+		fmt.Println(path)
+		for i, elem := range path {
+			fmt.Println(elem)
+			if i < len(path)-1 {
+				fmt.Println(posns[2*i+1])
+			}
+		}
+		fmt.Println(strings.Join(cmds, "\n"))
+	default:
+		log.Fatalf("unknown format %v", *format)
+	}
 }
@@ -1,76 +1,47 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-)
-
-var (
-	r8 = flag.Uint("r8", 32768, "r8")
+	"log"
+	"time"
 
-	level = 0
+	"teleporter"
 )
 
-func verify(a, b uint16) (uint16, uint16) {
-	//fmt.Printf("%*scalled %v,%v\n", level*2, "", a, b)
-	level++
-
-	if a == 0 {
-		a = (b + 1) % 32768
-		goto ret
-	}
-	if b == 0 {
-		a, b = callVerify((a+32767)%32768, uint16(*r8))
-		goto ret
-	}
-
-	b, _ = callVerify(a, (b+32767)%32768)
-
-	a, b = callVerify((a+32767)%32768, b)
-
-ret:
-	level--
-	//fmt.Printf("%*sreturning %v,%v\n", level*2, "", a, b)
-	return a, b
-}
-
-type Pair struct {
-	a, b uint16
-}
-
 var (
-	seen = map[Pair]Pair{}
+	r8      = flag.Uint("r8", 32768, "evaluate for this r8 rather than searching")
+	workers = flag.Int("workers", 0, "number of search goroutines; the number of CPUs if zero")
+	timeout = flag.Duration("timeout", 0, "give up searching after this long, if non-zero")
 )
 
-func callVerify(a, b uint16) (uint16, uint16) {
-	in := Pair{a, b}
-	if ret, found := seen[in]; found {
-		return ret.a, ret.b
-	}
-
-	var res Pair
-	res.a, res.b = verify(a, b)
-	seen[in] = res
-	return res.a, res.b
-}
-
 func main() {
 	flag.Parse()
 
+	start := time.Now()
+
 	if *r8 != 32768 {
-		a, b := verify(4, 1)
-		fmt.Printf("r8=%d a=%d, b=%d\n", *r8, a, b)
+		a := teleporter.NewEvaluator().Eval(4, 1, uint16(*r8))
+		fmt.Printf("r8=%d a=%d (%v)\n", *r8, a, time.Since(start))
 		return
 	}
 
-	for v := 1; v < 32768; v++ {
-		*r8 = uint(v)
-		seen = map[Pair]Pair{}
-		a, _ := verify(4, 1)
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-		fmt.Printf("r8=%d a=%d\n", *r8, a)
-		if a == 6 {
-			break
-		}
+	opts := teleporter.SearchOptions{A: 4, B: 1, Want: 6, Workers: *workers}
+	found, ok, err := teleporter.Search(ctx, opts)
+	if err != nil {
+		log.Fatalf("search failed after %v: %v", time.Since(start), err)
 	}
+	if !ok {
+		log.Fatalf("no r8 found after %v", time.Since(start))
+	}
+
+	fmt.Printf("r8=%d (%v)\n", found, time.Since(start))
 }
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"input"
+	"instruction"
+	"journal"
+	"memory"
+	"symtab"
+	"util"
+	"watch"
+)
+
+type resumeMode int
+
+const (
+	resumeContinue resumeMode = iota
+	resumeStep
+	resumeUntilDepth
+)
+
+type frame struct {
+	callSite, target uint16
+}
+
+type debugger struct {
+	ctx    *instruction.Context
+	symTab symtab.SymTab
+	in     *input.Reader
+	out    io.Writer
+
+	breakpoints map[uint16]int
+	nextBPNum   int
+
+	// Shadow call stack, maintained by watching call and ret
+	// instructions. The VM stack mixes return addresses with pushed
+	// values, so it can't be used for backtraces directly.
+	frames []frame
+
+	// If non-nil, used to step backwards. retFrames records the frame
+	// popped by each ret, so it can be restored when the ret is undone.
+	journal   *journal.Journal
+	retFrames []*frame
+
+	// If non-nil, the watchpoints managed by the watch commands.
+	watches *watch.Set
+
+	// If non-nil, called after commands have had a chance to change RAM,
+	// since set and undo write to it directly.
+	ramChanged func()
+
+	mode      resumeMode
+	stepsLeft int
+	depth     int
+
+	interrupted bool
+	stopped     bool
+	lastCmd     string
+	lastOp      uint16
+}
+
+func newDebugger(ctx *instruction.Context, symTab symtab.SymTab, in *input.Reader, out io.Writer, stopAtStart bool) *debugger {
+	d := &debugger{
+		ctx:         ctx,
+		symTab:      symTab,
+		in:          in,
+		out:         out,
+		breakpoints: map[uint16]int{},
+		nextBPNum:   1,
+		mode:        resumeContinue,
+	}
+	if stopAtStart {
+		d.mode, d.stepsLeft = resumeStep, 1
+	}
+	return d
+}
+
+func (d *debugger) AddBreakpoint(addr uint16) int {
+	if num, found := d.breakpoints[addr]; found {
+		return num
+	}
+
+	num := d.nextBPNum
+	d.nextBPNum++
+	d.breakpoints[addr] = num
+	return num
+}
+
+// Interrupt causes the debugger to stop before the next instruction.
+func (d *debugger) Interrupt() {
+	d.interrupted = true
+}
+
+// Stop is like Interrupt, but doesn't announce the stop. It's used when
+// the caller has already said why execution stopped.
+func (d *debugger) Stop() {
+	d.stopped = true
+}
+
+func (d *debugger) shouldStop(pc uint16) bool {
+	if d.stopped {
+		d.stopped = false
+		return true
+	}
+
+	if d.interrupted {
+		d.interrupted = false
+		fmt.Fprintln(d.out, "interrupted")
+		return true
+	}
+
+	if num, found := d.breakpoints[pc]; found {
+		fmt.Fprintf(d.out, "breakpoint %d, %s\n", num, util.AddrToName(pc, d.symTab))
+		return true
+	}
+
+	switch d.mode {
+	case resumeStep:
+		d.stepsLeft--
+		return d.stepsLeft <= 0
+	case resumeUntilDepth:
+		return len(d.frames) <= d.depth
+	default:
+		return false
+	}
+}
+
+// SetJournal enables the reverse execution commands.
+func (d *debugger) SetJournal(j *journal.Journal) {
+	d.journal = j
+}
+
+// SetWatches enables the watchpoint commands.
+func (d *debugger) SetWatches(w *watch.Set) {
+	d.watches = w
+}
+
+// SetRAMChanged sets a function to call when RAM may have been changed by
+// a command.
+func (d *debugger) SetRAMChanged(f func()) {
+	d.ramChanged = f
+}
+
+// Check is called before the instruction at pc is executed. It returns the
+// pc to execute next (which reverse execution may have changed), and true
+// if the user asked to quit.
+func (d *debugger) Check(pc uint16) (uint16, bool) {
+	if d.shouldStop(pc) {
+		d.printInst(pc)
+
+		var quit bool
+		pc, quit = d.repl(pc)
+		if d.ramChanged != nil {
+			d.ramChanged()
+		}
+		if quit {
+			return pc, true
+		}
+	}
+
+	d.lastOp = d.ctx.RAM.Read(pc)
+	return pc, false
+}
+
+// Executed is called after the instruction at pc has been executed.
+func (d *debugger) Executed(pc uint16, cb *instruction.CB) {
+	switch d.lastOp {
+	case instruction.OpCall:
+		d.frames = append(d.frames, frame{callSite: pc, target: cb.NPC})
+	case instruction.OpRet:
+		var popped *frame
+		if len(d.frames) > 0 {
+			f := d.frames[len(d.frames)-1]
+			popped = &f
+			d.frames = d.frames[0 : len(d.frames)-1]
+		}
+		if d.journal != nil {
+			d.retFrames = append(d.retFrames, popped)
+		}
+	}
+}
+
+// undo reverses one step, returning the pc of the undone instruction.
+func (d *debugger) undo() (uint16, bool) {
+	pc, ok := d.journal.Undo(d.ctx)
+	if !ok {
+		return 0, false
+	}
+
+	switch d.ctx.RAM.Read(pc) {
+	case instruction.OpCall:
+		if len(d.frames) > 0 {
+			d.frames = d.frames[0 : len(d.frames)-1]
+		}
+	case instruction.OpRet:
+		if n := len(d.retFrames); n > 0 {
+			if popped := d.retFrames[n-1]; popped != nil {
+				d.frames = append(d.frames, *popped)
+			}
+			d.retFrames = d.retFrames[0 : n-1]
+		}
+	}
+
+	return pc, true
+}
+
+// reverse runs backwards until stop returns true or the journal is
+// exhausted, returning the new pc.
+func (d *debugger) reverse(pc uint16, stop func(pc uint16, n int) bool) uint16 {
+	for n := 1; ; n++ {
+		prev, ok := d.undo()
+		if !ok {
+			fmt.Fprintln(d.out, "no more history")
+			return pc
+		}
+		pc = prev
+
+		if stop(pc, n) {
+			return pc
+		}
+	}
+}
+
+func (d *debugger) printInst(addr uint16) {
+	inst, _, err := instruction.Read(memory.NewRAMReader(d.ctx.RAM, addr))
+	if err != nil {
+		fmt.Fprintf(d.out, "%30s:  error: %v\n", util.AddrToName(addr, d.symTab), err)
+		return
+	}
+	fmt.Fprintf(d.out, "%30s:  %s\n", util.AddrToName(addr, d.symTab), inst.ToString(d.symTab))
+}
+
+func (d *debugger) repl(pc uint16) (uint16, bool) {
+	for {
+		fmt.Fprint(d.out, "(dbg) ")
+		line, err := d.in.ReadLine()
+		if err != nil {
+			fmt.Fprintln(d.out)
+			return pc, true
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = d.lastCmd
+		} else {
+			d.lastCmd = line
+		}
+		if line == "" {
+			continue
+		}
+
+		newPC, resume, quit, err := d.exec(pc, line)
+		if err != nil {
+			fmt.Fprintf(d.out, "%v\n", err)
+			continue
+		} else if quit {
+			return pc, true
+		} else if resume {
+			return pc, false
+		}
+
+		if newPC != pc {
+			pc = newPC
+			d.printInst(pc)
+		}
+	}
+}
+
+var (
+	examinePattern = regexp.MustCompile(`^x(?:/(\d*)([wi]?))?$`)
+	setPattern     = regexp.MustCompile(`^(\S+?)\s*=\s*(\d+)$`)
+)
+
+func parseCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad count %v", args[0])
+	}
+	return n, nil
+}
+
+func parseReg(str string) (uint, bool) {
+	if len(str) < 2 || str[0] != 'r' {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(str[1:], 10, 8)
+	if err != nil || num < 1 || num > 8 {
+		return 0, false
+	}
+	return uint(num), true
+}
+
+func resolve(pc uint16, loc string, symTab symtab.SymTab) (uint16, error) {
+	if loc == "pc" || loc == "$pc" {
+		return pc, nil
+	}
+	return util.NameToAddr(loc, symTab)
+}
+
+func (d *debugger) resolve(pc uint16, loc string) (uint16, error) {
+	return resolve(pc, loc, d.symTab)
+}
+
+// resolveRAM is resolve for locations that will be read or written, so
+// must be within RAM.
+func resolveRAM(pc uint16, loc string, symTab symtab.SymTab) (uint16, error) {
+	addr, err := resolve(pc, loc, symTab)
+	if err != nil {
+		return 0, err
+	}
+	if addr >= memory.Size {
+		return 0, fmt.Errorf("address %v out of range", addr)
+	}
+	return addr, nil
+}
+
+// setValue sets a register or memory location given a spec like r8=1 or
+// loc=val.
+func setValue(ctx *instruction.Context, symTab symtab.SymTab, pc uint16, spec string) error {
+	parts := setPattern.FindStringSubmatch(spec)
+	if parts == nil {
+		return fmt.Errorf("usage: set reg|loc=val")
+	}
+	val, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return fmt.Errorf("bad value %v", parts[2])
+	}
+	if num, ok := parseReg(parts[1]); ok {
+		ctx.RegFile.Set(num, uint16(val))
+		return nil
+	}
+	addr, err := resolveRAM(pc, parts[1], symTab)
+	if err != nil {
+		return fmt.Errorf("bad location: %v", err)
+	}
+	ctx.RAM.Write(addr, uint16(val))
+	return nil
+}
+
+// exec runs a single debugger command. It returns the new pc, and
+// resume=true if execution should continue.
+func (d *debugger) exec(pc uint16, line string) (newPC uint16, resume, quit bool, err error) {
+	fields := strings.Fields(line)
+	cmd, args := fields[0], fields[1:]
+
+	switch cmd {
+	case "b", "break":
+		addr := pc
+		if len(args) > 0 {
+			if addr, err = d.resolve(pc, args[0]); err != nil {
+				return pc, false, false, fmt.Errorf("bad location: %v", err)
+			}
+		}
+		num := d.AddBreakpoint(addr)
+		fmt.Fprintf(d.out, "breakpoint %d at %s\n", num, util.AddrToName(addr, d.symTab))
+
+	case "d", "delete":
+		if len(args) == 0 {
+			d.breakpoints = map[uint16]int{}
+			return pc, false, false, nil
+		}
+		num, err := strconv.Atoi(args[0])
+		if err != nil {
+			return pc, false, false, fmt.Errorf("bad breakpoint number %v", args[0])
+		}
+		for addr, bpNum := range d.breakpoints {
+			if bpNum == num {
+				delete(d.breakpoints, addr)
+				return pc, false, false, nil
+			}
+		}
+		return pc, false, false, fmt.Errorf("no breakpoint %d", num)
+
+	case "s", "step":
+		n, err := parseCount(args)
+		if err != nil {
+			return pc, false, false, err
+		}
+		d.mode, d.stepsLeft = resumeStep, n
+		return pc, true, false, nil
+
+	case "n", "next":
+		d.mode, d.depth = resumeUntilDepth, len(d.frames)
+		return pc, true, false, nil
+
+	case "fin", "finish":
+		if len(d.frames) == 0 {
+			return pc, false, false, fmt.Errorf("finish not meaningful in the outermost frame")
+		}
+		d.mode, d.depth = resumeUntilDepth, len(d.frames)-1
+		return pc, true, false, nil
+
+	case "c", "continue":
+		d.mode = resumeContinue
+		return pc, true, false, nil
+
+	case "bt", "backtrace":
+		d.backtrace(pc)
+
+	case "i", "info":
+		if len(args) == 0 {
+			return pc, false, false, fmt.Errorf("info what?")
+		}
+		switch args[0] {
+		case "b", "break":
+			d.infoBreak()
+		case "r", "reg", "registers":
+			d.ctx.RegFile.Dump(d.out)
+		case "w", "watch":
+			d.infoWatch()
+		default:
+			return pc, false, false, fmt.Errorf("unknown info %v", args[0])
+		}
+
+	case "p", "print":
+		if len(args) != 1 {
+			return pc, false, false, fmt.Errorf("usage: print reg|loc")
+		}
+		if num, ok := parseReg(args[0]); ok {
+			fmt.Fprintf(d.out, "r%d = %d\n", num, d.ctx.RegFile.Get(num))
+			return pc, false, false, nil
+		}
+		addr, err := resolveRAM(pc, args[0], d.symTab)
+		if err != nil {
+			return pc, false, false, fmt.Errorf("bad location: %v", err)
+		}
+		fmt.Fprintf(d.out, "%s = %d\n", util.AddrToName(addr, d.symTab), d.ctx.RAM.Read(addr))
+
+	case "set":
+		if err := setValue(d.ctx, d.symTab, pc, strings.Join(args, " ")); err != nil {
+			return pc, false, false, err
+		}
+
+	case "save":
+		if len(args) != 1 {
+			return pc, false, false, fmt.Errorf("usage: save path")
+		}
+		if err := saveSnapshot(args[0], d.ctx, pc, d.in); err != nil {
+			return pc, false, false, err
+		}
+		fmt.Fprintf(d.out, "saved snapshot to %v\n", args[0])
+
+	case "rs", "reverse-step":
+		if d.journal == nil {
+			return pc, false, false, fmt.Errorf("reverse execution requires --journal")
+		}
+		n, err := parseCount(args)
+		if err != nil {
+			return pc, false, false, err
+		}
+		return d.reverse(pc, func(pc uint16, i int) bool { return i >= n }), false, false, nil
+
+	case "rc", "reverse-continue":
+		if d.journal == nil {
+			return pc, false, false, fmt.Errorf("reverse execution requires --journal")
+		}
+		return d.reverse(pc, func(pc uint16, i int) bool {
+			if num, found := d.breakpoints[pc]; found {
+				fmt.Fprintf(d.out, "breakpoint %d, %s\n", num, util.AddrToName(pc, d.symTab))
+				return true
+			}
+			return false
+		}), false, false, nil
+
+	case "whowrote", "rwrite":
+		if d.journal == nil {
+			return pc, false, false, fmt.Errorf("%v requires --journal", cmd)
+		}
+		if len(args) != 1 {
+			return pc, false, false, fmt.Errorf("usage: %v reg|loc", cmd)
+		}
+
+		var w journal.Write
+		var found bool
+		if num, ok := parseReg(args[0]); ok {
+			w, found = d.journal.LastRegWrite(num)
+		} else {
+			addr, err := d.resolve(pc, args[0])
+			if err != nil {
+				return pc, false, false, fmt.Errorf("bad location: %v", err)
+			}
+			w, found = d.journal.LastMemWrite(addr)
+		}
+		if !found {
+			return pc, false, false, fmt.Errorf("no write to %v in history", args[0])
+		}
+
+		fmt.Fprintf(d.out, "%v written by %s %d steps ago: %d -> %d\n",
+			args[0], util.AddrToName(w.PC, d.symTab), w.StepsAgo, w.Old, w.Val)
+		if cmd == "rwrite" {
+			return d.reverse(pc, func(pc uint16, i int) bool { return i >= w.StepsAgo }), false, false, nil
+		}
+
+	case "w", "watch":
+		if len(args) != 1 {
+			return pc, false, false, fmt.Errorf("usage: watch reg|[r|w|rw:]loc[-loc]")
+		}
+		wp, err := d.watches.Add(args[0], watch.Pause, d.symTab)
+		if err != nil {
+			return pc, false, false, err
+		}
+		fmt.Fprintf(d.out, "watchpoint %d: %v\n", wp.Num, wp)
+
+	case "unwatch":
+		if len(args) != 1 {
+			return pc, false, false, fmt.Errorf("usage: unwatch num")
+		}
+		num, err := strconv.Atoi(args[0])
+		if err != nil {
+			return pc, false, false, fmt.Errorf("bad watchpoint number %v", args[0])
+		}
+		if !d.watches.Delete(num) {
+			return pc, false, false, fmt.Errorf("no watchpoint %v", num)
+		}
+
+	case "q", "quit":
+		return pc, false, true, nil
+
+	case "h", "help":
+		d.help()
+
+	default:
+		if parts := examinePattern.FindStringSubmatch(cmd); parts != nil {
+			return pc, false, false, d.examine(pc, parts[1], parts[2], args)
+		}
+		return pc, false, false, fmt.Errorf("unknown command %v; try help", cmd)
+	}
+
+	return pc, false, false, nil
+}
+
+func (d *debugger) examine(pc uint16, countStr, format string, args []string) error {
+	count := 1
+	if countStr != "" {
+		var err error
+		if count, err = strconv.Atoi(countStr); err != nil {
+			return fmt.Errorf("bad count %v", countStr)
+		}
+	}
+
+	addr := pc
+	if len(args) > 0 {
+		var err error
+		if addr, err = d.resolve(pc, args[0]); err != nil {
+			return fmt.Errorf("bad location: %v", err)
+		}
+	}
+
+	for i := 0; i < count && int(addr) < len(d.ctx.RAM); i++ {
+		if format == "i" {
+			reader := memory.NewRAMReader(d.ctx.RAM, addr)
+			_, numRead, err := instruction.Read(reader)
+			d.printInst(addr)
+			if err != nil {
+				numRead = 1
+			}
+			addr += uint16(numRead)
+			continue
+		}
+
+		fmt.Fprintf(d.out, "%30s:  %5d\n", util.AddrToName(addr, d.symTab), d.ctx.RAM.Read(addr))
+		addr++
+	}
+
+	return nil
+}
+
+func (d *debugger) backtrace(pc uint16) {
+	fmt.Fprintf(d.out, "#0  %s\n", util.AddrToName(pc, d.symTab))
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		f := d.frames[i]
+		fmt.Fprintf(d.out, "#%-2d %s (called %s)\n", len(d.frames)-i,
+			util.AddrToName(f.callSite, d.symTab), util.AddrToName(f.target, d.symTab))
+	}
+}
+
+func (d *debugger) infoBreak() {
+	addrs := []int{}
+	for addr := range d.breakpoints {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return d.breakpoints[uint16(addrs[i])] < d.breakpoints[uint16(addrs[j])]
+	})
+
+	for _, addr := range addrs {
+		fmt.Fprintf(d.out, "%-3d %s\n", d.breakpoints[uint16(addr)],
+			util.AddrToName(uint16(addr), d.symTab))
+	}
+}
+
+func (d *debugger) infoWatch() {
+	for _, wp := range d.watches.Watchpoints() {
+		fmt.Fprintf(d.out, "%-3d %-20v %v\n", wp.Num, wp, wp.Action)
+	}
+}
+
+func (d *debugger) help() {
+	fmt.Fprint(d.out, `break [loc]         set breakpoint (default: pc)
+delete [num]        delete breakpoint (default: all)
+step [n]            execute n instructions, entering calls
+next                execute one instruction, stepping over calls
+finish              run until the current function returns
+continue            run until breakpoint or interrupt
+backtrace           show call stack
+info break|reg|watch
+                    show breakpoints, registers, or watchpoints
+print reg|loc       print register or memory value
+set reg|loc=val     set register or memory value
+x/N[w|i] [loc]      examine N words or instructions (default: pc)
+save path           write a snapshot of the vm state
+reverse-step [n]    undo n instructions (needs --journal)
+reverse-continue    run backwards until breakpoint
+whowrote reg|loc    show the last write to reg or loc
+rwrite reg|loc      run backwards to the last write to reg or loc
+watch spec          stop when reg (r1-r8) changes, or when [r|w|rw:]loc[-loc]
+                    is read or written (default: w)
+unwatch num         delete watchpoint
+quit                exit the vm
+`)
+}
@@ -8,20 +8,29 @@ import (
 	"math"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 
+	"annotations"
+	"device"
+	"input"
 	"instruction"
+	"journal"
 	"memory"
-	"register"
+	"patch"
+	"profile"
+	"snapshot"
 	"symtab"
+	"transcript"
 	"util"
+	"vm"
+	"watch"
 )
 
 var (
 	ramPath         = flag.String("ram", "", "ram data load")
 	symTabPath      = flag.String("symtab", "", "path to symbol table")
+	annotPath       = flag.String("annotations", "", "path to annotations, for symbols; replaces --symtab")
 	verbose         = flag.Bool("verbose", false, "verbose")
 	verboseFilePath = flag.String("verbose_file", "", "where to write verbose output; stdout if empty")
 	dumpReg         = flag.Bool("dump_reg", false, "in verbose, dump registers after each instruction")
@@ -30,186 +39,471 @@ var (
 	haltPCFlag      = flag.String("halt_pc", "", "halt after executing this instruction")
 	ramDumpPath     = flag.String("ram_dump", "", "where to dump ram on halt")
 	overrideRAM     = flag.String("override_ram", "", "force some RAM values at start, as addr=val,addr=val,...")
+	patchPath       = flag.String("patch", "", "patch file to apply to RAM and registers at start")
+	debug           = flag.Bool("debug", false, "start in the interactive debugger")
+	breakFlag       = flag.String("break", "", "debugger breakpoints, as loc,loc,...")
+	restorePath     = flag.String("restore", "", "resume from this snapshot instead of --ram")
+	snapshotPath    = flag.String("snapshot", "", "where to write a snapshot on SIGUSR1")
+	scriptPath      = flag.String("script", "", "input script to run before reading stdin")
+	transcriptPath  = flag.String("transcript", "", "where to record a transcript of input and output")
+	replayPath      = flag.String("replay", "", "replay the input in this transcript, checking the output against it")
+	journalSteps    = flag.Int("journal", 0, "remember this many steps for reverse execution in the debugger")
+	profilePath     = flag.String("profile", "", "where to write an execution profile on exit")
+	profileTop      = flag.Int("profile_top", 50, "number of addresses to list in the profile")
+	coveragePath    = flag.String("coverage", "", "where to write executed addresses, for dis --coverage")
+	watchFlag       = flag.String("watch", "", "watchpoints, as spec,spec,... where spec is a register (r1-r8) or [r|w|rw:]loc[-loc]")
+	watchPause      = flag.Bool("watch_pause", false, "stop in the debugger on watchpoint hits instead of logging them")
 )
 
-func applyRAMOverrides(ram *memory.RAM, overrides string) error {
-	for _, pair := range strings.Split(overrides, ",") {
-		parts := strings.SplitN(pair, "=", 2)
-		if len(parts) != 2 {
-			return fmt.Errorf("bad pair %v", pair)
+func readSymTabOrDie() symtab.SymTab {
+	if *annotPath != "" {
+		if *symTabPath != "" {
+			log.Fatalf("--annotations can't be used with --symtab")
 		}
-
-		addr, err := strconv.ParseUint(parts[0], 10, 16)
+		db, err := annotations.ReadFromPath(*annotPath)
 		if err != nil {
-			return fmt.Errorf("bad addr %v in pair %v", parts[0], pair)
+			log.Fatalf("failed to read annotations: %v", err)
 		}
+		return db.Symbols
+	}
 
-		val, err := strconv.ParseUint(parts[1], 10, 16)
+	if *symTabPath != "" {
+		symTab, err := symtab.ReadFromPath(*symTabPath)
 		if err != nil {
-			return fmt.Errorf("bad val %v in pair %v", parts[1], pair)
+			log.Fatal(err)
 		}
+		return symTab
+	}
 
-		ram.Write(uint16(addr), uint16(val))
+	return &symtab.NoEntriesSymTab{}
+}
+
+func parsePCFlagsOrDie(symTab symtab.SymTab) (startPC, haltPC uint16) {
+	if *startPCFlag != "" {
+		var err error
+		if startPC, err = util.NameToAddr(*startPCFlag, symTab); err != nil {
+			log.Fatalf("invalid --start_pc value: %v", err)
+		}
 	}
 
-	return nil
+	haltPC = math.MaxUint16
+	if *haltPCFlag != "" {
+		var err error
+		if haltPC, err = util.NameToAddr(*haltPCFlag, symTab); err != nil {
+			log.Fatalf("invalid --halt_pc value: %v", err)
+		}
+	}
+
+	return
 }
 
-func initRAM(path string) (*memory.RAM, error) {
-	fp, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func saveSnapshot(path string, ctx *instruction.Context, pc uint16, in *input.Reader) error {
+	snap := snapshot.Capture(ctx.RAM, ctx.RegFile, ctx.Stack, pc, in.Pending())
+	if err := snapshot.WriteToPath(path, snap); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
 	}
-	defer fp.Close()
+	return nil
+}
 
-	ram := &memory.RAM{}
+func readScriptOrDie() (script *input.Script, expectedOutput string) {
+	if *scriptPath != "" && *replayPath != "" {
+		log.Fatalf("--script and --replay are mutually exclusive")
+	}
 
-	var b [2]byte
-	for off := 0; ; off++ {
-		n, _ := fp.Read(b[:])
-		if n != 2 {
-			break
+	if *scriptPath != "" {
+		script, err := input.ReadScriptFromPath(*scriptPath)
+		if err != nil {
+			log.Fatalf("failed to read script: %v", err)
 		}
+		return script, ""
+	}
 
-		var val uint16
-		val |= uint16(b[0])
-		val |= (uint16(b[1]) << 8)
+	if *replayPath != "" {
+		entries, err := transcript.ReadFromPath(*replayPath)
+		if err != nil {
+			log.Fatalf("failed to read transcript: %v", err)
+		}
 
-		ram.Write(uint16(off), val)
+		// Only directives which change machine state affect the replay.
+		script, err := transcript.Script(entries, func(d input.Directive) bool {
+			return d.Name == "set"
+		})
+		if err != nil {
+			log.Fatalf("failed to build replay script: %v", err)
+		}
+		return script, transcript.Output(entries)
 	}
 
-	return ram, nil
+	return nil, ""
 }
 
-func parsePCFlagsOrDie(symTab symtab.SymTab) (startPC, haltPC uint16) {
-	if *startPCFlag != "" {
-		var err error
-		if startPC, err = util.NameToAddr(*startPCFlag, symTab); err != nil {
-			log.Fatalf("invalid --start_pc value: %v", err)
+func handleDirective(d input.Directive, m *vm.Machine, symTab symtab.SymTab, in *input.Reader, dbg *debugger) error {
+	switch d.Name {
+	case "pause":
+		dbg.Interrupt()
+	case "snapshot":
+		if len(d.Args) != 1 {
+			return fmt.Errorf("usage: snapshot path")
 		}
+		return saveSnapshot(d.Args[0], m.Context(), m.PC, in)
+	case "set":
+		if len(d.Args) == 0 {
+			return fmt.Errorf("usage: set reg|loc=val,...")
+		}
+		for _, spec := range strings.Split(strings.Join(d.Args, ""), ",") {
+			if err := setValue(m.Context(), symTab, m.PC, spec); err != nil {
+				return err
+			}
+		}
+		m.FlushCache()
+	default:
+		return fmt.Errorf("unknown directive")
 	}
+	return nil
+}
 
-	haltPC = math.MaxUint16
-	if *haltPCFlag != "" {
-		var err error
-		if haltPC, err = util.NameToAddr(*haltPCFlag, symTab); err != nil {
-			log.Fatalf("invalid --start_pc value: %v", err)
+func newDebuggerOrDie(iCtx *instruction.Context, symTab symtab.SymTab, in *input.Reader) *debugger {
+	dbg := newDebugger(iCtx, symTab, in, os.Stdout, *debug)
+	if *breakFlag != "" {
+		for _, loc := range strings.Split(*breakFlag, ",") {
+			addr, err := util.NameToAddr(loc, symTab)
+			if err != nil {
+				log.Fatalf("invalid --break location %v: %v", loc, err)
+			}
+			dbg.AddBreakpoint(addr)
 		}
 	}
+	return dbg
+}
 
-	return
+func newWatchesOrDie(symTab symtab.SymTab) *watch.Set {
+	action := watch.Log
+	if *watchPause {
+		action = watch.Pause
+	}
+
+	watches := watch.NewSet()
+	if *watchFlag != "" {
+		for _, spec := range strings.Split(*watchFlag, ",") {
+			if _, err := watches.Add(spec, action, symTab); err != nil {
+				log.Fatalf("invalid --watch spec %v: %v", spec, err)
+			}
+		}
+	}
+	return watches
 }
 
-func main() {
-	flag.Parse()
+func writeProfile(prof *profile.Profile, symTab symtab.SymTab) {
+	prof.Finish()
 
-	if *ramPath == "" {
-		log.Fatalf("--ram is required")
+	if *profilePath != "" {
+		if err := prof.WriteReportToPath(*profilePath, symTab, *profileTop); err != nil {
+			log.Printf("failed to write profile: %v", err)
+		}
 	}
+	if *coveragePath != "" {
+		if err := prof.Coverage().WriteToPath(*coveragePath); err != nil {
+			log.Printf("failed to write coverage: %v", err)
+		}
+	}
+}
 
-	ram, err := initRAM(*ramPath)
-	if err != nil {
-		log.Fatal(err)
+func newMachineOrDie(symTab symtab.SymTab, in *input.Reader, startPC uint16) *vm.Machine {
+	m := vm.New(nil)
+
+	if *restorePath != "" {
+		snap, err := snapshot.ReadFromPath(*restorePath)
+		if err != nil {
+			log.Fatalf("failed to read snapshot: %v", err)
+		}
+
+		m.Restore(snap)
+		in.SetPending(snap.Input)
+	} else {
+		if *ramPath == "" {
+			log.Fatalf("--ram or --restore is required")
+		}
+
+		if err := m.LoadFromPath(*ramPath); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	if *overrideRAM != "" {
-		if err := applyRAMOverrides(ram, *overrideRAM); err != nil {
+		if err := m.ApplyRAMOverrides(*overrideRAM); err != nil {
 			log.Fatalf("failed to apply RAM overrides: %v", err)
 		}
 	}
 
-	var symTab symtab.SymTab = &symtab.NoEntriesSymTab{}
-	if *symTabPath != "" {
-		var err error
-		if symTab, err = symtab.ReadFromPath(*symTabPath); err != nil {
-			log.Fatal(err)
+	if *patchPath != "" {
+		p, err := patch.ReadFromPath(*patchPath, symTab)
+		if err != nil {
+			log.Fatalf("failed to read patch: %v", err)
+		}
+		if err := p.Apply(m.RAM(), m.RegFile()); err != nil {
+			log.Fatalf("failed to apply patch: %v", err)
 		}
+		m.FlushCache()
 	}
 
-	regFile := &register.File{}
 	if *initReg != "" {
-		var err error
-		regFile, err = register.InitFromSpec(*initReg)
-		if err != nil {
+		if err := m.RegFile().ApplySpec(*initReg); err != nil {
 			log.Fatalf("failed to init registers: %v", err)
 		}
 	}
 
-	startPC, haltPC := parsePCFlagsOrDie(symTab)
+	if *restorePath == "" || *startPCFlag != "" {
+		m.PC = startPC
+	}
 
-	hupChan := make(chan os.Signal, 1)
-	signal.Notify(hupChan, syscall.SIGHUP)
+	return m
+}
 
-	var verboseWriter io.Writer
-	if *verboseFilePath != "" {
-		verboseFile, err := os.OpenFile(*verboseFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatalf("failed to open verbose file %v: %v", *verboseFilePath, err)
-		}
-		defer verboseFile.Close()
+func openVerboseOrDie() (w io.Writer, closer func()) {
+	if *verboseFilePath == "" {
+		return os.Stdout, func() {}
+	}
 
-		verboseWriter = verboseFile
-	} else {
-		verboseWriter = os.Stdout
+	verboseFile, err := os.OpenFile(*verboseFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("failed to open verbose file %v: %v", *verboseFilePath, err)
 	}
+	return verboseFile, func() { verboseFile.Close() }
+}
 
-	stack := memory.NewStack()
+// output is where the machine's output goes: the terminal, plus a
+// transcript and a replay checker if requested.
+type output struct {
+	w                io.Writer
+	checker          *transcript.Checker
+	transcriptWriter *transcript.Writer
+	transcriptFile   *os.File
+}
 
-	iCtx := instruction.Context{
-		RAM:           ram,
-		RegFile:       regFile,
-		Stack:         stack,
-		Verbose:       verbose,
-		VerboseWriter: verboseWriter,
+func newOutputOrDie(in *input.Reader, expectedOutput string) *output {
+	o := &output{w: os.Stdout}
+	if *replayPath != "" {
+		o.checker = transcript.NewChecker(expectedOutput, o.w)
+		o.w = o.checker
 	}
 
-	pc := startPC
-	for {
-		select {
-		case <-hupChan:
-			*verbose = !*verbose
-			fmt.Printf("verbose now %v\n", *verbose)
-		default:
+	if *transcriptPath != "" {
+		var err error
+		if o.transcriptFile, err = os.Create(*transcriptPath); err != nil {
+			log.Fatalf("failed to create transcript: %v", err)
 		}
 
-		reader := memory.NewRAMReader(ram, pc)
+		o.transcriptWriter = transcript.NewWriter(o.transcriptFile)
+		o.w = io.MultiWriter(o.w, o.transcriptWriter)
+		in.SetRecorder(o.transcriptWriter)
+	}
 
-		inst, numRead, err := instruction.Read(reader)
-		if err != nil {
-			log.Fatalf("bad inst read at %v: %v", pc, err)
-		}
+	return o
+}
 
-		if *verbose {
-			fmt.Fprintf(verboseWriter, "%30s: %s\n", util.AddrToName(pc, symTab),
-				inst.ToString(symTab))
+func (o *output) Finish() {
+	if o.transcriptWriter != nil {
+		if err := o.transcriptWriter.Flush(); err != nil {
+			log.Printf("failed to write transcript: %v", err)
 		}
+		o.transcriptFile.Close()
+	}
 
-		cb := instruction.CB{
-			NPC: pc + uint16(numRead),
+	if o.checker != nil {
+		if off := o.checker.Result(); off >= 0 {
+			fmt.Printf("replay diverged from transcript at output byte %d\n", off)
+		} else {
+			fmt.Println("replay matched transcript")
 		}
+	}
+}
+
+// runner holds the per-step machinery layered over the machine: signal
+// handling, the debugger, watchpoints, profiling, and verbose output.
+// Any of dbg, jrnl, watches, and prof may be nil.
+type runner struct {
+	m             *vm.Machine
+	symTab        symtab.SymTab
+	in            *input.Reader
+	verboseWriter io.Writer
+	haltPC        uint16
+
+	dbg     *debugger
+	jrnl    *journal.Journal
+	watches *watch.Set
+	prof    *profile.Profile
+
+	hupChan, usr1Chan, intChan chan os.Signal
+}
+
+func newRunner(m *vm.Machine, symTab symtab.SymTab, in *input.Reader, verboseWriter io.Writer, haltPC uint16, interactive bool) *runner {
+	r := &runner{
+		m:             m,
+		symTab:        symTab,
+		in:            in,
+		verboseWriter: verboseWriter,
+		haltPC:        haltPC,
+		hupChan:       make(chan os.Signal, 1),
+		usr1Chan:      make(chan os.Signal, 1),
+		intChan:       make(chan os.Signal, 1),
+	}
+
+	signal.Notify(r.hupChan, syscall.SIGHUP)
+	if *snapshotPath != "" {
+		signal.Notify(r.usr1Chan, syscall.SIGUSR1)
+	}
+
+	iCtx := m.Context()
+	if interactive {
+		r.dbg = newDebuggerOrDie(iCtx, symTab, in)
+		r.dbg.SetRAMChanged(m.FlushCache)
+		signal.Notify(r.intChan, os.Interrupt)
+	}
 
-		inst.Exec(&iCtx, &cb)
+	if *journalSteps > 0 {
+		r.jrnl = journal.New(*journalSteps)
+		iCtx.Journal = r.jrnl
+		r.dbg.SetJournal(r.jrnl)
+	}
 
-		if *verbose && *dumpReg {
-			regFile.Dump(verboseWriter)
+	if r.dbg != nil || *watchFlag != "" {
+		r.watches = newWatchesOrDie(symTab)
+		iCtx.Watcher = r.watches
+		if r.dbg != nil {
+			r.dbg.SetWatches(r.watches)
 		}
+	}
+
+	if *profilePath != "" || *coveragePath != "" {
+		r.prof = profile.New()
+	}
+
+	m.BeforeExec = r.beforeExec
+	m.AfterStep = r.afterStep
+	return r
+}
+
+func (r *runner) beforeExec(pc uint16, inst instruction.Inst) {
+	if *verbose {
+		fmt.Fprintf(r.verboseWriter, "%30s: %s\n", util.AddrToName(pc, r.symTab),
+			inst.ToString(r.symTab))
+	}
+	if r.jrnl != nil {
+		r.jrnl.Begin(pc)
+	}
+}
 
-		if haltPC != math.MaxUint16 && haltPC == pc {
-			fmt.Printf("hlt requested by flag at %v\n", pc)
-			break
+func (r *runner) afterStep(s *vm.Step) {
+	if r.prof != nil {
+		r.prof.Record(s.PC, s.Op, s.CB.NPC, s.Len)
+	}
+
+	if r.dbg != nil {
+		r.dbg.Executed(s.PC, &s.CB)
+	}
+
+	if r.watches != nil {
+		hits, pause := r.watches.Hits()
+		for _, hit := range hits {
+			fmt.Printf("%v at %v\n", hit, util.AddrToName(s.PC, r.symTab))
+		}
+		if pause {
+			r.dbg.Stop()
 		}
+	}
+
+	if *verbose && *dumpReg {
+		r.m.RegFile().Dump(r.verboseWriter)
+	}
+}
 
-		if cb.Hlt {
-			fmt.Printf("hlt requested at %v\n", pc)
-			break
+// beforeStep handles signals and gives the debugger a chance to stop
+// before the instruction at PC executes. It returns true if the user quit.
+func (r *runner) beforeStep() bool {
+	select {
+	case <-r.hupChan:
+		*verbose = !*verbose
+		fmt.Printf("verbose now %v\n", *verbose)
+	case <-r.usr1Chan:
+		if err := saveSnapshot(*snapshotPath, r.m.Context(), r.m.PC, r.in); err != nil {
+			log.Print(err)
+		} else {
+			fmt.Printf("saved snapshot to %v\n", *snapshotPath)
 		}
+	case <-r.intChan:
+		r.dbg.Interrupt()
+	default:
+	}
+
+	if r.dbg != nil {
+		var quit bool
+		r.m.PC, quit = r.dbg.Check(r.m.PC)
+		return quit
+	}
+	return false
+}
+
+// until is the Run callback. It stops after --halt_pc, or when the user
+// quits from the debugger.
+func (r *runner) until(s *vm.Step) bool {
+	if r.haltPC != math.MaxUint16 && r.haltPC == s.PC {
+		fmt.Printf("hlt requested by flag at %v\n", s.PC)
+		return true
+	}
+	return r.beforeStep()
+}
 
-		pc = cb.NPC
+func (r *runner) Run() {
+	if r.beforeStep() {
+		return
 	}
 
+	if err := r.m.Run(r.until); err != nil {
+		fmt.Printf("hlt at %v: %v\n", r.m.PC, err)
+	} else if r.m.Halted() {
+		fmt.Printf("hlt requested at %v\n", r.m.PC)
+	}
+
+	if r.prof != nil {
+		writeProfile(r.prof, r.symTab)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	symTab := readSymTabOrDie()
+	startPC, haltPC := parsePCFlagsOrDie(symTab)
+
+	in := input.NewReader(os.Stdin)
+	m := newMachineOrDie(symTab, in, startPC)
+
+	verboseWriter, closeVerbose := openVerboseOrDie()
+	defer closeVerbose()
+
+	script, expectedOutput := readScriptOrDie()
+	out := newOutputOrDie(in, expectedOutput)
+
+	m.SetDevice(device.NewTerminal(in, out.w))
+	iCtx := m.Context()
+	iCtx.Verbose = verbose
+	iCtx.VerboseWriter = verboseWriter
+
+	interactive := *debug || *breakFlag != "" || script != nil || *journalSteps > 0 || *watchPause
+	r := newRunner(m, symTab, in, verboseWriter, haltPC, interactive)
+
+	if script != nil {
+		in.SetScript(script, func(d input.Directive) error {
+			return handleDirective(d, m, symTab, in, r.dbg)
+		})
+	}
+
+	r.Run()
+	out.Finish()
+
 	if *ramDumpPath != "" {
 		log.Printf("dumping RAM to %v", *ramDumpPath)
-		if err := memory.DumpRAM(ram, *ramDumpPath); err != nil {
+		if err := memory.DumpRAM(m.RAM(), *ramDumpPath); err != nil {
 			log.Fatalf("failed to dump RAM: %v", err)
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -51,12 +52,17 @@ func (c *Comment) String() string {
 type Registry interface {
 	GetSingle(line int) (string, bool)
 	GetBlock(line int) *Comment
+
+	// Comments returns all comments, ordered by start address. Single
+	// comments come before blocks starting at the same address.
+	Comments() []*Comment
 }
 
 type NullRegistry struct{}
 
 func (r *NullRegistry) GetSingle(line int) (string, bool) { return "", false }
 func (r *NullRegistry) GetBlock(line int) *Comment        { return nil }
+func (r *NullRegistry) Comments() []*Comment              { return nil }
 
 type registryImpl map[int][]*Comment
 
@@ -90,6 +96,20 @@ func (r registryImpl) GetBlock(line int) *Comment {
 	return nil
 }
 
+func (r registryImpl) Comments() []*Comment {
+	out := []*Comment{}
+	for _, arr := range r {
+		out = append(out, arr...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Start != out[j].Start {
+			return out[i].Start < out[j].Start
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
+
 const (
 	addrOrRange = `(\d+)(?:-(\d+))?`
 	lineComment = `//(?:\s(.*))?`
@@ -57,6 +57,16 @@ func TestRead(t *testing.T) {
 	if block := cs.GetBlock(6); block != nil {
 		t.Errorf(`GetBlock(6) = %+v, want nil`, block)
 	}
+
+	expectedAll := []*Comment{
+		&Comment{Single, 3, 3, []string{"foo"}},
+		&Comment{Block, 3, 3, []string{"bar", "", "  baz"}},
+		&Comment{Block, 5, 15, []string{"two"}},
+		&Comment{Single, 10, 10, []string{"ten"}},
+	}
+	if all := cs.Comments(); !reflect.DeepEqual(all, expectedAll) {
+		t.Errorf("Comments() = %v, want %v", all, expectedAll)
+	}
 }
 
 func TestReadSymTab(t *testing.T) {
@@ -0,0 +1,284 @@
+// Package datascan finds the challenge's strings and string tables in a RAM
+// image.
+//
+// Strings are length-prefixed: a word holding the length, followed by one
+// word per character. Tables are length-prefixed arrays of pointers, either
+// to strings (command_names) or to records whose first word points to a
+// string (arg_names, which points to the item records).
+//
+// Many strings are stored encrypted, and are printed by passing them to
+// word_iterate along with a visitor that xors each character with a key
+// held in r3. They're found by looking for the calls that print them:
+//
+//	set r1 STRING
+//	set r2 VISITOR
+//	add r3 K1 K2     (or set r3 KEY)
+//	call ITERATE
+//
+// Much of the code doing so is itself encrypted until decode_data runs, so
+// the image should be scanned after the VM's initialization has finished.
+package datascan
+
+import (
+	"fmt"
+	"sort"
+
+	"annotations"
+	"instruction"
+	"symtab"
+)
+
+type String struct {
+	Addr uint16
+	Text string
+
+	// Key is the xor key for encrypted strings, and Ref the address of
+	// the code that prints them.
+	Encrypted bool
+	Key       uint16
+	Ref       uint16
+}
+
+// End returns the address of the string's last word.
+func (s *String) End() uint16 {
+	return s.Addr + uint16(len(s.Text))
+}
+
+type Table struct {
+	Addr    uint16
+	Entries []uint16 // string or record addresses
+
+	// Records is true if the entries point to records, not strings.
+	Records bool
+}
+
+func (t *Table) End() uint16 {
+	return t.Addr + uint16(len(t.Entries))
+}
+
+type Options struct {
+	// MinLen is the shortest unreferenced plaintext string reported.
+	// Strings referenced by tables are reported regardless of length.
+	MinLen int
+
+	// MinEntries is the smallest table reported.
+	MinEntries int
+
+	// Iterate and Visitor are the addresses of word_iterate and the xor
+	// visitor. Encrypted strings aren't searched for if Iterate is zero.
+	Iterate, Visitor uint16
+}
+
+type Result struct {
+	Strings []*String // sorted by address
+	Tables  []*Table
+}
+
+func isText(w uint16) bool {
+	return w == '\n' || (w >= ' ' && w < 127)
+}
+
+// plainAt returns the plaintext string at addr, if there is one.
+func plainAt(ram []uint16, addr int) (string, bool) {
+	n := int(ram[addr])
+	if n == 0 || addr+n >= len(ram) {
+		return "", false
+	}
+
+	chars := make([]byte, n)
+	for i := 0; i < n; i++ {
+		w := ram[addr+1+i]
+		if !isText(w) {
+			return "", false
+		}
+		chars[i] = byte(w)
+	}
+	return string(chars), true
+}
+
+// decrypt returns the string at addr decrypted with key, if every
+// character decrypts to text.
+func decrypt(ram []uint16, addr, key uint16) (string, bool) {
+	n := int(ram[addr])
+	if int(addr)+n >= len(ram) {
+		return "", false
+	}
+
+	chars := make([]byte, n)
+	for i := 0; i < n; i++ {
+		w := ram[int(addr)+1+i] ^ key
+		if !isText(w) {
+			return "", false
+		}
+		chars[i] = byte(w)
+	}
+	return string(chars), true
+}
+
+var (
+	r1 = instruction.RegArg(1)
+	r2 = instruction.RegArg(2)
+	r3 = instruction.RegArg(3)
+)
+
+// keyAt returns the key loaded into r3 by the instruction at addr, and
+// the instruction's length.
+func keyAt(ram []uint16, addr int) (uint16, int, bool) {
+	switch {
+	case addr+3 < len(ram) && ram[addr] == instruction.OpSet && ram[addr+1] == r3 &&
+		!instruction.IsReg(ram[addr+2]):
+		return ram[addr+2], 3, true
+	case addr+4 < len(ram) && ram[addr] == instruction.OpAdd && ram[addr+1] == r3 &&
+		!instruction.IsReg(ram[addr+2]) && !instruction.IsReg(ram[addr+3]):
+		return (ram[addr+2] + ram[addr+3]) % 32768, 4, true
+	}
+	return 0, 0, false
+}
+
+// encrypted finds the calls printing encrypted strings.
+func encrypted(ram []uint16, opts Options) []*String {
+	out := []*String{}
+	for addr := 0; addr+8 < len(ram); addr++ {
+		if ram[addr] != instruction.OpSet || ram[addr+1] != r1 || instruction.IsReg(ram[addr+2]) ||
+			ram[addr+3] != instruction.OpSet || ram[addr+4] != r2 || ram[addr+5] != opts.Visitor {
+			continue
+		}
+		key, n, ok := keyAt(ram, addr+6)
+		if !ok {
+			continue
+		}
+		call := addr + 6 + n
+		if call+1 >= len(ram) || ram[call] != instruction.OpCall || ram[call+1] != opts.Iterate {
+			continue
+		}
+
+		str := ram[addr+2]
+		if text, ok := decrypt(ram, str, key); ok {
+			out = append(out, &String{Addr: str, Text: text, Encrypted: true, Key: key, Ref: uint16(addr)})
+		}
+	}
+	return out
+}
+
+func allStrings(plain map[int]string, addrs []uint16) bool {
+	for _, addr := range addrs {
+		if _, found := plain[int(addr)]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan searches ram, which may be shorter than the full address space.
+func Scan(ram []uint16, opts Options) *Result {
+	res := &Result{}
+
+	plain := map[int]string{}
+	for addr := range ram {
+		if text, ok := plainAt(ram, addr); ok {
+			plain[addr] = text
+		}
+	}
+
+	referenced := map[int]bool{}
+	for addr := 0; addr < len(ram); addr++ {
+		n := int(ram[addr])
+		if n < opts.MinEntries || n == 0 || addr+n >= len(ram) {
+			continue
+		}
+
+		entries := ram[addr+1 : addr+1+n]
+		names := entries
+		records := false
+		if !allStrings(plain, names) {
+			names = make([]uint16, n)
+			for i, ent := range entries {
+				if int(ent) < len(ram) {
+					names[i] = ram[ent]
+				}
+			}
+			if !allStrings(plain, names) {
+				continue
+			}
+			records = true
+		}
+
+		res.Tables = append(res.Tables, &Table{
+			Addr:    uint16(addr),
+			Entries: append([]uint16{}, entries...),
+			Records: records,
+		})
+		for _, name := range names {
+			referenced[int(name)] = true
+		}
+		addr += n
+	}
+
+	for addr := 0; addr < len(ram); addr++ {
+		text, found := plain[addr]
+		if !found || (len(text) < opts.MinLen && !referenced[addr]) {
+			continue
+		}
+		res.Strings = append(res.Strings, &String{Addr: uint16(addr), Text: text})
+		addr += len(text)
+	}
+
+	if opts.Iterate != 0 {
+		seen := map[uint16]bool{}
+		for _, s := range res.Strings {
+			seen[s.Addr] = true
+		}
+		for _, s := range encrypted(ram, opts) {
+			if !seen[s.Addr] {
+				res.Strings = append(res.Strings, s)
+				seen[s.Addr] = true
+			}
+		}
+		sort.Slice(res.Strings, func(i, j int) bool {
+			return res.Strings[i].Addr < res.Strings[j].Addr
+		})
+	}
+
+	return res
+}
+
+// Annotate adds symbols and notes to db for each string and table. Items
+// already named in st keep their names; others are named after their
+// addresses. Existing notes are left alone. Items whose symbols would
+// overlap existing ones are skipped, and returned.
+func (r *Result) Annotate(db *annotations.DB, st symtab.SymTab) []error {
+	errs := []error{}
+	add := func(prefix string, start, end uint16, kind symtab.Kind, note string) {
+		if ent, found := st.LookupAddr(uint(start)); !found || ent.Start != uint(start) {
+			ent := symtab.SymEnt{
+				Name:  fmt.Sprintf("%s_%d", prefix, start),
+				Start: uint(start),
+				End:   uint(end),
+				Kind:  kind,
+			}
+			if err := db.Symbols.AddEntry(ent); err != nil {
+				errs = append(errs, err)
+				return
+			}
+		}
+		if _, found := db.GetSingle(int(start)); !found {
+			db.SetSingle(int(start), note)
+		}
+	}
+
+	for _, t := range r.Tables {
+		what := "strings"
+		if t.Records {
+			what = "records"
+		}
+		add("tab", t.Addr, t.End(), symtab.KindData, fmt.Sprintf("table of %d %s", len(t.Entries), what))
+	}
+	for _, s := range r.Strings {
+		if s.Encrypted {
+			add("xstr", s.Addr, s.End(), symtab.KindString, fmt.Sprintf("%q (key %d)", s.Text, s.Key))
+		} else {
+			add("str", s.Addr, s.End(), symtab.KindString, fmt.Sprintf("%q", s.Text))
+		}
+	}
+	return errs
+}
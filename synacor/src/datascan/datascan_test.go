@@ -0,0 +1,160 @@
+package datascan
+
+import (
+	"reflect"
+	"testing"
+
+	"adventure"
+	"annotations"
+	"instruction"
+	"symtab"
+	"util"
+)
+
+func words(str string) []uint16 {
+	out := []uint16{uint16(len(str))}
+	for _, c := range str {
+		out = append(out, uint16(c))
+	}
+	return out
+}
+
+func encrypt(str string, key uint16) []uint16 {
+	out := words(str)
+	for i := 1; i < len(out); i++ {
+		out[i] ^= key
+	}
+	return out
+}
+
+func buildImage() []uint16 {
+	r1, r2, r3 := instruction.RegArg(1), instruction.RegArg(2), instruction.RegArg(3)
+
+	image := make([]uint16, 100)
+	at := func(addr int, ws ...uint16) {
+		copy(image[addr:], ws)
+	}
+
+	// Prints the encrypted string at 40 with key 100+200.
+	at(0, instruction.OpSet, r1, 40,
+		instruction.OpSet, r2, 90,
+		instruction.OpAdd, r3, 100, 200,
+		instruction.OpCall, 80,
+		instruction.OpHlt)
+
+	at(20, 3, 30, 35, 50) // table of strings
+	at(24, 1, 60)         // table of records, if small tables are allowed
+	at(26, 2, 1000, 1)    // not a table
+	at(30, words("go")...)
+	at(35, words("look")...)
+	at(40, encrypt("hidden", 300)...)
+	at(50, words("inventory")...)
+	at(60, 30, 0) // record
+	return image
+}
+
+func TestScan(t *testing.T) {
+	image := buildImage()
+
+	got := Scan(image, Options{MinLen: 3, MinEntries: 2, Iterate: 80, Visitor: 90})
+
+	wantStrings := []*String{
+		&String{Addr: 30, Text: "go"},
+		&String{Addr: 35, Text: "look"},
+		&String{Addr: 40, Text: "hidden", Encrypted: true, Key: 300, Ref: 0},
+		&String{Addr: 50, Text: "inventory"},
+	}
+	if !reflect.DeepEqual(got.Strings, wantStrings) {
+		t.Errorf("Scan().Strings = %+v, want %+v", got.Strings, wantStrings)
+	}
+
+	wantTables := []*Table{&Table{Addr: 20, Entries: []uint16{30, 35, 50}}}
+	if !reflect.DeepEqual(got.Tables, wantTables) {
+		t.Errorf("Scan().Tables = %+v, want %+v", got.Tables, wantTables)
+	}
+
+	// Without an iteration function, encrypted strings aren't found, and
+	// with MinEntries of 1, the record table at 24 is.
+	got = Scan(image, Options{MinLen: 3, MinEntries: 1})
+	if len(got.Strings) != 3 {
+		t.Errorf("Scan(no iterate).Strings = %+v, want 3 plaintext strings", got.Strings)
+	}
+	wantTables = append(wantTables, &Table{Addr: 24, Entries: []uint16{60}, Records: true})
+	if !reflect.DeepEqual(got.Tables, wantTables) {
+		t.Errorf("Scan(MinEntries 1).Tables = %+v, want %+v", got.Tables, wantTables)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	st := symtab.New()
+	st.AddEntry(symtab.SymEnt{Name: "names", Start: 20, End: 23, Kind: symtab.KindData})
+	st.Add("overlap", 49, 52)
+
+	db := annotations.New()
+	db.Symbols = st
+	db.SetSingle(30, "existing note")
+
+	res := Scan(buildImage(), Options{MinLen: 3, MinEntries: 2, Iterate: 80, Visitor: 90})
+	errs := res.Annotate(db, st)
+	if len(errs) != 1 {
+		t.Errorf("Annotate() = %v, want one overlap error", errs)
+	}
+
+	names := []string{}
+	for _, ent := range db.Symbols.Entries() {
+		names = append(names, ent.String())
+	}
+	want := []string{
+		"names\t\t20-23\tdata",
+		"str_30\t\t30-32\tstring",
+		"str_35\t\t35-39\tstring",
+		"xstr_40\t\t40-46\tstring",
+		"overlap\t\t49-52",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("symbols = %q, want %q", names, want)
+	}
+
+	notes := map[int]string{20: "table of 3 strings", 30: "existing note", 35: `"look"`, 40: `"hidden" (key 300)`}
+	for addr, want := range notes {
+		if got, _ := db.GetSingle(addr); got != want {
+			t.Errorf("note at %d = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestScanChallenge(t *testing.T) {
+	image, err := util.ReadWordsFromPath("../../challenge.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := adventure.NewGameFromImage(image)
+	if _, err := g.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	ram := g.RAM()
+
+	res := Scan(ram[:], Options{MinLen: 4, MinEntries: 2, Iterate: 1458, Visitor: 1531})
+
+	strs := map[uint16]*String{}
+	for _, s := range res.Strings {
+		strs[s.Addr] = s
+	}
+	if s := strs[27414]; s == nil || s.Text != "\nWhat do you do?\n" || !s.Encrypted {
+		t.Errorf("string at 27414 = %+v, want encrypted prompt", s)
+	}
+
+	tables := map[uint16]*Table{}
+	for _, tab := range res.Tables {
+		tables[tab.Addr] = tab
+	}
+	if tab := tables[27398]; tab == nil || len(tab.Entries) != 7 || tab.Records {
+		t.Errorf("command_names = %+v, want 7 strings", tab)
+	} else if s := strs[tab.Entries[0]]; s == nil || s.Text != "go" {
+		t.Errorf("command_names[0] = %+v, want go", s)
+	}
+	if tab := tables[27381]; tab == nil || len(tab.Entries) != 16 || !tab.Records {
+		t.Errorf("arg_names = %+v, want 16 records", tab)
+	}
+}
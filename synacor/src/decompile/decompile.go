@@ -0,0 +1,615 @@
+// Package decompile lifts traced functions to structured pseudo-C.
+//
+// Each instruction becomes a statement or expression (r1 = r2 + 1, mem[r1]
+// = r2, and so on), with arithmetic shown mod 32768 as in the VM. Control
+// flow is recovered from the function's basic blocks: forward conditional
+// jumps become if and if/else, backward jumps become while and do/while
+// loops, and anything that doesn't fit those shapes is left as a goto.
+//
+// Two conventions of the challenge are assumed. Functions save the
+// registers they use with pushes on entry and pops before each ret, so
+// those are dropped. Arguments are passed in r1..r3, so calls show the
+// argument registers set up before them.
+package decompile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cfg"
+	"disasm"
+	"instruction"
+	"symtab"
+	"util"
+)
+
+const numRegs = 8
+
+type regSet uint16
+
+const allRegs = regSet(1<<numRegs - 1)
+
+func regBit(arg uint16) regSet {
+	if !instruction.IsReg(arg) {
+		return 0
+	}
+	return 1 << (instruction.RegNum(arg) - 1)
+}
+
+// usesDefs returns the registers read and written by inst. Calls are
+// assumed to read their arguments and preserve everything, and rets to
+// read everything, which is conservative for deciding whether a value is
+// dead.
+func usesDefs(inst *instruction.Decoded) (uses, defs regSet) {
+	args := inst.Args
+	if inst.Op.RegDest {
+		defs = regBit(args[0])
+		args = args[1:]
+	}
+	for _, arg := range args {
+		uses |= regBit(arg)
+	}
+
+	switch inst.Op.Code {
+	case instruction.OpCall:
+		uses |= 0x7 // r1..r3
+	case instruction.OpRet:
+		uses = allRegs
+	}
+	return uses, defs
+}
+
+// cond is a branch condition. An empty op means l is tested for truth.
+type cond struct {
+	l, op, r string
+}
+
+var negations = map[string]string{
+	"":   "!",
+	"!":  "",
+	"==": "!=",
+	"!=": "==",
+	">":  "<=",
+	"<=": ">",
+}
+
+func (c cond) not() cond {
+	return cond{c.l, negations[c.op], c.r}
+}
+
+func (c cond) String() string {
+	switch c.op {
+	case "":
+		return c.l
+	case "!":
+		return "!" + c.l
+	default:
+		return fmt.Sprintf("%s %s %s", c.l, c.op, c.r)
+	}
+}
+
+// stmt is a lifted instruction.
+type stmt struct {
+	addr uint16
+	text string
+}
+
+// block is a basic block with its body lifted. The terminating jump, if
+// any, is kept separately, as its rendering depends on the surrounding
+// structure.
+type block struct {
+	*cfg.Block
+	stmts []stmt
+	term  *instruction.Decoded // jmp, jt, or jf
+	cond  cond                 // for jt and jf, the condition for jumping
+}
+
+type line struct {
+	addr    uint16
+	hasAddr bool
+	indent  int
+	text    string
+	label   uint16 // if isLabel, the address the label marks
+	isLabel bool
+}
+
+type decompiler struct {
+	tr     *disasm.Trace
+	f      *cfg.Func
+	st     symtab.SymTab
+	blocks []*block
+	index  map[uint16]int // block start address to index
+
+	elided map[uint16]bool // push/pop addresses dropped as save/restore
+	saved  []uint16        // registers saved on entry
+	lines  []line
+	gotos  map[uint16]bool // addresses targeted by gotos
+	indent int
+}
+
+type loopCtx struct {
+	head, exit uint16
+}
+
+func (d *decompiler) regOrVal(arg uint16) string {
+	if instruction.IsReg(arg) {
+		return fmt.Sprintf("r%d", instruction.RegNum(arg))
+	}
+	return fmt.Sprint(arg)
+}
+
+func (d *decompiler) addrName(arg uint16) string {
+	if instruction.IsReg(arg) {
+		return d.regOrVal(arg)
+	}
+	return util.AddrToName(arg, d.st)
+}
+
+func charLiteral(arg uint16) (string, bool) {
+	switch {
+	case arg == '\n':
+		return `'\n'`, true
+	case arg == '\'' || arg == '\\':
+		return `'\` + string(rune(arg)) + `'`, true
+	case arg >= ' ' && arg < 127:
+		return "'" + string(rune(arg)) + "'", true
+	default:
+		return "", false
+	}
+}
+
+// binary renders a two-operand arithmetic expression. Adding a large
+// constant is shown as subtraction, as that's what it amounts to mod
+// 32768.
+func (d *decompiler) binary(op string, a, b uint16) string {
+	if op == "+" && !instruction.IsReg(b) && b >= 16384 {
+		return fmt.Sprintf("%s - %d", d.regOrVal(a), 32768-int(b))
+	}
+	return fmt.Sprintf("%s %s %s", d.regOrVal(a), op, d.regOrVal(b))
+}
+
+var binaryOps = map[uint16]string{
+	instruction.OpAdd:  "+",
+	instruction.OpMult: "*",
+	instruction.OpMod:  "%",
+	instruction.OpAnd:  "&",
+	instruction.OpOr:   "|",
+	instruction.OpEq:   "==",
+	instruction.OpGt:   ">",
+}
+
+// lift returns the statement for a non-branch instruction, or false if it
+// produces none. argRegs is the set of argument registers assigned so far
+// in the block, for rendering calls.
+func (d *decompiler) lift(inst *instruction.Decoded, argRegs regSet) (string, bool) {
+	a := inst.Args
+	switch inst.Op.Code {
+	case instruction.OpNop:
+		return "", false
+	case instruction.OpHlt:
+		return "halt()", true
+	case instruction.OpRet:
+		return "return", true
+	case instruction.OpSet:
+		return fmt.Sprintf("%s = %s", d.regOrVal(a[0]), d.regOrVal(a[1])), true
+	case instruction.OpNot:
+		return fmt.Sprintf("%s = ~%s", d.regOrVal(a[0]), d.regOrVal(a[1])), true
+	case instruction.OpRmem:
+		return fmt.Sprintf("%s = mem[%s]", d.regOrVal(a[0]), d.addrName(a[1])), true
+	case instruction.OpWmem:
+		return fmt.Sprintf("mem[%s] = %s", d.addrName(a[0]), d.regOrVal(a[1])), true
+	case instruction.OpPush:
+		return fmt.Sprintf("push(%s)", d.regOrVal(a[0])), true
+	case instruction.OpPop:
+		return fmt.Sprintf("%s = pop()", d.regOrVal(a[0])), true
+	case instruction.OpIn:
+		return fmt.Sprintf("%s = in()", d.regOrVal(a[0])), true
+	case instruction.OpOut:
+		if lit, ok := charLiteral(a[0]); ok && !instruction.IsReg(a[0]) {
+			return fmt.Sprintf("out(%s)", lit), true
+		}
+		return fmt.Sprintf("out(%s)", d.regOrVal(a[0])), true
+	case instruction.OpCall:
+		args := []string{}
+		for i := uint(1); i <= 3; i++ {
+			if argRegs&(1<<(i-1)) != 0 {
+				for len(args) < int(i)-1 {
+					args = append(args, fmt.Sprintf("r%d", len(args)+1))
+				}
+				args = append(args, fmt.Sprintf("r%d", i))
+			}
+		}
+		callee := d.addrName(a[0])
+		if instruction.IsReg(a[0]) {
+			callee = "(*" + callee + ")"
+		}
+		return fmt.Sprintf("%s(%s)", callee, strings.Join(args, ", ")), true
+	}
+
+	if op, found := binaryOps[inst.Op.Code]; found {
+		return fmt.Sprintf("%s = %s", d.regOrVal(a[0]), d.binary(op, a[1], a[2])), true
+	}
+	return inst.Op.Name, true
+}
+
+// findSaves looks for registers pushed on entry and popped, in reverse
+// order, immediately before every ret. If there are any, they're recorded
+// for elision.
+func (d *decompiler) findSaves() {
+	entry := d.f.Blocks[0]
+	pushes := []*instruction.Decoded{}
+	for _, ci := range entry.Insts {
+		inst := d.tr.Insts[ci.Addr]
+		if inst.Op.Code != instruction.OpPush || !instruction.IsReg(inst.Args[0]) {
+			break
+		}
+		pushes = append(pushes, inst)
+	}
+	if len(pushes) == 0 {
+		return
+	}
+
+	elided := map[uint16]bool{}
+	for _, p := range pushes {
+		elided[p.Addr] = true
+	}
+
+	sawRet := false
+	for _, b := range d.f.Blocks {
+		last := d.tr.Insts[b.Insts[len(b.Insts)-1].Addr]
+		if last.Op.Code != instruction.OpRet {
+			continue
+		}
+		sawRet = true
+
+		if len(b.Insts) < len(pushes)+1 {
+			return
+		}
+		pops := b.Insts[len(b.Insts)-1-len(pushes) : len(b.Insts)-1]
+		for i, ci := range pops {
+			inst := d.tr.Insts[ci.Addr]
+			push := pushes[len(pushes)-1-i]
+			if inst.Op.Code != instruction.OpPop || inst.Args[0] != push.Args[0] {
+				return
+			}
+			elided[ci.Addr] = true
+		}
+	}
+	if !sawRet {
+		return
+	}
+
+	d.elided = elided
+	for _, p := range pushes {
+		d.saved = append(d.saved, p.Args[0])
+	}
+}
+
+// liveIn computes the registers live on entry to each block.
+func (d *decompiler) liveIn() []regSet {
+	live := make([]regSet, len(d.blocks))
+	for changed := true; changed; {
+		changed = false
+		for i := len(d.blocks) - 1; i >= 0; i-- {
+			in := d.blockLiveOut(i, live)
+			insts := d.blocks[i].Insts
+			for j := len(insts) - 1; j >= 0; j-- {
+				uses, defs := usesDefs(d.tr.Insts[insts[j].Addr])
+				in = (in &^ defs) | uses
+			}
+			if in != live[i] {
+				live[i] = in
+				changed = true
+			}
+		}
+	}
+	return live
+}
+
+func (d *decompiler) blockLiveOut(i int, live []regSet) regSet {
+	b := d.blocks[i]
+	if b.Indirect {
+		return allRegs
+	}
+	var out regSet
+	for _, succ := range b.Succs {
+		if j, found := d.index[succ]; found {
+			out |= live[j]
+		} else {
+			out = allRegs // leaves the function
+		}
+	}
+	return out
+}
+
+// liftBlocks lifts the body of each block, folding comparisons into the
+// branches that test them when the compared result is otherwise unused.
+func (d *decompiler) liftBlocks() {
+	for i, cb := range d.f.Blocks {
+		d.blocks = append(d.blocks, &block{Block: cb})
+		d.index[cb.Start] = i
+	}
+	live := d.liveIn()
+
+	for i, b := range d.blocks {
+		insts := []*instruction.Decoded{}
+		for _, ci := range b.Insts {
+			insts = append(insts, d.tr.Insts[ci.Addr])
+		}
+
+		if last := insts[len(insts)-1]; last.Op.Code == instruction.OpJmp ||
+			last.Op.Code == instruction.OpJt || last.Op.Code == instruction.OpJf {
+			b.term = last
+			insts = insts[:len(insts)-1]
+		}
+
+		if b.term != nil && b.term.Op.Code != instruction.OpJmp {
+			test := b.term.Args[0]
+			b.cond = cond{l: d.regOrVal(test)}
+
+			if n := len(insts); n > 0 {
+				prev := insts[n-1]
+				op := binaryOps[prev.Op.Code]
+				if (op == "==" || op == ">") && prev.Args[0] == test &&
+					d.blockLiveOut(i, live)&regBit(test) == 0 &&
+					prev.Args[1] != test && prev.Args[2] != test {
+					b.cond = cond{d.regOrVal(prev.Args[1]), op, d.regOrVal(prev.Args[2])}
+					insts = insts[:n-1]
+				}
+			}
+
+			if b.term.Op.Code == instruction.OpJf {
+				b.cond = b.cond.not()
+			}
+		}
+
+		var argRegs regSet
+		for _, inst := range insts {
+			if d.elided[inst.Addr] {
+				continue
+			}
+			if text, ok := d.lift(inst, argRegs); ok {
+				b.stmts = append(b.stmts, stmt{inst.Addr, text})
+			}
+
+			_, defs := usesDefs(inst)
+			if inst.Op.Code == instruction.OpCall {
+				argRegs = 0
+			} else {
+				argRegs |= defs & 0x7
+			}
+		}
+	}
+}
+
+func (d *decompiler) emit(text string) {
+	d.lines = append(d.lines, line{indent: d.indent, text: text})
+}
+
+func (d *decompiler) emitAt(addr uint16, text string) {
+	d.lines = append(d.lines, line{addr: addr, hasAddr: true, indent: d.indent, text: text})
+}
+
+func (d *decompiler) emitBody(b *block) {
+	d.lines = append(d.lines, line{indent: d.indent, label: b.Start, isLabel: true})
+	for _, s := range b.stmts {
+		d.emitAt(s.addr, s.text)
+	}
+}
+
+// end returns the address just past block i.
+func (d *decompiler) end(i int) uint16 {
+	if i < len(d.blocks) {
+		return d.blocks[i].Start
+	}
+	return d.blocks[len(d.blocks)-1].End + 1
+}
+
+// jumpText renders an unconditional transfer to tgt.
+func (d *decompiler) jumpText(tgt uint16, loop *loopCtx) string {
+	switch {
+	case loop != nil && tgt == loop.exit:
+		return "break"
+	case loop != nil && tgt == loop.head:
+		return "continue"
+	}
+	if _, found := d.index[tgt]; !found {
+		return "goto " + util.AddrToName(tgt, d.st) // tail call
+	}
+	d.gotos[tgt] = true
+	return fmt.Sprintf("goto L%d", tgt)
+}
+
+// backEdge returns the last block in [i, hi) which jumps back to block i,
+// or -1.
+func (d *decompiler) backEdge(i, hi int) int {
+	for j := hi - 1; j >= i; j-- {
+		if t := d.blocks[j].term; t != nil {
+			if tgt, ok := t.StaticTarget(); ok && tgt == d.blocks[i].Start {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// structure emits blocks [lo, hi). skip is a block index whose terminator
+// has already been accounted for by the enclosing construct, and noLoop is
+// a block index already known to be a loop head.
+func (d *decompiler) structure(lo, hi int, loop *loopCtx, skip map[int]bool, noLoop int) {
+	for i := lo; i < hi; {
+		b := d.blocks[i]
+
+		if j := d.backEdge(i, hi); j >= 0 && i != noLoop {
+			i = d.structureLoop(i, j, skip)
+			continue
+		}
+
+		d.emitBody(b)
+		if b.term == nil || skip[i] {
+			i++
+			continue
+		}
+
+		tgt, static := b.term.StaticTarget()
+		if !static {
+			text := fmt.Sprintf("goto *%s", d.regOrVal(b.term.Args[len(b.term.Args)-1]))
+			if b.term.Op.Code != instruction.OpJmp {
+				text = fmt.Sprintf("if (%v) %s", b.cond, text)
+			}
+			d.emitAt(b.term.Addr, text)
+			i++
+			continue
+		}
+
+		if b.term.Op.Code == instruction.OpJmp {
+			if tgt != d.end(i+1) {
+				d.emitAt(b.term.Addr, d.jumpText(tgt, loop))
+			}
+			i++
+			continue
+		}
+
+		// Conditional jump.
+		if loop != nil && (tgt == loop.exit || tgt == loop.head) {
+			d.emitAt(b.term.Addr, fmt.Sprintf("if (%v) %s", b.cond, d.jumpText(tgt, loop)))
+			i++
+			continue
+		}
+
+		k, found := d.index[tgt]
+		if tgt == d.end(hi) {
+			k, found = hi, true
+		}
+		if !found || k <= i+1 || k > hi {
+			if tgt != d.end(i+1) {
+				d.emitAt(b.term.Addr, fmt.Sprintf("if (%v) %s", b.cond, d.jumpText(tgt, loop)))
+			}
+			i++
+			continue
+		}
+
+		// if/else: the then part ends by jumping over the else part.
+		if last := d.blocks[k-1]; k-1 > i && last.term != nil && last.term.Op.Code == instruction.OpJmp && !skip[k-1] {
+			if e, ok := last.term.StaticTarget(); ok {
+				ei, found := d.index[e]
+				if e == d.end(hi) {
+					ei, found = hi, true
+				}
+				if found && ei > k && ei <= hi {
+					d.emitAt(b.term.Addr, fmt.Sprintf("if (%v) {", b.cond.not()))
+					d.nested(i+1, k, loop, withSkip(skip, k-1))
+					d.emit("} else {")
+					d.nested(k, ei, loop, skip)
+					d.emit("}")
+					i = ei
+					continue
+				}
+			}
+		}
+
+		d.emitAt(b.term.Addr, fmt.Sprintf("if (%v) {", b.cond.not()))
+		d.nested(i+1, k, loop, skip)
+		d.emit("}")
+		i = k
+	}
+}
+
+func withSkip(skip map[int]bool, i int) map[int]bool {
+	out := map[int]bool{i: true}
+	for k := range skip {
+		out[k] = true
+	}
+	return out
+}
+
+func (d *decompiler) nested(lo, hi int, loop *loopCtx, skip map[int]bool) {
+	d.indent++
+	d.structure(lo, hi, loop, skip, -1)
+	d.indent--
+}
+
+// structureLoop emits the loop made by blocks i through j, where j jumps
+// back to i, and returns the index of the block following the loop.
+func (d *decompiler) structureLoop(i, j int, skip map[int]bool) int {
+	head, tail := d.blocks[i], d.blocks[j]
+	ctx := &loopCtx{head: head.Start, exit: d.end(j + 1)}
+	inner := withSkip(skip, j)
+
+	if tail.term.Op.Code != instruction.OpJmp {
+		// do { ... } while (cond)
+		d.emit("do {")
+		d.indent++
+		d.structure(i, j+1, ctx, inner, i)
+		d.indent--
+		d.emitAt(tail.term.Addr, fmt.Sprintf("} while (%v)", tail.cond))
+		return j + 1
+	}
+
+	// while (cond) { ... }, if the head does nothing but test for exit.
+	if i != j && len(head.stmts) == 0 && head.term != nil && head.term.Op.Code != instruction.OpJmp {
+		if tgt, ok := head.term.StaticTarget(); ok && tgt == ctx.exit {
+			d.lines = append(d.lines, line{indent: d.indent, label: head.Start, isLabel: true})
+			d.emitAt(head.term.Addr, fmt.Sprintf("while (%v) {", head.cond.not()))
+			d.nested(i+1, j+1, ctx, inner)
+			d.emit("}")
+			return j + 1
+		}
+	}
+
+	d.emit("while (true) {")
+	d.indent++
+	d.structure(i, j+1, ctx, inner, i)
+	d.indent--
+	d.emit("}")
+	return j + 1
+}
+
+// Write decompiles f, which must come from a cfg.Program built from tr,
+// and writes it to w. Each statement is followed by the address of the
+// instruction it came from.
+func Write(w io.Writer, tr *disasm.Trace, f *cfg.Func, st symtab.SymTab) error {
+	d := &decompiler{
+		tr:    tr,
+		f:     f,
+		st:    tr.SymTab(st),
+		index: map[uint16]int{},
+		gotos: map[uint16]bool{},
+	}
+	d.findSaves()
+	d.liftBlocks()
+
+	d.indent = 1
+	d.structure(0, len(d.blocks), nil, map[int]bool{}, -1)
+
+	out := []string{}
+	header := f.Name + "() {"
+	if len(d.saved) > 0 {
+		regs := []string{}
+		for _, r := range d.saved {
+			regs = append(regs, d.regOrVal(r))
+		}
+		header += " // saves " + strings.Join(regs, ", ")
+	}
+	out = append(out, header)
+
+	for _, l := range d.lines {
+		if l.isLabel {
+			if d.gotos[l.label] {
+				out = append(out, fmt.Sprintf("%*sL%d:", (l.indent-1)*4, "", l.label))
+			}
+			continue
+		}
+
+		text := strings.Repeat("    ", l.indent) + l.text
+		if l.hasAddr {
+			text = fmt.Sprintf("%-50s // %s", text, util.AddrToName(l.addr, d.st))
+		}
+		out = append(out, text)
+	}
+	out = append(out, "}")
+
+	_, err := fmt.Fprintln(w, strings.Join(out, "\n"))
+	return err
+}
@@ -0,0 +1,209 @@
+package decompile
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"assembler"
+	"cfg"
+	"comment"
+	"disasm"
+	"symtab"
+)
+
+var addrCommentPattern = regexp.MustCompile(`\s*// [a-z_0-9+]+$`)
+
+// decompile assembles src, traces it from 0, and returns the decompiled
+// function named name with the address comments removed.
+func decompile(t *testing.T, src, name string) string {
+	t.Helper()
+
+	p, err := assembler.Assemble(strings.NewReader(src), 0, symtab.New())
+	if err != nil {
+		t.Fatalf("Assemble = %v", err)
+	}
+	st := symtab.New()
+	for label, addr := range p.Labels {
+		st.Add(label, uint(addr), uint(addr))
+	}
+
+	tr := disasm.NewTrace(p.Words, []uint16{0})
+	f, found := cfg.Build(tr, st, &comment.NullRegistry{}).Func(name)
+	if !found {
+		t.Fatalf("no function %v", name)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, tr, f, st); err != nil {
+		t.Fatalf("Write = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, line := range lines {
+		lines[i] = addrCommentPattern.ReplaceAllString(line, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestWrite(t *testing.T) {
+	type testCase struct {
+		desc, src, want string
+	}
+
+	testCases := []testCase{
+		testCase{
+			desc: "if with save/restore and arguments",
+			src: `
+					call f
+					halt
+				f:	push r2
+					push r3
+					jt r1 nonzero
+					add r1 r1 32767
+					set r3 5
+					set r2 'a'
+					call f
+				nonzero:
+					mult r1 r1 r2
+					pop r3
+					pop r2
+					ret`,
+			want: `
+f() { // saves r2, r3
+    if (!r1) {
+        r1 = r1 - 1
+        r3 = 5
+        r2 = 97
+        f(r1, r2, r3)
+    }
+    r1 = r1 * r2
+    return
+}`,
+		},
+		testCase{
+			desc: "if/else with folded comparison",
+			src: `
+					call f
+					halt
+				f:	push r2
+					gt r2 r1 10
+					jf r2 small
+					out 'b'
+					jmp done
+				small:
+					out 's'
+				done:
+					pop r2
+					ret`,
+			want: `
+f() { // saves r2
+    if (r1 > 10) {
+        out('b')
+    } else {
+        out('s')
+    }
+    return
+}`,
+		},
+		testCase{
+			desc: "while loop",
+			src: `
+					call f
+					halt
+				f:	set r2 0
+				loop:
+					jf r1 done
+					mod r3 r1 2
+					add r2 r2 r3
+					rmem r1 r1
+					jmp loop
+				done:
+					set r1 r2
+					ret`,
+			want: `
+f() {
+    r2 = 0
+    while (r1) {
+        r3 = r1 % 2
+        r2 = r2 + r3
+        r1 = mem[r1]
+    }
+    r1 = r2
+    return
+}`,
+		},
+		testCase{
+			desc: "do/while with live comparison",
+			src: `
+					call f
+					halt
+				f:	and r2 r1 r1
+				loop:
+					not r2 r2
+					wmem r1 r2
+					eq r3 r2 0
+					jf r3 loop
+					set r1 r3
+					ret`,
+			want: `
+f() {
+    r2 = r1 & r1
+    do {
+        r2 = ~r2
+        mem[r1] = r2
+        r3 = r2 == 0
+    } while (!r3)
+    r1 = r3
+    return
+}`,
+		},
+		testCase{
+			desc: "loop with break",
+			src: `
+					call f
+					halt
+				f:	push r2
+				loop:
+					in r1
+					eq r2 r1 10
+					jt r2 done
+					out r1
+					jmp loop
+				done:
+					pop r2
+					ret`,
+			want: `
+f() { // saves r2
+    while (true) {
+        r1 = in()
+        if (r1 == 10) break
+        out(r1)
+    }
+    return
+}`,
+		},
+		testCase{
+			desc: "computed conditional jump",
+			src: `
+					call f
+					halt
+				f:	jt r1 r2
+					out 'x'
+					ret`,
+			want: `
+f() {
+    if (r1) goto *r2
+    out('x')
+    return
+}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		if got, want := decompile(t, tc.src, "f"), strings.TrimSpace(tc.want); got != want {
+			t.Errorf("%v: Write =\n%v\nwant\n%v", tc.desc, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package device provides implementations of instruction.Device, the I/O
+// device used by the in and out instructions.
+package device
+
+import (
+	"bytes"
+	"io"
+)
+
+type unreader interface {
+	Unread(b byte)
+}
+
+// Terminal pairs an input source with an output sink, as when the VM is
+// run interactively. If the input can unread bytes (as input.Reader can),
+// so can the Terminal.
+type Terminal struct {
+	In  io.ByteReader
+	Out io.Writer
+}
+
+func NewTerminal(in io.ByteReader, out io.Writer) *Terminal {
+	return &Terminal{In: in, Out: out}
+}
+
+func (t *Terminal) ReadByte() (byte, error) {
+	return t.In.ReadByte()
+}
+
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.Out.Write(p)
+}
+
+func (t *Terminal) Unread(b byte) {
+	if u, ok := t.In.(unreader); ok {
+		u.Unread(b)
+	}
+}
+
+// Buffer reads input from and accumulates output in memory. Reads return
+// io.EOF once the input is exhausted.
+type Buffer struct {
+	in  []byte
+	out bytes.Buffer
+	eof error
+}
+
+func NewBuffer(input string) *Buffer {
+	return &Buffer{in: []byte(input), eof: io.EOF}
+}
+
+func (b *Buffer) ReadByte() (byte, error) {
+	if len(b.in) == 0 {
+		return 0, b.eof
+	}
+	c := b.in[0]
+	b.in = b.in[1:]
+	return c, nil
+}
+
+func (b *Buffer) Unread(c byte) {
+	b.in = append([]byte{c}, b.in...)
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	return b.out.Write(p)
+}
+
+// AddInput appends to the unread input.
+func (b *Buffer) AddInput(input string) {
+	b.in = append(b.in, input...)
+}
+
+// Pending returns the unread input.
+func (b *Buffer) Pending() []byte {
+	return append([]byte{}, b.in...)
+}
+
+func (b *Buffer) SetPending(pending []byte) {
+	b.in = append([]byte{}, pending...)
+}
+
+// Output returns the output written so far.
+func (b *Buffer) Output() string {
+	return b.out.String()
+}
+
+// TakeOutput returns the output written since the last call to TakeOutput,
+// discarding it from the buffer.
+func (b *Buffer) TakeOutput() string {
+	out := b.out.String()
+	b.out.Reset()
+	return out
+}
+
+// Chan reads input from and writes output to channels, for driving the VM
+// from another goroutine. Reads block until input is available, and return
+// io.EOF once the input channel is closed. Each Write sends one string,
+// and blocks until it has been received.
+type Chan struct {
+	in      <-chan string
+	out     chan<- string
+	pending []byte
+}
+
+func NewChan(in <-chan string, out chan<- string) *Chan {
+	return &Chan{in: in, out: out}
+}
+
+func (c *Chan) ReadByte() (byte, error) {
+	for len(c.pending) == 0 {
+		str, ok := <-c.in
+		if !ok {
+			return 0, io.EOF
+		}
+		c.pending = []byte(str)
+	}
+
+	b := c.pending[0]
+	c.pending = c.pending[1:]
+	return b, nil
+}
+
+func (c *Chan) Unread(b byte) {
+	c.pending = append([]byte{b}, c.pending...)
+}
+
+func (c *Chan) Write(p []byte) (int, error) {
+	c.out <- string(p)
+	return len(p), nil
+}
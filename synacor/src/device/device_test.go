@@ -0,0 +1,99 @@
+package device
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"instruction"
+	"vm"
+)
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer("ab")
+
+	if c, err := b.ReadByte(); c != 'a' || err != nil {
+		t.Errorf("ReadByte() = %q, %v, want 'a', nil", c, err)
+	}
+	b.Unread('a')
+	b.AddInput("c")
+	if got := string(b.Pending()); got != "abc" {
+		t.Errorf("Pending() = %q, want \"abc\"", got)
+	}
+
+	b.SetPending(nil)
+	if _, err := b.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte() = _, %v, want _, io.EOF", err)
+	}
+
+	b.Write([]byte("foo"))
+	if got := b.TakeOutput(); got != "foo" {
+		t.Errorf("TakeOutput() = %q, want \"foo\"", got)
+	}
+	if got := b.Output(); got != "" {
+		t.Errorf("Output() = %q, want \"\"", got)
+	}
+}
+
+func TestChan(t *testing.T) {
+	in := make(chan string, 2)
+	out := make(chan string, 1)
+	c := NewChan(in, out)
+
+	in <- "h"
+	in <- "i"
+	close(in)
+
+	got := ""
+	for {
+		b, err := c.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("ReadByte() = _, %v", err)
+		}
+		got += string(b)
+	}
+	if got != "hi" {
+		t.Errorf("read %q, want \"hi\"", got)
+	}
+
+	c.Write([]byte("out"))
+	if got := <-out; got != "out" {
+		t.Errorf("Write sent %q, want \"out\"", got)
+	}
+}
+
+func TestParagraphs(t *testing.T) {
+	got := Paragraphs("\n\n== Foyer ==\nA room.\n\n\nThings:\n- tablet\n\nWhat do you do?\n")
+	expected := []string{"== Foyer ==\nA room.", "Things:\n- tablet", "What do you do?"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Paragraphs() = %q, want %q", got, expected)
+	}
+}
+
+func TestSession(t *testing.T) {
+	session := NewSession()
+	m := vm.New(session)
+	if err := m.LoadFromPath("../../challenge.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Run(nil); !errors.Is(err, instruction.ErrNoInput) {
+		t.Fatalf("Run() = %v, want ErrNoInput", err)
+	}
+	if out := session.TakeOutput(); !strings.Contains(out, "Welcome to the Synacor Challenge!") {
+		t.Errorf("initial output = %q, want welcome message", out)
+	}
+
+	session.Send("take tablet")
+	if err := m.Run(nil); !errors.Is(err, instruction.ErrNoInput) {
+		t.Fatalf("Run() = %v, want ErrNoInput", err)
+	}
+	paras := session.TakeParagraphs()
+	if len(paras) != 2 || paras[0] != "Taken." || paras[1] != "What do you do?" {
+		t.Errorf("TakeParagraphs() = %q, want [\"Taken.\", \"What do you do?\"]", paras)
+	}
+}
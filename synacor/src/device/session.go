@@ -0,0 +1,50 @@
+package device
+
+import (
+	"io"
+	"strings"
+
+	"instruction"
+)
+
+// Session is a line-oriented device for driving the game a command at a
+// time. Reads return instruction.ErrNoInput when no command is pending,
+// which halts the VM at the in instruction. The caller collects the
+// output produced in response to the last command with TakeOutput or
+// TakeParagraphs, supplies the next command with Send, and resumes
+// execution at the same pc.
+type Session struct {
+	Buffer
+}
+
+func NewSession() *Session {
+	return &Session{Buffer{eof: instruction.ErrNoInput}}
+}
+
+// Send queues a command for the VM to read.
+func (s *Session) Send(cmd string) {
+	s.AddInput(cmd + "\n")
+}
+
+// Close causes reads to return io.EOF once the pending input is consumed.
+func (s *Session) Close() {
+	s.eof = io.EOF
+}
+
+// TakeParagraphs is like TakeOutput, but splits the output into
+// paragraphs.
+func (s *Session) TakeParagraphs() []string {
+	return Paragraphs(s.TakeOutput())
+}
+
+// Paragraphs splits out at blank lines, returning the non-empty
+// paragraphs with surrounding whitespace removed.
+func Paragraphs(out string) []string {
+	paras := []string{}
+	for _, para := range strings.Split(out, "\n\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			paras = append(paras, para)
+		}
+	}
+	return paras
+}
@@ -0,0 +1,171 @@
+// Package disasm renders binary images as assembly text.
+//
+// The output can be read back by the assembler package. Words which don't
+// decode as instructions are rendered as .word directives.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"comment"
+	"instruction"
+	"profile"
+	"reader"
+	"symtab"
+	"util"
+)
+
+type Options struct {
+	Len  int  // number of instructions to dump; -1 for all (Dump only)
+	Full bool // include read bytes and raw addrs
+
+	// If non-nil, prefix each instruction with its execution count, or
+	// with ##### if it was never executed.
+	Coverage profile.Coverage
+}
+
+type SaverReader struct {
+	r  reader.Short
+	vr [5]uint16
+	n  int
+}
+
+func NewSaverReader(r reader.Short) *SaverReader {
+	return &SaverReader{
+		r: r,
+	}
+}
+
+func (sr *SaverReader) Read() (uint16, error) {
+	v, err := sr.r.Read()
+	if err != nil {
+		return 0, err
+	}
+
+	if sr.n < len(sr.vr) {
+		sr.vr[sr.n] = v
+		sr.n++
+	}
+
+	return v, err
+}
+
+func (sr *SaverReader) Off() uint16 {
+	return sr.r.Off()
+}
+
+// Returns a slice containing the last values read. Invalidated upon next Read
+// call.
+func (sr *SaverReader) ValuesRead() []uint16 {
+	values := sr.vr[0:sr.n]
+	sr.n = 0
+	return values
+}
+
+func wordDirective(vals []uint16) string {
+	strs := make([]string, len(vals))
+	for i, v := range vals {
+		strs[i] = fmt.Sprint(v)
+	}
+	return ".word " + strings.Join(strs, ", ")
+}
+
+// printer formats disassembly lines, interleaving comments.
+type printer struct {
+	w        io.Writer
+	st       symtab.SymTab
+	cReg     comment.Registry
+	opts     Options
+	curBlock *comment.Comment
+}
+
+// line prints one line of output for the statement at addr, which was
+// assembled from vals. If note is non-empty, it's used as the comment
+// instead of one from the registry. code is true if stmt is an
+// instruction.
+func (p *printer) line(addr uint16, vals []uint16, stmt, note string, code bool) {
+	if block := p.cReg.GetBlock(int(addr)); block != nil {
+		if p.curBlock != nil {
+			panic("nested block")
+		}
+		p.curBlock = block
+
+		padLen := 33
+		if p.opts.Full {
+			padLen += 33 // 8 + 4*6 + 1
+		}
+		if p.opts.Coverage != nil {
+			padLen += 10
+		}
+
+		fmt.Fprintln(p.w)
+		for _, line := range block.Lines {
+			fmt.Fprintf(p.w, "%*s// %s\n", padLen, "", line)
+		}
+	}
+
+	if p.opts.Coverage != nil {
+		mark := ""
+		if count, found := p.opts.Coverage[addr]; found {
+			mark = fmt.Sprint(count)
+		} else if code {
+			mark = "#####"
+		}
+		fmt.Fprintf(p.w, "%9s ", mark)
+	}
+
+	fmt.Fprintf(p.w, "%30s:  ", util.AddrToName(addr, p.st))
+
+	if p.opts.Full {
+		fmt.Fprintf(p.w, "%5d:  ", addr)
+
+		shown := vals
+		if len(shown) > 4 {
+			shown = shown[0:4]
+		}
+		for _, v := range shown {
+			fmt.Fprintf(p.w, "%5d ", v)
+		}
+		for i := len(shown); i < 4; i++ {
+			fmt.Fprint(p.w, "      ")
+		}
+
+		fmt.Fprint(p.w, " ")
+	}
+
+	fmt.Fprintf(p.w, "%-30s", stmt)
+	if note != "" {
+		fmt.Fprintf(p.w, "// %s", note)
+	} else if comment, found := p.cReg.GetSingle(int(addr)); found {
+		fmt.Fprintf(p.w, "// %s", comment)
+	}
+	fmt.Fprintln(p.w)
+
+	if p.curBlock != nil && int(addr+uint16(len(vals))-1) >= p.curBlock.End {
+		fmt.Fprintln(p.w)
+		p.curBlock = nil
+	}
+}
+
+func Dump(w io.Writer, sr reader.Short, st symtab.SymTab, cReg comment.Registry, opts Options) {
+	saverReader := NewSaverReader(sr)
+	p := &printer{w: w, st: st, cReg: cReg, opts: opts}
+
+	for i := 0; opts.Len == -1 || i < opts.Len; i++ {
+		addr := sr.Off()
+
+		inst, numRead, instErr := instruction.Read(saverReader)
+		if numRead == 0 {
+			break
+		}
+
+		vr := saverReader.ValuesRead()
+		if instErr != nil {
+			p.line(addr, vr, wordDirective(vr), fmt.Sprintf("error: %v", instErr), false)
+		} else {
+			p.line(addr, vr, inst.ToString(st), "", true)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"comment"
+	"instruction"
+	"symtab"
+)
+
+// wordsReader is a reader.Short over an in-memory image.
+type wordsReader struct {
+	words []uint16
+	off   uint16
+}
+
+func (r *wordsReader) Read() (uint16, error) {
+	if int(r.off) >= len(r.words) {
+		return 0, io.EOF
+	}
+	v := r.words[r.off]
+	r.off++
+	return v, nil
+}
+
+func (r *wordsReader) Off() uint16 {
+	return r.off
+}
+
+// Func is a function discovered by Trace. Its extent runs from the entry
+// point to the last instruction reachable from it without following calls.
+type Func struct {
+	Start, End uint16
+}
+
+// Trace is the result of a recursive-descent pass over an image. Only
+// words reachable from the entry points via static control flow are
+// treated as code; everything else is data.
+type Trace struct {
+	Image []uint16
+	Insts map[uint16]*instruction.Decoded
+	Funcs map[uint16]*Func
+
+	// instStart[i] holds the start address of the instruction covering
+	// word i, or -1 if word i isn't code.
+	instStart []int
+}
+
+func NewTrace(image []uint16, entries []uint16) *Trace {
+	t := &Trace{
+		Image:     image,
+		Insts:     map[uint16]*instruction.Decoded{},
+		Funcs:     map[uint16]*Func{},
+		instStart: make([]int, len(image)),
+	}
+	for i := range t.instStart {
+		t.instStart[i] = -1
+	}
+
+	work := []uint16{}
+	for _, entry := range entries {
+		t.Funcs[entry] = &Func{Start: entry, End: entry}
+		work = append(work, entry)
+	}
+
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if int(addr) >= len(image) || t.instStart[addr] != -1 {
+			continue
+		}
+
+		inst, err := instruction.Decode(&wordsReader{image, addr})
+		if err != nil || !t.claim(inst) {
+			continue
+		}
+		t.Insts[addr] = inst
+
+		if tgt, ok := inst.StaticTarget(); ok {
+			if inst.Op.Code == instruction.OpCall {
+				if _, found := t.Funcs[tgt]; !found {
+					t.Funcs[tgt] = &Func{Start: tgt, End: tgt}
+				}
+			}
+			work = append(work, tgt)
+		}
+		if inst.FallsThrough() {
+			work = append(work, inst.Next())
+		}
+	}
+
+	for _, f := range t.Funcs {
+		t.findExtent(f)
+	}
+
+	return t
+}
+
+// claim marks the words of inst as code, refusing if any of them already
+// belong to another instruction.
+func (t *Trace) claim(inst *instruction.Decoded) bool {
+	for i := 0; i < inst.Len(); i++ {
+		if t.instStart[int(inst.Addr)+i] != -1 {
+			return false
+		}
+	}
+	for i := 0; i < inst.Len(); i++ {
+		t.instStart[int(inst.Addr)+i] = int(inst.Addr)
+	}
+	return true
+}
+
+func (t *Trace) findExtent(f *Func) {
+	for _, addr := range t.Body(f) {
+		if end := t.Insts[addr].Next() - 1; end > f.End {
+			f.End = end
+		}
+	}
+}
+
+// Body returns the sorted addresses of the instructions reachable from
+// the start of f without following calls or entering other functions.
+func (t *Trace) Body(f *Func) []uint16 {
+	seen := map[uint16]bool{}
+	work := []uint16{f.Start}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		inst, found := t.Insts[addr]
+		if !found || seen[addr] {
+			continue
+		}
+		if _, isFunc := t.Funcs[addr]; isFunc && addr != f.Start {
+			continue
+		}
+		seen[addr] = true
+
+		if tgt, ok := inst.StaticTarget(); ok && inst.Op.Code != instruction.OpCall {
+			work = append(work, tgt)
+		}
+		if inst.FallsThrough() {
+			work = append(work, inst.Next())
+		}
+	}
+
+	addrs := []uint16{}
+	for addr := range seen {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Text returns the disassembled form of the instruction at addr.
+func (t *Trace) Text(addr uint16, st symtab.SymTab) string {
+	inst, _, err := instruction.Read(&wordsReader{t.Image, addr})
+	if err != nil {
+		return wordDirective(t.Image[addr:t.Insts[addr].Next()])
+	}
+	return inst.ToString(st)
+}
+
+// IsCode returns true if addr is the start of a traced instruction.
+func (t *Trace) IsCode(addr uint16) bool {
+	_, found := t.Insts[addr]
+	return found
+}
+
+// FuncNames returns a symbol table naming each discovered function not
+// already named by st.
+func (t *Trace) FuncNames(st symtab.SymTab) symtab.SymTab {
+	names := symtab.New()
+	for _, f := range t.SortedFuncs() {
+		if ent, found := st.LookupAddr(uint(f.Start)); found && ent.Start == uint(f.Start) {
+			continue
+		}
+		names.Add(fmt.Sprintf("sub_%d", f.Start), uint(f.Start), uint(f.End))
+	}
+	return names
+}
+
+// SortedFuncs returns the discovered functions in address order.
+func (t *Trace) SortedFuncs() []*Func {
+	funcs := []*Func{}
+	for _, f := range t.Funcs {
+		funcs = append(funcs, f)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Start < funcs[j].Start })
+	return funcs
+}
+
+// WriteSymTab writes the functions named by FuncNames in symtab format.
+func (t *Trace) WriteSymTab(w io.Writer, st symtab.SymTab) error {
+	return symtab.Write(w, t.FuncNames(st))
+}
+
+// SymTab returns a symbol table that resolves names from st, falling back
+// to those from FuncNames.
+func (t *Trace) SymTab(st symtab.SymTab) symtab.SymTab {
+	return &overlaySymTab{st, t.FuncNames(st)}
+}
+
+// overlaySymTab resolves names from base, falling back to extra.
+type overlaySymTab struct {
+	base, extra symtab.SymTab
+}
+
+func (s *overlaySymTab) Add(name string, start, end uint) error {
+	return s.base.Add(name, start, end)
+}
+
+func (s *overlaySymTab) AddEntry(ent symtab.SymEnt) error {
+	return s.base.AddEntry(ent)
+}
+
+func (s *overlaySymTab) LookupAddr(addr uint) (symtab.SymEnt, bool) {
+	if ent, found := s.base.LookupAddr(addr); found {
+		return ent, true
+	}
+	return s.extra.LookupAddr(addr)
+}
+
+func (s *overlaySymTab) LookupName(name string) (symtab.SymEnt, bool) {
+	if ent, found := s.base.LookupName(name); found {
+		return ent, true
+	}
+	return s.extra.LookupName(name)
+}
+
+func (s *overlaySymTab) Entries() []symtab.SymEnt {
+	ents := append(s.base.Entries(), s.extra.Entries()...)
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Start < ents[j].Start })
+	return ents
+}
+
+const wordsPerLine = 8
+
+// DumpTrace prints the traced image. Instructions are printed as with
+// Dump, while data is printed as .string or .word directives. Discovered
+// functions are labeled sub_<addr> unless st names them.
+func DumpTrace(w io.Writer, t *Trace, st symtab.SymTab, cReg comment.Registry, opts Options) {
+	p := &printer{
+		w:    w,
+		st:   t.SymTab(st),
+		cReg: cReg,
+		opts: opts,
+	}
+
+	for addr := 0; addr < len(t.Image); {
+		if inst, found := t.Insts[uint16(addr)]; found {
+			vals := t.Image[addr : addr+inst.Len()]
+			stmt, _, err := instruction.Read(&wordsReader{t.Image, uint16(addr)})
+			if err != nil {
+				p.line(uint16(addr), vals, wordDirective(vals), fmt.Sprintf("error: %v", err), false)
+			} else {
+				p.line(uint16(addr), vals, stmt.ToString(p.st), "", true)
+			}
+			addr += inst.Len()
+			continue
+		}
+
+		end := addr
+		for end < len(t.Image) && t.instStart[end] == -1 {
+			end++
+		}
+		t.dumpData(p, addr, end)
+		addr = end
+	}
+}
+
+// dumpData prints the data words in [start, end). Length-prefixed runs of
+// printable characters become .string directives.
+func (t *Trace) dumpData(p *printer, start, end int) {
+	for addr := start; addr < end; {
+		if t.startsString(addr, end) {
+			vals := t.Image[addr : addr+1+int(t.Image[addr])]
+			p.line(uint16(addr), vals, ".string "+quoteWords(vals[1:]), "", false)
+			addr += len(vals)
+			continue
+		}
+
+		lineEnd := addr + 1
+		for lineEnd < end && lineEnd-addr < wordsPerLine && !t.startsString(lineEnd, end) {
+			lineEnd++
+		}
+		vals := t.Image[addr:lineEnd]
+		p.line(uint16(addr), vals, wordDirective(vals), "", false)
+		addr = lineEnd
+	}
+}
+
+// startsString returns true if a length-prefixed printable string that
+// ends before end starts at addr.
+func (t *Trace) startsString(addr, end int) bool {
+	n := int(t.Image[addr])
+	return n > 0 && addr+n < end && isPrintable(t.Image[addr+1:addr+1+n])
+}
+
+func isPrintable(vals []uint16) bool {
+	for _, v := range vals {
+		if v != '\n' && (v < ' ' || v > '~') {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteWords(vals []uint16) string {
+	runes := make([]rune, len(vals))
+	for i, v := range vals {
+		runes[i] = rune(v)
+	}
+	return strconv.Quote(string(runes))
+}
@@ -0,0 +1,78 @@
+package disasm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"comment"
+	"symtab"
+)
+
+var traceImage = []uint16{
+	17, 9, // 0: call 9
+	0,           // 2: hlt
+	2, 'h', 'i', // 3: data string
+	7, 8, 100, // 6: data words
+	7, 32768, 13, // 9: jt r1 13
+	18,      // 12: ret
+	19, 'x', // 13: out x
+	18, // 15: ret
+}
+
+func TestTrace(t *testing.T) {
+	tr := NewTrace(traceImage, []uint16{0})
+
+	expectedFuncs := map[uint16]*Func{
+		0: &Func{0, 2},
+		9: &Func{9, 15},
+	}
+	if !reflect.DeepEqual(tr.Funcs, expectedFuncs) {
+		t.Errorf("Funcs = %v, want %v", tr.Funcs, expectedFuncs)
+	}
+
+	for addr, want := range map[uint16]bool{0: true, 1: false, 2: true, 3: false, 9: true, 13: true} {
+		if got := tr.IsCode(addr); got != want {
+			t.Errorf("IsCode(%v) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDumpTrace(t *testing.T) {
+	tr := NewTrace(traceImage, []uint16{0})
+
+	st := symtab.New()
+	st.Add("main", 0, 2)
+
+	var out bytes.Buffer
+	DumpTrace(&out, tr, st, &comment.NullRegistry{}, Options{Len: -1})
+
+	expected := []string{
+		"main: call 9 <sub_9>",
+		"main+2: hlt",
+		`3: .string "hi"`,
+		"6: .word 7, 8, 100",
+		"sub_9: jt r1 13 <sub_9+4>",
+		"sub_9+3: ret",
+		"sub_9+4: out x",
+		"sub_9+6: ret",
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("DumpTrace() =\n%v\nwant\n%v", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	var symOut bytes.Buffer
+	if err := tr.WriteSymTab(&symOut, st); err != nil {
+		t.Fatalf("WriteSymTab() = %v, want nil", err)
+	}
+	if got, want := symOut.String(), "sub_9\t\t9-15\n"; got != want {
+		t.Errorf("WriteSymTab() wrote %q, want %q", got, want)
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/simmonmt/puzzles/synacor
 
+go 1.27.1
+
 require (
 	github.com/HuKeping/rbtree v0.0.0-20180131135737-0a7018020338
 	github.com/google/btree v0.0.0-20180813153112-4030bb1f1f0c
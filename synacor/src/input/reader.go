@@ -0,0 +1,111 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Recorder is notified of each line the Reader hands to the VM, and of
+// each directive it executes.
+type Recorder interface {
+	Input(line string)
+	Directive(d Directive)
+}
+
+// Reader supplies VM input one byte at a time. It reads from its sources a
+// line at a time -- first from the script, if any, and then from the live
+// source. The unconsumed remainder of the current line is pending, and can
+// be saved and restored along with the rest of the machine state.
+type Reader struct {
+	src     *bufio.Reader
+	pending []byte
+
+	script   []ScriptEntry
+	handler  DirectiveHandler
+	recorder Recorder
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		src: bufio.NewReader(r),
+	}
+}
+
+// SetScript causes the script to be consumed before the live source.
+// Directives in the script are passed to handler.
+func (r *Reader) SetScript(script *Script, handler DirectiveHandler) {
+	r.script = append([]ScriptEntry{}, script.Entries...)
+	r.handler = handler
+}
+
+func (r *Reader) SetRecorder(recorder Recorder) {
+	r.recorder = recorder
+}
+
+func (r *Reader) nextLine() ([]byte, error) {
+	for len(r.script) > 0 {
+		ent := r.script[0]
+		r.script = r.script[1:]
+
+		if ent.Directive == nil {
+			return []byte(ent.Line + "\n"), nil
+		}
+
+		if r.recorder != nil {
+			r.recorder.Directive(*ent.Directive)
+		}
+		if err := r.handler(*ent.Directive); err != nil {
+			return nil, fmt.Errorf("directive %v failed: %v", ent.Directive, err)
+		}
+	}
+
+	return r.src.ReadBytes('\n')
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	if len(r.pending) == 0 {
+		line, err := r.nextLine()
+		if len(line) == 0 {
+			return 0, err
+		}
+		if r.recorder != nil {
+			r.recorder.Input(string(line))
+		}
+		r.pending = line
+	}
+
+	b := r.pending[0]
+	r.pending = r.pending[1:]
+	return b, nil
+}
+
+// Unread pushes b back onto the front of the pending input.
+func (r *Reader) Unread(b byte) {
+	r.pending = append([]byte{b}, r.pending...)
+}
+
+// ReadLine reads a full line directly from the live source, bypassing (and
+// preserving) any pending or scripted input. It's used by callers like the
+// debugger which share the source with the VM. The trailing newline is not
+// returned.
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.src.ReadString('\n')
+	if line == "" && err != nil {
+		return "", err
+	}
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[0 : n-1]
+	}
+	return line, nil
+}
+
+// Pending returns a copy of the input that has been read from the source
+// but not yet consumed.
+func (r *Reader) Pending() []byte {
+	return append([]byte{}, r.pending...)
+}
+
+func (r *Reader) SetPending(pending []byte) {
+	r.pending = append([]byte{}, pending...)
+}
@@ -0,0 +1,51 @@
+package input
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadByte(t *testing.T) {
+	r := NewReader(strings.NewReader("ab\ncd"))
+
+	for _, want := range []byte("ab\ncd") {
+		if got, err := r.ReadByte(); err != nil || got != want {
+			t.Errorf("ReadByte() = %q, %v, want %q, nil", got, err, want)
+		}
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte() = _, %v, want _, EOF", err)
+	}
+}
+
+func TestPending(t *testing.T) {
+	r := NewReader(strings.NewReader("abc\ndebug\nxyz\n"))
+
+	if b, _ := r.ReadByte(); b != 'a' {
+		t.Errorf("ReadByte() = %q, want 'a'", b)
+	}
+	if pending := string(r.Pending()); pending != "bc\n" {
+		t.Errorf(`Pending() = %q, want "bc\n"`, pending)
+	}
+
+	// ReadLine shouldn't disturb the pending input.
+	if line, err := r.ReadLine(); err != nil || line != "debug" {
+		t.Errorf(`ReadLine() = %q, %v, want "debug", nil`, line, err)
+	}
+
+	r.SetPending([]byte("q\n"))
+	want := "q\nxyz\n"
+	got := ""
+	for i := 0; i < len(want); i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("ReadByte() = _, %v, want _, nil", err)
+		}
+		got += string(b)
+	}
+	if got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
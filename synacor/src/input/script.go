@@ -0,0 +1,82 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Scripts supply input lines to the VM ahead of the live source. Lines
+// starting with # are comments. Lines starting with ! are directives to the
+// VM itself (e.g. "!pause", "!snapshot path", "!set r8=25734"), and are
+// passed to the DirectiveHandler rather than the VM. Blank lines are
+// ignored. Everything else is VM input.
+
+type Directive struct {
+	Name string
+	Args []string
+}
+
+func (d Directive) String() string {
+	return strings.Join(append([]string{d.Name}, d.Args...), " ")
+}
+
+type DirectiveHandler func(d Directive) error
+
+type ScriptEntry struct {
+	// Exactly one of Line or Directive is set.
+	Line      string
+	Directive *Directive
+}
+
+type Script struct {
+	Entries []ScriptEntry
+}
+
+func ParseDirective(str string) (*Directive, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty directive")
+	}
+	return &Directive{Name: fields[0], Args: fields[1:]}, nil
+}
+
+func ReadScript(r io.Reader) (*Script, error) {
+	script := &Script{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			d, err := ParseDirective(line[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", lineNum, err)
+			}
+			script.Entries = append(script.Entries, ScriptEntry{Directive: d})
+			continue
+		}
+
+		script.Entries = append(script.Entries, ScriptEntry{Line: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return script, nil
+}
+
+func ReadScriptFromPath(path string) (*Script, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return ReadScript(fp)
+}
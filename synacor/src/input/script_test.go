@@ -0,0 +1,80 @@
+package input
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadScript(t *testing.T) {
+	in := `
+		# comment
+		take tablet
+		!set r8=25734
+
+		!pause
+		go doorway`
+
+	script, err := ReadScript(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadScript() = _, %v, want _, nil", err)
+	}
+
+	expected := &Script{
+		Entries: []ScriptEntry{
+			ScriptEntry{Line: "take tablet"},
+			ScriptEntry{Directive: &Directive{Name: "set", Args: []string{"r8=25734"}}},
+			ScriptEntry{Directive: &Directive{Name: "pause", Args: []string{}}},
+			ScriptEntry{Line: "go doorway"},
+		},
+	}
+
+	if !reflect.DeepEqual(script, expected) {
+		t.Errorf("ReadScript() = %+v, want %+v", script, expected)
+	}
+}
+
+type testRecorder struct {
+	events []string
+}
+
+func (r *testRecorder) Input(line string)     { r.events = append(r.events, "in:"+line) }
+func (r *testRecorder) Directive(d Directive) { r.events = append(r.events, "dir:"+d.String()) }
+
+func TestScriptedReader(t *testing.T) {
+	script, err := ReadScript(strings.NewReader("a\n!pause\nb\n"))
+	if err != nil {
+		t.Fatalf("ReadScript() = _, %v, want _, nil", err)
+	}
+
+	handled := []string{}
+	recorder := &testRecorder{}
+
+	r := NewReader(strings.NewReader("live\n"))
+	r.SetRecorder(recorder)
+	r.SetScript(script, func(d Directive) error {
+		handled = append(handled, d.String())
+		return nil
+	})
+
+	got := ""
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		got += string(b)
+	}
+
+	if want := "a\nb\nlive\n"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	if want := []string{"pause"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+
+	wantEvents := []string{"in:a\n", "dir:pause", "in:b\n", "in:live\n"}
+	if !reflect.DeepEqual(recorder.events, wantEvents) {
+		t.Errorf("recorded %q, want %q", recorder.events, wantEvents)
+	}
+}
@@ -0,0 +1,259 @@
+package instruction_test
+
+// A conformance suite for the instructions in arch-spec. Each case is a
+// tiny program, assembled and run from address 0 until it halts.
+//
+// Our registers are numbered from 1, so the spec's register 0 (encoded as
+// 32768) is r1 here, and its register 7 (32775) is r8.
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"assembler"
+	"device"
+	"instruction"
+	"memory"
+	"register"
+	"symtab"
+	"vm"
+)
+
+const maxSteps = 1000
+
+type result struct {
+	pc    uint16
+	regs  [register.Num]uint16
+	stack []uint16
+	out   string
+	ram   *memory.RAM
+	err   error
+}
+
+// run assembles src and executes it on a vm.Machine with input available
+// to in. It returns when the program halts, or fails the test if it runs
+// for more than maxSteps instructions.
+func run(t *testing.T, src, input string) *result {
+	t.Helper()
+
+	prog, err := assembler.Assemble(strings.NewReader(src), 0, symtab.New())
+	if err != nil {
+		t.Fatalf("Assemble(%q) = %v", src, err)
+	}
+
+	buf := device.NewBuffer(input)
+	m := vm.New(buf)
+	if err := m.Load(prog.Words); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	steps := 0
+	err = m.Run(func(*vm.Step) bool {
+		steps++
+		return steps == maxSteps
+	})
+	if !m.Halted() && err == nil {
+		t.Fatalf("%q: no halt after %d steps", src, maxSteps)
+	}
+
+	res := &result{pc: m.PC, ram: m.RAM(), err: err}
+	for i := range res.regs {
+		res.regs[i] = m.RegFile().Get(uint(i))
+	}
+	res.stack = m.Stack().Values()
+	res.out = buf.Output()
+	return res
+}
+
+func TestConformance(t *testing.T) {
+	type testCase struct {
+		desc  string
+		src   string
+		input string
+
+		pc    uint16            // where execution stopped
+		regs  map[uint]uint16   // registers to check; others must be 0
+		stack []uint16          // stack contents, bottom first
+		out   string            // output
+		mem   map[uint16]uint16 // memory to check
+		err   string            // error substring, if execution should fail
+	}
+
+	testCases := []testCase{
+		// halt: 0
+		{desc: "halt stops execution", src: "halt\nset r1 1", pc: 0},
+
+		// set: 1 a b
+		{desc: "set literal", src: "set r1 5\nhalt", pc: 3, regs: map[uint]uint16{1: 5}},
+		{desc: "set register", src: "set r2 7\nset r1 r2\nhalt", pc: 6, regs: map[uint]uint16{1: 7, 2: 7}},
+		{
+			desc: "first and last registers",
+			src:  ".word 1, 32768, 11, 1, 32775, 88\nhalt",
+			pc:   6,
+			regs: map[uint]uint16{1: 11, 8: 88},
+		},
+		{desc: "set to literal", src: ".word 1, 5, 6\nhalt", err: "non-reg result"},
+
+		// push: 2 a, pop: 3 a
+		{desc: "push", src: "set r1 4\npush r1\npush 9\nhalt", pc: 7, regs: map[uint]uint16{1: 4}, stack: []uint16{4, 9}},
+		{desc: "pop", src: "push 1\npush 2\npop r1\nhalt", pc: 6, regs: map[uint]uint16{1: 2}, stack: []uint16{1}},
+		{desc: "pop from empty stack", src: "pop r1\nhalt", pc: 0, err: instruction.ErrEmptyStack.Error()},
+		{desc: "pop to literal", src: ".word 3, 1\nhalt", err: "non-reg result"},
+
+		// eq: 4 a b c, gt: 5 a b c
+		{desc: "eq true", src: "set r2 3\neq r1 r2 3\nhalt", pc: 7, regs: map[uint]uint16{1: 1, 2: 3}},
+		{desc: "eq false", src: "eq r1 2 3\nhalt", pc: 4, regs: map[uint]uint16{1: 0}},
+		{desc: "gt true", src: "gt r1 32767 0\nhalt", pc: 4, regs: map[uint]uint16{1: 1}},
+		{desc: "gt equal", src: "gt r1 3 3\nhalt", pc: 4, regs: map[uint]uint16{1: 0}},
+
+		// jmp: 6 a
+		{desc: "jmp literal", src: "jmp 3\nhalt\nset r1 1\nhalt", pc: 6, regs: map[uint]uint16{1: 1}},
+		{desc: "jmp register", src: "set r2 6\njmp r2\nhalt\nset r1 1\nhalt", pc: 9, regs: map[uint]uint16{1: 1, 2: 6}},
+
+		// jt: 7 a b, jf: 8 a b
+		{desc: "jt taken", src: "jt 1 4\nhalt\nhalt", pc: 4},
+		{desc: "jt not taken", src: "jt 0 4\nhalt\nhalt", pc: 3},
+		{desc: "jt register target", src: "set r1 9\njt r1 r1\nhalt\nhalt", pc: 9, regs: map[uint]uint16{1: 9}},
+		{desc: "jf taken", src: "jf 0 4\nhalt\nhalt", pc: 4},
+		{desc: "jf not taken", src: "jf 5 4\nhalt\nhalt", pc: 3},
+		{desc: "jf register target", src: "set r2 9\njf r1 r2\nhalt\nhalt", pc: 9, regs: map[uint]uint16{2: 9}},
+
+		// add: 9 a b c
+		{desc: "add", src: "set r2 3\nadd r1 r2 4\nhalt", pc: 7, regs: map[uint]uint16{1: 7, 2: 3}},
+		{desc: "add wraps", src: "add r1 32758 15\nhalt", pc: 4, regs: map[uint]uint16{1: 5}},
+
+		// mult: 10 a b c
+		{desc: "mult", src: "mult r1 6 7\nhalt", pc: 4, regs: map[uint]uint16{1: 42}},
+		{desc: "mult wraps", src: "mult r1 300 300\nhalt", pc: 4, regs: map[uint]uint16{1: 90000 % 32768}},
+		{desc: "mult wraps past 16 bits", src: "mult r1 32767 32767\nhalt", pc: 4, regs: map[uint]uint16{1: 1}},
+
+		// mod: 11 a b c
+		{desc: "mod", src: "mod r1 32767 10\nhalt", pc: 4, regs: map[uint]uint16{1: 7}},
+		{desc: "mod by zero", src: "mod r1 5 r2\nhalt", pc: 0, err: instruction.ErrDivByZero.Error()},
+
+		// and: 12 a b c, or: 13 a b c, not: 14 a b
+		{desc: "and", src: "and r1 12 10\nhalt", pc: 4, regs: map[uint]uint16{1: 8}},
+		{desc: "or", src: "or r1 12 10\nhalt", pc: 4, regs: map[uint]uint16{1: 14}},
+		{desc: "not zero", src: "not r1 0\nhalt", pc: 3, regs: map[uint]uint16{1: 32767}},
+		{desc: "not is 15-bit", src: "not r1 21845\nhalt", pc: 3, regs: map[uint]uint16{1: 10922}},
+
+		// rmem: 15 a b, wmem: 16 a b
+		{desc: "rmem literal", src: "rmem r1 data\nhalt\ndata: .word 1234", pc: 3, regs: map[uint]uint16{1: 1234}},
+		{
+			desc: "rmem register",
+			src:  "set r2 data\nrmem r1 r2\nhalt\ndata: .word 4321",
+			pc:   6,
+			regs: map[uint]uint16{1: 4321, 2: 7},
+		},
+		{
+			desc: "wmem",
+			src:  "set r1 100\nwmem r1 5\nwmem 101 r1\nhalt",
+			pc:   9,
+			regs: map[uint]uint16{1: 100},
+			mem:  map[uint16]uint16{100: 5, 101: 100},
+		},
+		{desc: "wmem self-modification", src: "wmem 3 21\nhalt\nhalt", pc: 4, mem: map[uint16]uint16{3: 21}},
+
+		// call: 17 a, ret: 18
+		{
+			desc:  "call pushes the return address",
+			src:   "call f\nhalt\nf: set r1 1\nhalt",
+			pc:    6,
+			regs:  map[uint]uint16{1: 1},
+			stack: []uint16{2},
+		},
+		{desc: "call and ret", src: "call f\nhalt\nf: set r1 1\nret", pc: 2, regs: map[uint]uint16{1: 1}},
+		{desc: "call register", src: "set r2 f\ncall r2\nhalt\nf: ret", pc: 5, regs: map[uint]uint16{2: 6}},
+		{desc: "ret with empty stack halts", src: "ret\nset r1 1\nhalt", pc: 0},
+
+		// out: 19 a
+		{desc: "out", src: "set r1 'i'\nout h\nout r1\nout 0x0a\nhalt", pc: 9, regs: map[uint]uint16{1: 'i'}, out: "hi\n"},
+
+		// in: 20 a
+		{
+			desc:  "in",
+			src:   "in r1\nin r2\nhalt",
+			input: "ab",
+			pc:    4,
+			regs:  map[uint]uint16{1: 'a', 2: 'b'},
+		},
+		{desc: "in with no input", src: "in r1\nhalt", pc: 0, err: "EOF"},
+
+		// noop: 21
+		{desc: "noop", src: "noop\nnop\nhalt", pc: 2},
+
+		// Invalid operands and opcodes.
+		{desc: "invalid destination", src: ".word 1, 32776, 1\nhalt", err: "invalid operand 32776"},
+		{desc: "invalid source", src: ".word 9, 32768, 1, 65535\nhalt", err: "invalid operand 65535"},
+		{desc: "invalid target", src: ".word 6, 40000\nhalt", err: "invalid operand 40000"},
+		{desc: "unknown opcode", src: ".word 22\nhalt", err: "unknown op 22"},
+	}
+
+	for _, tc := range testCases {
+		got := run(t, tc.src, tc.input)
+
+		if tc.err != "" {
+			if got.err == nil || !strings.Contains(got.err.Error(), tc.err) {
+				t.Errorf("%v: err = %v, want %q", tc.desc, got.err, tc.err)
+			}
+		} else if got.err != nil {
+			t.Errorf("%v: err = %v, want nil", tc.desc, got.err)
+		}
+
+		if got.pc != tc.pc {
+			t.Errorf("%v: pc = %v, want %v", tc.desc, got.pc, tc.pc)
+		}
+
+		var wantRegs [register.Num]uint16
+		for num, val := range tc.regs {
+			wantRegs[num] = val
+		}
+		if got.regs != wantRegs {
+			t.Errorf("%v: regs = %v, want %v", tc.desc, got.regs, wantRegs)
+		}
+
+		if tc.stack == nil {
+			tc.stack = []uint16{}
+		}
+		if !reflect.DeepEqual(got.stack, tc.stack) {
+			t.Errorf("%v: stack = %v, want %v", tc.desc, got.stack, tc.stack)
+		}
+
+		if got.out != tc.out {
+			t.Errorf("%v: out = %q, want %q", tc.desc, got.out, tc.out)
+		}
+
+		for addr, want := range tc.mem {
+			if val := got.ram.Read(addr); val != want {
+				t.Errorf("%v: mem[%d] = %v, want %v", tc.desc, addr, val, want)
+			}
+		}
+	}
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	for num := uint(1); num <= 8; num++ {
+		arg := instruction.RegArg(num)
+		if want := uint16(32767 + num); arg != want {
+			t.Errorf("RegArg(%v) = %v, want %v", num, arg, want)
+		}
+		if !instruction.IsReg(arg) || instruction.RegNum(arg) != num {
+			t.Errorf("IsReg(%v), RegNum(%v) = %v, %v, want true, %v",
+				arg, arg, instruction.IsReg(arg), instruction.RegNum(arg), num)
+		}
+	}
+
+	if instruction.IsReg(32767) {
+		t.Errorf("IsReg(32767) = true, want false")
+	}
+}
+
+func TestDecodeInvalidOperand(t *testing.T) {
+	ram := &memory.RAM{}
+	copy(ram[:], []uint16{instruction.OpAdd, 32768, 32769, 32776})
+
+	if _, err := instruction.Decode(memory.NewRAMReader(ram, 0)); err == nil || !strings.Contains(err.Error(), "invalid operand 32776") {
+		t.Errorf("Decode() = _, %v, want invalid operand", err)
+	}
+}
@@ -0,0 +1,84 @@
+package instruction
+
+import (
+	"fmt"
+
+	"reader"
+)
+
+// Decoded is the raw form of an instruction, for use by analysis tools.
+type Decoded struct {
+	Addr uint16
+	Op   Op
+	Args []uint16
+}
+
+// Decode reads an instruction without building an Inst.
+func Decode(sr reader.Short) (*Decoded, error) {
+	addr := sr.Off()
+
+	code, err := sr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	op, found := LookupOpCode(code)
+	if !found {
+		return nil, fmt.Errorf("unknown op %v", code)
+	}
+
+	args := make([]uint16, op.NumArgs)
+	for i := range args {
+		if args[i], err = (operandReader{sr}).Read(); err != nil {
+			return nil, fmt.Errorf("bad %v read: %v", op.Name, err)
+		}
+	}
+
+	if op.RegDest && !IsReg(args[0]) {
+		return nil, fmt.Errorf("non-reg result")
+	}
+
+	return &Decoded{Addr: addr, Op: op, Args: args}, nil
+}
+
+func (d *Decoded) Len() int {
+	return 1 + len(d.Args)
+}
+
+// Next returns the address of the following instruction.
+func (d *Decoded) Next() uint16 {
+	return d.Addr + uint16(d.Len())
+}
+
+// Target returns the argument holding the destination of a jump or call,
+// and whether the instruction has one.
+func (d *Decoded) Target() (uint16, bool) {
+	switch d.Op.Code {
+	case OpJmp, OpCall:
+		return d.Args[0], true
+	case OpJt, OpJf:
+		return d.Args[1], true
+	default:
+		return 0, false
+	}
+}
+
+// StaticTarget is like Target, but only returns literal destinations.
+func (d *Decoded) StaticTarget() (uint16, bool) {
+	tgt, ok := d.Target()
+	if !ok || IsReg(tgt) {
+		return 0, false
+	}
+	return tgt, true
+}
+
+// FallsThrough returns true if execution can continue with the next
+// instruction. Calls are assumed to return.
+func (d *Decoded) FallsThrough() bool {
+	switch d.Op.Code {
+	case OpJmp, OpRet, OpHlt:
+		return false
+	default:
+		return true
+	}
+}
@@ -1,9 +1,9 @@
 package instruction
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"unicode"
 
@@ -17,13 +17,125 @@ type Context struct {
 	RAM           *memory.RAM
 	RegFile       *register.File
 	Stack         *memory.Stack
+	IO            Device
 	Verbose       *bool
 	VerboseWriter io.Writer
+	Journal       Journal
+	Watcher       Watcher
+}
+
+// Device is used by the in and out instructions. Implementations are in
+// the device package.
+type Device interface {
+	ReadByte() (byte, error)
+	Write(p []byte) (int, error)
+}
+
+// ErrNoInput is returned by devices which have no input ready but may
+// later. An in instruction that gets it halts without consuming input, so
+// execution can resume at the same pc once input has been supplied.
+var ErrNoInput = errors.New("no input available")
+
+var (
+	ErrEmptyStack = errors.New("pop from empty stack")
+	ErrDivByZero  = errors.New("mod by zero")
+)
+
+// Journal, if set in the Context, is told about each change to machine
+// state made by Exec before the change is made.
+type Journal interface {
+	RegWrite(num uint, old, val uint16)
+	MemWrite(addr, old, val uint16)
+	Push(val uint16)
+	Pop(val uint16)
+	InputRead(b byte)
+}
+
+// Watcher, if set in the Context, is told about each register write and
+// each memory access made by rmem and wmem.
+type Watcher interface {
+	RegWrite(num uint, old, val uint16)
+	MemRead(addr, val uint16)
+	MemWrite(addr, old, val uint16)
+}
+
+func (ctx *Context) setReg(num uint, val uint16) {
+	if ctx.Journal != nil || ctx.Watcher != nil {
+		old := ctx.RegFile.Get(num)
+		if ctx.Journal != nil {
+			ctx.Journal.RegWrite(num, old, val)
+		}
+		if ctx.Watcher != nil {
+			ctx.Watcher.RegWrite(num, old, val)
+		}
+	}
+	ctx.RegFile.Set(num, val)
+}
+
+func (ctx *Context) readMem(addr uint16) uint16 {
+	val := ctx.RAM.Read(addr)
+	if ctx.Watcher != nil {
+		ctx.Watcher.MemRead(addr, val)
+	}
+	return val
+}
+
+func (ctx *Context) writeMem(addr, val uint16) {
+	if ctx.Journal != nil || ctx.Watcher != nil {
+		old := ctx.RAM.Read(addr)
+		if ctx.Journal != nil {
+			ctx.Journal.MemWrite(addr, old, val)
+		}
+		if ctx.Watcher != nil {
+			ctx.Watcher.MemWrite(addr, old, val)
+		}
+	}
+	ctx.RAM.Write(addr, val)
+}
+
+func (ctx *Context) push(val uint16) {
+	if ctx.Journal != nil {
+		ctx.Journal.Push(val)
+	}
+	ctx.Stack.Push(val)
+}
+
+func (ctx *Context) pop() (uint16, bool) {
+	val, found := ctx.Stack.Pop()
+	if found && ctx.Journal != nil {
+		ctx.Journal.Pop(val)
+	}
+	return val, found
+}
+
+func (ctx *Context) readInput() (byte, error) {
+	b, err := ctx.IO.ReadByte()
+	if err == nil && ctx.Journal != nil {
+		ctx.Journal.InputRead(b)
+	}
+	return b, err
 }
 
 type CB struct {
 	Hlt bool
 	NPC uint16
+	Err error // why the instruction halted, if it failed
+}
+
+// MaxArg is the largest valid operand: the encoding of r8.
+const MaxArg = 32775
+
+// operandReader rejects operands which are neither values nor registers.
+type operandReader struct {
+	reader.Short
+}
+
+func (r operandReader) Read() (uint16, error) {
+	val, err := r.Short.Read()
+	if err == nil && val > MaxArg {
+		return 0, fmt.Errorf("invalid operand %v", val)
+	}
+	return val, err
 }
 
 func read2(sr reader.Short) (a, b uint16, err error) {
@@ -54,11 +166,11 @@ func read3(sr reader.Short) (a, b, c uint16, err error) {
 	return a, b, c, nil
 }
 
-func isReg(val uint16) bool {
+func IsReg(val uint16) bool {
 	return val > 32767
 }
 
-func regNum(val uint16) uint {
+func RegNum(val uint16) uint {
 	return uint(val - 32767)
 }
 
@@ -67,16 +179,16 @@ func regName(num uint) string {
 }
 
 func argToStr(val uint16) string {
-	if isReg(val) {
-		return regName(regNum(val))
+	if IsReg(val) {
+		return regName(RegNum(val))
 	} else {
 		return fmt.Sprint(val)
 	}
 }
 
 func tgtToStr(val uint16, st symtab.SymTab) string {
-	if isReg(val) {
-		return regName(regNum(val))
+	if IsReg(val) {
+		return regName(RegNum(val))
 	} else if ent, found := st.LookupAddr(uint(val)); found {
 		return fmt.Sprintf("%d <%s>", val, ent.OffStr(uint(val)))
 	}
@@ -84,8 +196,8 @@ func tgtToStr(val uint16, st symtab.SymTab) string {
 }
 
 func regOrVal(num uint16, regFile *register.File) uint16 {
-	if isReg(num) {
-		return regFile.Get(regNum(num))
+	if IsReg(num) {
+		return regFile.Get(RegNum(num))
 	} else {
 		return num
 	}
@@ -109,7 +221,7 @@ func (i *add) Exec(ctx *Context, cb *CB) {
 	b := regOrVal(i.b, ctx.RegFile)
 	c := regOrVal(i.c, ctx.RegFile)
 	a := (b + c) % 32768
-	ctx.RegFile.Set(regNum(i.a), a)
+	ctx.setReg(RegNum(i.a), a)
 }
 
 type and struct {
@@ -122,7 +234,7 @@ func (i *and) ToString(st symtab.SymTab) string {
 }
 
 func (i *and) Exec(ctx *Context, cb *CB) {
-	ctx.RegFile.Set(regNum(i.a), regOrVal(i.b, ctx.RegFile)&regOrVal(i.c, ctx.RegFile))
+	ctx.setReg(RegNum(i.a), regOrVal(i.b, ctx.RegFile)&regOrVal(i.c, ctx.RegFile))
 }
 
 type call struct {
@@ -134,7 +246,7 @@ func (i *call) ToString(st symtab.SymTab) string {
 }
 
 func (i *call) Exec(ctx *Context, cb *CB) {
-	ctx.Stack.Push(cb.NPC)
+	ctx.push(cb.NPC)
 	cb.NPC = regOrVal(i.a, ctx.RegFile)
 }
 
@@ -152,7 +264,7 @@ func (i *eq) Exec(ctx *Context, cb *CB) {
 	if regOrVal(i.b, ctx.RegFile) == regOrVal(i.c, ctx.RegFile) {
 		res = 1
 	}
-	ctx.RegFile.Set(regNum(i.a), res)
+	ctx.setReg(RegNum(i.a), res)
 }
 
 type gt struct {
@@ -169,7 +281,7 @@ func (i *gt) Exec(ctx *Context, cb *CB) {
 	if regOrVal(i.b, ctx.RegFile) > regOrVal(i.c, ctx.RegFile) {
 		res = 1
 	}
-	ctx.RegFile.Set(regNum(i.a), res)
+	ctx.setReg(RegNum(i.a), res)
 }
 
 type in struct {
@@ -181,13 +293,14 @@ func (i *in) ToString(st symtab.SymTab) string {
 }
 
 func (i *in) Exec(ctx *Context, cb *CB) {
-	b := [1]byte{}
-	n, err := os.Stdin.Read(b[:])
-	if n == 0 || err != nil {
-		panic("bad read")
+	b, err := ctx.readInput()
+	if err != nil {
+		cb.Hlt = true
+		cb.Err = fmt.Errorf("bad read: %w", err)
+		return
 	}
 
-	ctx.RegFile.Set(regNum(i.a), uint16(b[0]))
+	ctx.setReg(RegNum(i.a), uint16(b))
 }
 
 type hlt struct{}
@@ -254,8 +367,13 @@ func (i *mod) ToString(st symtab.SymTab) string {
 func (i *mod) Exec(ctx *Context, cb *CB) {
 	b := regOrVal(i.b, ctx.RegFile)
 	c := regOrVal(i.c, ctx.RegFile)
+	if c == 0 {
+		cb.Hlt = true
+		cb.Err = ErrDivByZero
+		return
+	}
 	a := (b % c) % 32768
-	ctx.RegFile.Set(regNum(i.a), a)
+	ctx.setReg(RegNum(i.a), a)
 }
 
 type mult struct {
@@ -271,7 +389,7 @@ func (i *mult) Exec(ctx *Context, cb *CB) {
 	b := regOrVal(i.b, ctx.RegFile)
 	c := regOrVal(i.c, ctx.RegFile)
 	a := (b * c) % 32768
-	ctx.RegFile.Set(regNum(i.a), a)
+	ctx.setReg(RegNum(i.a), a)
 }
 
 type nop struct{}
@@ -288,7 +406,7 @@ func (i *not) ToString(st symtab.SymTab) string {
 }
 
 func (i *not) Exec(ctx *Context, cb *CB) {
-	ctx.RegFile.Set(regNum(i.a), (^regOrVal(i.b, ctx.RegFile))&0x7fff)
+	ctx.setReg(RegNum(i.a), (^regOrVal(i.b, ctx.RegFile))&0x7fff)
 }
 
 type or struct {
@@ -301,7 +419,7 @@ func (i *or) ToString(st symtab.SymTab) string {
 }
 
 func (i *or) Exec(ctx *Context, cb *CB) {
-	ctx.RegFile.Set(regNum(i.a), regOrVal(i.b, ctx.RegFile)|regOrVal(i.c, ctx.RegFile))
+	ctx.setReg(RegNum(i.a), regOrVal(i.b, ctx.RegFile)|regOrVal(i.c, ctx.RegFile))
 }
 
 type ret struct{}
@@ -309,7 +427,7 @@ type ret struct{}
 func (i *ret) ToString(st symtab.SymTab) string { return "ret" }
 
 func (i *ret) Exec(ctx *Context, cb *CB) {
-	dest, found := ctx.Stack.Pop()
+	dest, found := ctx.pop()
 	if !found {
 		cb.Hlt = true
 		return
@@ -327,8 +445,8 @@ func (i *rmem) ToString(st symtab.SymTab) string {
 }
 
 func (i *rmem) Exec(ctx *Context, cb *CB) {
-	val := ctx.RAM.Read(regOrVal(i.b, ctx.RegFile))
-	ctx.RegFile.Set(regNum(i.a), val)
+	val := ctx.readMem(regOrVal(i.b, ctx.RegFile))
+	ctx.setReg(RegNum(i.a), val)
 }
 
 type out struct {
@@ -336,13 +454,13 @@ type out struct {
 }
 
 func (i *out) ToString(st symtab.SymTab) string {
-	if isReg(i.a) {
-		return fmt.Sprintf("out %s", regName(regNum(i.a)))
+	if IsReg(i.a) {
+		return fmt.Sprintf("out %s", regName(RegNum(i.a)))
 	}
 
 	b := byte(i.a)
-	if !unicode.IsPrint(rune(b)) {
-		return fmt.Sprintf("out 0x%02x", b)
+	if i.a > 0xff || !unicode.IsPrint(rune(b)) {
+		return fmt.Sprintf("out 0x%02x", i.a)
 	}
 
 	return fmt.Sprintf("out %s", string(b))
@@ -350,10 +468,10 @@ func (i *out) ToString(st symtab.SymTab) string {
 
 func (i *out) Exec(ctx *Context, cb *CB) {
 	val := regOrVal(i.a, ctx.RegFile)
-	if *ctx.Verbose {
+	if ctx.Verbose != nil && *ctx.Verbose {
 		fmt.Fprintf(ctx.VerboseWriter, "=== out: %s (%d) ===\n", string(byte(val)), val)
 	}
-	fmt.Print(string(byte(val)))
+	ctx.IO.Write([]byte{byte(val)})
 }
 
 type pop struct {
@@ -365,11 +483,13 @@ func (i *pop) ToString(st symtab.SymTab) string {
 }
 
 func (i *pop) Exec(ctx *Context, cb *CB) {
-	val, found := ctx.Stack.Pop()
+	val, found := ctx.pop()
 	if !found {
-		panic("empty stack")
+		cb.Hlt = true
+		cb.Err = ErrEmptyStack
+		return
 	}
-	ctx.RegFile.Set(regNum(i.a), val)
+	ctx.setReg(RegNum(i.a), val)
 }
 
 type push struct {
@@ -381,7 +501,7 @@ func (i *push) ToString(st symtab.SymTab) string {
 }
 
 func (i *push) Exec(ctx *Context, cb *CB) {
-	ctx.Stack.Push(regOrVal(i.a, ctx.RegFile))
+	ctx.push(regOrVal(i.a, ctx.RegFile))
 }
 
 type set struct {
@@ -393,7 +513,7 @@ func (i *set) ToString(st symtab.SymTab) string {
 }
 
 func (i *set) Exec(ctx *Context, cb *CB) {
-	ctx.RegFile.Set(regNum(i.res), regOrVal(i.src, ctx.RegFile))
+	ctx.setReg(RegNum(i.res), regOrVal(i.src, ctx.RegFile))
 }
 
 type wmem struct {
@@ -407,7 +527,7 @@ func (i *wmem) ToString(st symtab.SymTab) string {
 func (i *wmem) Exec(ctx *Context, cb *CB) {
 	addr := regOrVal(i.a, ctx.RegFile)
 	val := regOrVal(i.b, ctx.RegFile)
-	ctx.RAM.Write(addr, val)
+	ctx.writeMem(addr, val)
 }
 
 func Read(sr reader.Short) (Inst, int, error) {
@@ -416,7 +536,7 @@ func Read(sr reader.Short) (Inst, int, error) {
 		return nil, 0, err
 	}
 
-	inst, argLen, err := new(op, sr)
+	inst, argLen, err := new(op, operandReader{sr})
 	if err != nil {
 		return nil, 1, err
 	}
@@ -426,191 +546,182 @@ func Read(sr reader.Short) (Inst, int, error) {
 
 func new(op uint16, sr reader.Short) (Inst, int, error) {
 	switch op {
-	case 0:
+	case OpHlt:
 		return &hlt{}, 0, nil
 
-	case 1:
+	case OpSet:
 		res, src, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad set read: %v", err)
 		}
-		if !isReg(res) {
+		if !IsReg(res) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &set{res: res, src: src}, 2, nil
 
-	case 2:
+	case OpPush:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad push read: %v", err)
 		}
 		return &push{a}, 1, nil
 
-	case 3:
+	case OpPop:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad pop read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &pop{a}, 1, nil
 
-	case 4:
+	case OpEq:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad eq read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &eq{a, b, c}, 3, nil
 
-	case 5:
+	case OpGt:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad gt read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &gt{a, b, c}, 3, nil
 
-	case 6:
+	case OpJmp:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad jmp read: %v", err)
 		}
-		if isReg(a) {
-			panic("unexpected jmp to reg value")
-		}
 		return &jmp{a}, 1, nil
 
-	case 7:
+	case OpJt:
 		cond, tgt, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad jt read: %v", err)
 		}
-		if isReg(tgt) {
-			panic("unexpected jt to tgt value")
-		}
 		return &jt{cond, tgt}, 2, nil
 
-	case 8:
+	case OpJf:
 		cond, tgt, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad jf read: %v", err)
 		}
-		if isReg(tgt) {
-			panic("unexpected jf to tgt value")
-		}
 		return &jf{cond, tgt}, 2, nil
 
-	case 9:
+	case OpAdd:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad add read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &add{a, b, c}, 3, nil
 
-	case 10:
+	case OpMult:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad mult read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &mult{a, b, c}, 3, nil
 
-	case 11:
+	case OpMod:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad mod read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &mod{a, b, c}, 3, nil
 
-	case 12:
+	case OpAnd:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad type or and read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &and{a, b, c}, 3, nil
 
-	case 13:
+	case OpOr:
 		a, b, c, err := read3(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad or read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &or{a, b, c}, 3, nil
 
-	case 14:
+	case OpNot:
 		a, b, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad not read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &not{a, b}, 2, nil
 
-	case 15:
+	case OpRmem:
 		a, b, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad rmem read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &rmem{a, b}, 2, nil
 
-	case 16:
+	case OpWmem:
 		a, b, err := read2(sr)
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad wmem read: %v", err)
 		}
 		return &wmem{a, b}, 2, nil
 
-	case 17:
+	case OpCall:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad call read: %v", err)
 		}
 		return &call{a}, 1, nil
 
-	case 18:
+	case OpRet:
 		return &ret{}, 0, nil
 
-	case 19:
+	case OpOut:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad out read: %v", err)
 		}
 		return &out{a}, 1, nil
 
-	case 20:
+	case OpIn:
 		a, err := sr.Read()
 		if err != nil {
 			return nil, 0, fmt.Errorf("bad in read: %v", err)
 		}
-		if !isReg(a) {
+		if !IsReg(a) {
 			return nil, 0, fmt.Errorf("non-reg result")
 		}
 		return &in{a}, 1, nil
 
-	case 21:
+	case OpNop:
 		return &nop{}, 0, nil
 	default:
 		return nil, 0, fmt.Errorf("unknown op %v", op)
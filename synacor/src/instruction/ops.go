@@ -0,0 +1,91 @@
+package instruction
+
+// Op describes an opcode.
+type Op struct {
+	Code    uint16
+	Name    string // as used by ToString
+	NumArgs int
+	RegDest bool // the first argument must be a register
+}
+
+const (
+	OpHlt  = 0
+	OpSet  = 1
+	OpPush = 2
+	OpPop  = 3
+	OpEq   = 4
+	OpGt   = 5
+	OpJmp  = 6
+	OpJt   = 7
+	OpJf   = 8
+	OpAdd  = 9
+	OpMult = 10
+	OpMod  = 11
+	OpAnd  = 12
+	OpOr   = 13
+	OpNot  = 14
+	OpRmem = 15
+	OpWmem = 16
+	OpCall = 17
+	OpRet  = 18
+	OpOut  = 19
+	OpIn   = 20
+	OpNop  = 21
+)
+
+var (
+	Ops = []Op{
+		Op{OpHlt, "hlt", 0, false},
+		Op{OpSet, "set", 2, true},
+		Op{OpPush, "push", 1, false},
+		Op{OpPop, "pop", 1, true},
+		Op{OpEq, "eq", 3, true},
+		Op{OpGt, "gt", 3, true},
+		Op{OpJmp, "jmp", 1, false},
+		Op{OpJt, "jt", 2, false},
+		Op{OpJf, "jf", 2, false},
+		Op{OpAdd, "add", 3, true},
+		Op{OpMult, "mult", 3, true},
+		Op{OpMod, "mod", 3, true},
+		Op{OpAnd, "and", 3, true},
+		Op{OpOr, "or", 3, true},
+		Op{OpNot, "not", 2, true},
+		Op{OpRmem, "rmem", 2, true},
+		Op{OpWmem, "wmem", 2, false},
+		Op{OpCall, "call", 1, false},
+		Op{OpRet, "ret", 0, false},
+		Op{OpOut, "out", 1, false},
+		Op{OpIn, "in", 1, true},
+		Op{OpNop, "nop", 0, false},
+	}
+
+	// Names used by the architecture spec, where they differ from ours.
+	opAliases = map[string]string{
+		"halt": "hlt",
+		"noop": "nop",
+	}
+)
+
+func LookupOpCode(code uint16) (Op, bool) {
+	if int(code) >= len(Ops) {
+		return Op{}, false
+	}
+	return Ops[code], true
+}
+
+func LookupOpName(name string) (Op, bool) {
+	if alias, found := opAliases[name]; found {
+		name = alias
+	}
+	for _, op := range Ops {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return Op{}, false
+}
+
+// RegArg returns the encoded argument for register num.
+func RegArg(num uint) uint16 {
+	return uint16(num + 32767)
+}
@@ -0,0 +1,167 @@
+// Package journal records the changes made to machine state by each
+// executed instruction, allowing execution to be reversed.
+package journal
+
+import (
+	"instruction"
+)
+
+type kind int
+
+const (
+	kindStep kind = iota // marks the start of a step
+	kindReg
+	kindMem
+	kindPush
+	kindPop
+	kindInput
+)
+
+type change struct {
+	kind     kind
+	loc      uint16 // register number, memory address, or step pc
+	old, val uint16
+}
+
+// Unreader is implemented by I/O devices which can take back consumed
+// input. If the context's device isn't an Unreader, input can't be
+// restored when undoing steps.
+type Unreader interface {
+	Unread(b byte)
+}
+
+// Journal implements instruction.Journal. Changes are grouped into steps,
+// one per executed instruction, with Begin called before each.
+type Journal struct {
+	changes  []change
+	numSteps int
+	maxSteps int
+}
+
+// New returns a journal which remembers at most maxSteps steps. Older steps
+// are discarded.
+func New(maxSteps int) *Journal {
+	return &Journal{
+		maxSteps: maxSteps,
+	}
+}
+
+// Begin starts a new step, for the instruction at pc.
+func (j *Journal) Begin(pc uint16) {
+	if j.numSteps >= j.maxSteps {
+		j.trim()
+	}
+
+	j.changes = append(j.changes, change{kind: kindStep, loc: pc})
+	j.numSteps++
+}
+
+// trim discards the older half of the recorded steps.
+func (j *Journal) trim() {
+	keep := j.numSteps / 2
+	i := len(j.changes)
+	for n := 0; n < keep; {
+		i--
+		if j.changes[i].kind == kindStep {
+			n++
+		}
+	}
+
+	j.changes = append([]change{}, j.changes[i:]...)
+	j.numSteps = keep
+}
+
+func (j *Journal) RegWrite(num uint, old, val uint16) {
+	j.changes = append(j.changes, change{kind: kindReg, loc: uint16(num), old: old, val: val})
+}
+
+func (j *Journal) MemWrite(addr, old, val uint16) {
+	j.changes = append(j.changes, change{kind: kindMem, loc: addr, old: old, val: val})
+}
+
+func (j *Journal) Push(val uint16) {
+	j.changes = append(j.changes, change{kind: kindPush, val: val})
+}
+
+func (j *Journal) Pop(val uint16) {
+	j.changes = append(j.changes, change{kind: kindPop, val: val})
+}
+
+func (j *Journal) InputRead(b byte) {
+	j.changes = append(j.changes, change{kind: kindInput, val: uint16(b)})
+}
+
+// Len returns the number of steps that can be undone.
+func (j *Journal) Len() int {
+	return j.numSteps
+}
+
+// Undo reverses the most recent step, returning the pc of the instruction
+// it executed (which is where execution should resume). It returns false if
+// there are no steps to undo.
+func (j *Journal) Undo(ctx *instruction.Context) (uint16, bool) {
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		c := j.changes[i]
+
+		switch c.kind {
+		case kindStep:
+			j.changes = j.changes[0:i]
+			j.numSteps--
+			return c.loc, true
+		case kindReg:
+			ctx.RegFile.Set(uint(c.loc), c.old)
+		case kindMem:
+			ctx.RAM.Write(c.loc, c.old)
+		case kindPush:
+			ctx.Stack.Pop()
+		case kindPop:
+			ctx.Stack.Push(c.val)
+		case kindInput:
+			if u, ok := ctx.IO.(Unreader); ok {
+				u.Unread(byte(c.val))
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Write describes a journaled write to a register or memory location.
+type Write struct {
+	PC       uint16 // the instruction that made the write
+	StepsAgo int    // the number of steps to undo to reach PC
+	Old, Val uint16
+}
+
+func (j *Journal) lastWrite(k kind, loc uint16) (Write, bool) {
+	stepsAgo := 1
+	var match *change
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		c := &j.changes[i]
+		if c.kind == kindStep {
+			if match != nil {
+				return Write{PC: c.loc, StepsAgo: stepsAgo, Old: match.old, Val: match.val}, true
+			}
+			stepsAgo++
+			continue
+		}
+
+		// An instruction can only write a given location once, so the
+		// first match is the one we want.
+		if c.kind == k && c.loc == loc && match == nil {
+			match = c
+		}
+	}
+
+	return Write{}, false
+}
+
+// LastMemWrite finds the most recent journaled write to addr.
+func (j *Journal) LastMemWrite(addr uint16) (Write, bool) {
+	return j.lastWrite(kindMem, addr)
+}
+
+// LastRegWrite finds the most recent journaled write to register num.
+func (j *Journal) LastRegWrite(num uint) (Write, bool) {
+	return j.lastWrite(kindReg, uint16(num))
+}
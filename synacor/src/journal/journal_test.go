@@ -0,0 +1,109 @@
+package journal
+
+import (
+	"reflect"
+	"testing"
+
+	"device"
+	"instruction"
+	"vm"
+)
+
+// newMachine returns a machine running prog, recording to a journal of
+// maxSteps steps.
+func newMachine(prog []uint16, in string, maxSteps int) (*vm.Machine, *Journal) {
+	m := vm.New(device.NewBuffer(in))
+	m.Load(prog)
+
+	j := New(maxSteps)
+	m.Context().Journal = j
+	m.BeforeExec = func(pc uint16, inst instruction.Inst) {
+		j.Begin(pc)
+	}
+	return m, j
+}
+
+func run(t *testing.T, m *vm.Machine, numSteps int) uint16 {
+	for i := 0; i < numSteps; i++ {
+		if _, err := m.Step(); err != nil {
+			t.Fatalf("Step() at %v = %v", m.PC, err)
+		}
+	}
+	return m.PC
+}
+
+func TestUndo(t *testing.T) {
+	prog := []uint16{
+		1, 32768, 5, // 0: set r1 5
+		2, 32768, // 3: push r1
+		16, 100, 32768, // 5: wmem 100 r1
+		3, 32769, // 8: pop r2
+		20, 32770, // 10: in r3
+		9, 32768, 32768, 1, // 12: add r1, r1, 1
+	}
+
+	m, j := newMachine(prog, "xy\n", 100)
+	ctx := m.Context()
+
+	initialRAM := *ctx.RAM
+	initialRegs := *ctx.RegFile
+
+	if pc := run(t, m, 6); pc != 16 {
+		t.Fatalf("ran to %v, want 16", pc)
+	}
+
+	if w, found := j.LastMemWrite(100); !found || w != (Write{PC: 5, StepsAgo: 4, Old: 0, Val: 5}) {
+		t.Errorf("LastMemWrite(100) = %+v, %v, want {5 4 0 5}, true", w, found)
+	}
+	if w, found := j.LastRegWrite(1); !found || w != (Write{PC: 12, StepsAgo: 1, Old: 5, Val: 6}) {
+		t.Errorf("LastRegWrite(1) = %+v, %v, want {12 1 5 6}, true", w, found)
+	}
+	if w, found := j.LastRegWrite(4); found {
+		t.Errorf("LastRegWrite(4) = %+v, %v, want _, false", w, found)
+	}
+
+	wantPCs := []uint16{12, 10, 8, 5, 3, 0}
+	for _, want := range wantPCs {
+		if pc, ok := j.Undo(ctx); !ok || pc != want {
+			t.Errorf("Undo() = %v, %v, want %v, true", pc, ok, want)
+		}
+	}
+	if _, ok := j.Undo(ctx); ok {
+		t.Errorf("Undo() = _, true, want _, false")
+	}
+
+	if *ctx.RAM != initialRAM {
+		t.Errorf("RAM not restored")
+	}
+	if !reflect.DeepEqual(*ctx.RegFile, initialRegs) {
+		t.Errorf("regs = %+v, want %+v", *ctx.RegFile, initialRegs)
+	}
+	if n := ctx.Stack.Len(); n != 0 {
+		t.Errorf("stack len = %v, want 0", n)
+	}
+	if b, _ := ctx.IO.ReadByte(); b != 'x' {
+		t.Errorf("input not restored; read %q, want 'x'", b)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	prog := []uint16{9, 32768, 32768, 1} // add r1, r1, 1
+	for i := 0; i < 10; i++ {
+		prog = append(prog, prog[0:4]...)
+	}
+
+	m, j := newMachine(prog, "", 4)
+	ctx := m.Context()
+
+	run(t, m, 10)
+	if n := j.Len(); n > 4 {
+		t.Errorf("Len() = %v, want <= 4", n)
+	}
+
+	for j.Len() > 0 {
+		j.Undo(ctx)
+	}
+	if r1 := ctx.RegFile.Get(1); r1 == 0 || r1 >= 10 {
+		t.Errorf("r1 = %v after undoing all retained steps, want partial undo", r1)
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"io/ioutil"
 )
 
-type RAM [32768]uint16
+// Size is the number of words in RAM.
+const Size = 32768
+
+type RAM [Size]uint16
 
 func (r *RAM) Read(addr uint16) uint16 {
 	if (addr & 0x8000) != 0 {
@@ -31,3 +31,20 @@ func (s *Stack) Pop() (uint16, bool) {
 	s.cur--
 	return val, true
 }
+
+func (s *Stack) Len() int {
+	return s.cur + 1
+}
+
+// Values returns a copy of the stack contents, from bottom to top.
+func (s *Stack) Values() []uint16 {
+	return append([]uint16{}, s.cells[0:s.cur+1]...)
+}
+
+func NewStackFromValues(vals []uint16) *Stack {
+	s := NewStack()
+	for _, val := range vals {
+		s.Push(val)
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+// Package patch reads and applies patch files, which describe changes to
+// RAM and registers made when the VM loads a program.
+//
+// Patch files are line-oriented. Blank lines and lines starting with # are
+// ignored.
+//
+//	reg r8=25734         set registers, as for vm --init_reg
+//	@ loc                start a hunk at loc (an address or symbol+offset)
+//	- stmt               assembly expected at the hunk's address
+//	+ stmt               assembly to replace it with
+//
+// The - lines of a hunk are assembled starting at loc, as are the + lines,
+// using the assembler's syntax. A hunk applies only if RAM holds the words
+// of its - lines. The replacement may be shorter than the original, in
+// which case it's padded with nops, but not longer.
+package patch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"assembler"
+	"instruction"
+	"memory"
+	"register"
+	"symtab"
+	"util"
+)
+
+type Hunk struct {
+	LineNum  int
+	Loc      string
+	Addr     uint16
+	Old, New []uint16
+}
+
+type Patch struct {
+	Regs  []string // specs for register.File.ApplySpec
+	Hunks []*Hunk
+}
+
+func assemble(lines []string, addr uint16, st symtab.SymTab) ([]uint16, error) {
+	prog, err := assembler.Assemble(strings.NewReader(strings.Join(lines, "\n")), addr, st)
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Words) > 0 && prog.Origin != addr {
+		return nil, fmt.Errorf("assembled at %d, not %d", prog.Origin, addr)
+	}
+	return prog.Words, nil
+}
+
+type hunkText struct {
+	lineNum  int
+	loc      string
+	old, new []string
+}
+
+func (t *hunkText) build(st symtab.SymTab) (*Hunk, error) {
+	addr, err := util.NameToAddr(t.loc, st)
+	if err != nil {
+		return nil, fmt.Errorf("bad location %v: %v", t.loc, err)
+	}
+
+	h := &Hunk{LineNum: t.lineNum, Loc: t.loc, Addr: addr}
+	if h.Old, err = assemble(t.old, addr, st); err != nil {
+		return nil, fmt.Errorf("original: %v", err)
+	}
+	if h.New, err = assemble(t.new, addr, st); err != nil {
+		return nil, fmt.Errorf("replacement: %v", err)
+	}
+
+	if len(h.Old) == 0 {
+		return nil, fmt.Errorf("no original words")
+	}
+	if len(h.New) > len(h.Old) {
+		return nil, fmt.Errorf("replacement is %d words, original only %d",
+			len(h.New), len(h.Old))
+	}
+	for len(h.New) < len(h.Old) {
+		h.New = append(h.New, instruction.OpNop)
+	}
+	if int(addr)+len(h.Old) > memory.Size {
+		return nil, fmt.Errorf("extends past the end of RAM")
+	}
+
+	return h, nil
+}
+
+// Read parses a patch file. Symbols are resolved using st.
+func Read(r io.Reader, st symtab.SymTab) (*Patch, error) {
+	p := &Patch{}
+
+	var cur *hunkText
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		h, err := cur.build(st)
+		if err != nil {
+			return fmt.Errorf("%d: %v", cur.lineNum, err)
+		}
+		p.Hunks = append(p.Hunks, h)
+		cur = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "reg "):
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			spec := strings.TrimSpace(strings.TrimPrefix(line, "reg "))
+			if err := (&register.File{}).ApplySpec(spec); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineNum, err)
+			}
+			p.Regs = append(p.Regs, spec)
+
+		case strings.HasPrefix(line, "@"):
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			cur = &hunkText{lineNum: lineNum, loc: strings.TrimSpace(line[1:])}
+
+		case strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+"):
+			if cur == nil {
+				return nil, fmt.Errorf("%d: %c outside of hunk", lineNum, line[0])
+			}
+			if line[0] == '-' {
+				cur.old = append(cur.old, line[1:])
+			} else {
+				cur.new = append(cur.new, line[1:])
+			}
+
+		default:
+			return nil, fmt.Errorf("%d: unrecognized line %q", lineNum, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	for i, a := range p.Hunks {
+		for _, b := range p.Hunks[i+1:] {
+			if int(a.Addr) < int(b.Addr)+len(b.Old) && int(b.Addr) < int(a.Addr)+len(a.Old) {
+				return nil, fmt.Errorf("%d: hunk overlaps hunk at line %d", b.LineNum, a.LineNum)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func ReadFromPath(path string, st symtab.SymTab) (*Patch, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return Read(fp, st)
+}
+
+// Check verifies that each hunk's original words are in ram.
+func (p *Patch) Check(ram *memory.RAM) error {
+	for _, h := range p.Hunks {
+		for i, want := range h.Old {
+			addr := h.Addr + uint16(i)
+			if got := ram.Read(addr); got != want {
+				return fmt.Errorf("hunk at %v (line %d): word %d is %d, want %d",
+					h.Loc, h.LineNum, addr, got, want)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply checks the patch against ram, and if it matches, modifies ram and
+// (if non-nil) regFile. Nothing is modified if the check fails.
+func (p *Patch) Apply(ram *memory.RAM, regFile *register.File) error {
+	if err := p.Check(ram); err != nil {
+		return err
+	}
+
+	for _, h := range p.Hunks {
+		for i, val := range h.New {
+			ram.Write(h.Addr+uint16(i), val)
+		}
+	}
+
+	if regFile != nil {
+		for _, spec := range p.Regs {
+			if err := regFile.ApplySpec(spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package patch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"memory"
+	"register"
+	"symtab"
+	"util"
+)
+
+func TestRead(t *testing.T) {
+	st := symtab.New()
+	st.Add("func", 10, 30)
+
+	p, err := Read(strings.NewReader(`
+# comment
+reg r8=5,r1=2
+@ func+2
+- set r1 4
+- call func
++ set r1 6  // shorter
+@ 40
+- .word 1, 2
++ .word 3, 4
+`), st)
+	if err != nil {
+		t.Fatalf("Read = %v, want nil", err)
+	}
+
+	expected := &Patch{
+		Regs: []string{"r8=5,r1=2"},
+		Hunks: []*Hunk{
+			{LineNum: 4, Loc: "func+2", Addr: 12, Old: []uint16{1, 32768, 4, 17, 10}, New: []uint16{1, 32768, 6, 21, 21}},
+			{LineNum: 8, Loc: "40", Addr: 40, Old: []uint16{1, 2}, New: []uint16{3, 4}},
+		},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Read = %+v, want %+v", p, expected)
+	}
+
+	for _, in := range []string{
+		"- nop",                           // outside hunk
+		"@ nosuch\n- nop\n+ nop",          // bad loc
+		"@ 1\n- nop\n+ nop\n+ nop",        // replacement too long
+		"@ 1\n+ nop",                      // no original
+		"@ 1\n- set r1 4\n@ 2\n- nop",     // overlap
+		"@ 1\n- bogus",                    // bad assembly
+		"reg r9=1",                        // bad register
+		"@ 1\n- nop\n+ nop\nwhat is this", // junk
+	} {
+		if _, err := Read(strings.NewReader(in), st); err == nil {
+			t.Errorf("Read(%q) = nil, want error", in)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := Read(strings.NewReader("reg r2=9\n@ 1\n- .word 5, 6\n+ .word 7"), symtab.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ram := &memory.RAM{}
+	ram.Write(1, 5)
+	ram.Write(2, 7)
+	regFile := &register.File{}
+	if err := p.Apply(ram, regFile); err == nil {
+		t.Errorf("Apply to mismatched RAM = nil, want error")
+	}
+	if ram.Read(1) != 5 || regFile.Get(2) != 0 {
+		t.Errorf("failed Apply modified state")
+	}
+
+	ram.Write(2, 6)
+	if err := p.Apply(ram, regFile); err != nil {
+		t.Errorf("Apply = %v, want nil", err)
+	}
+	if ram.Read(1) != 7 || ram.Read(2) != 21 || regFile.Get(2) != 9 {
+		t.Errorf("Apply left %v %v r2=%v, want 7 21 r2=9", ram.Read(1), ram.Read(2), regFile.Get(2))
+	}
+}
+
+// The teleporter patch makes the same changes as the --override_ram and
+// --init_reg flags it replaced.
+func TestTeleporterPatch(t *testing.T) {
+	st, err := symtab.ReadFromPath("../../symtab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadFromPath("../../teleporter.patch", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words, err := util.ReadWordsFromPath("../../challenge.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ram := &memory.RAM{}
+	copy(ram[:], words)
+
+	expectedRAM := *ram
+	overrides := &register.File{}
+	for addr, val := range map[uint16]uint16{521: 21, 522: 21, 523: 21, 5485: 6, 5488: 5, 5489: 21, 5490: 21} {
+		expectedRAM.Write(addr, val)
+	}
+	overrides.Set(8, 25734)
+
+	regFile := &register.File{}
+	if err := p.Apply(ram, regFile); err != nil {
+		t.Fatalf("Apply = %v, want nil", err)
+	}
+	if *ram != expectedRAM || *regFile != *overrides {
+		t.Errorf("patched state doesn't match overrides")
+	}
+}
@@ -0,0 +1,300 @@
+// Package profile counts instruction executions and call costs, producing
+// a report and a coverage file for the disassembler.
+//
+// Costs are measured in executed instructions. Inclusive costs are tracked
+// with a shadow call stack, which is resynchronized on each ret, so code
+// that returns to somewhere other than its call site doesn't confuse it.
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"instruction"
+	"memory"
+	"symtab"
+	"util"
+)
+
+type CallStats struct {
+	Calls     uint64
+	Inclusive uint64
+}
+
+type frame struct {
+	target, ret uint16
+	start       uint64
+}
+
+type Profile struct {
+	Counts [memory.Size]uint64
+	Calls  map[uint16]*CallStats
+	Steps  uint64
+
+	frames []frame
+}
+
+func New() *Profile {
+	return &Profile{Calls: map[uint16]*CallStats{}}
+}
+
+// Record notes the execution of the instruction at pc. op is its opcode,
+// and npc the address executed next.
+func (p *Profile) Record(pc, op, npc uint16, numRead int) {
+	p.Counts[pc]++
+	p.Steps++
+
+	switch op {
+	case instruction.OpCall:
+		stats, found := p.Calls[npc]
+		if !found {
+			stats = &CallStats{}
+			p.Calls[npc] = stats
+		}
+		stats.Calls++
+		p.frames = append(p.frames, frame{target: npc, ret: pc + uint16(numRead), start: p.Steps})
+
+	case instruction.OpRet:
+		// Pop frames until we find the one being returned to. If there
+		// isn't one, the ret didn't match a call we saw, so leave the
+		// stack alone.
+		for i := len(p.frames) - 1; i >= 0; i-- {
+			if p.frames[i].ret == npc {
+				for len(p.frames) > i {
+					p.pop()
+				}
+				break
+			}
+		}
+	}
+}
+
+func (p *Profile) pop() {
+	f := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+	p.Calls[f.target].Inclusive += p.Steps - f.start
+}
+
+// Finish charges still-active calls for the steps executed so far. It
+// should be called once execution stops.
+func (p *Profile) Finish() {
+	for len(p.frames) > 0 {
+		p.pop()
+	}
+}
+
+type FuncStats struct {
+	Name      string
+	Start     uint16
+	Self      uint64
+	Calls     uint64
+	Inclusive uint64
+}
+
+// Funcs aggregates the profile by function. Addresses not covered by st
+// are attributed to the nearest preceding call target, or to "?" if there
+// isn't one.
+func (p *Profile) Funcs(st symtab.SymTab) []*FuncStats {
+	byName := map[string]*FuncStats{}
+	get := func(addr uint16) *FuncStats {
+		name, start := "?", uint16(0)
+		if ent, found := st.LookupAddr(uint(addr)); found {
+			name, start = ent.Name, uint16(ent.Start)
+		} else if tgt, found := p.callTargetBefore(addr); found {
+			name, start = strconv.Itoa(int(tgt)), tgt
+		}
+
+		fs, found := byName[name]
+		if !found {
+			fs = &FuncStats{Name: name, Start: start}
+			byName[name] = fs
+		}
+		return fs
+	}
+
+	for addr, count := range p.Counts {
+		if count > 0 {
+			get(uint16(addr)).Self += count
+		}
+	}
+	for tgt, stats := range p.Calls {
+		fs := get(tgt)
+		if fs.Start != tgt {
+			continue
+		}
+		fs.Calls += stats.Calls
+		fs.Inclusive += stats.Inclusive
+	}
+
+	funcs := []*FuncStats{}
+	for _, fs := range byName {
+		funcs = append(funcs, fs)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].Self != funcs[j].Self {
+			return funcs[i].Self > funcs[j].Self
+		}
+		return funcs[i].Start < funcs[j].Start
+	})
+	return funcs
+}
+
+func (p *Profile) callTargetBefore(addr uint16) (uint16, bool) {
+	best, found := uint16(0), false
+	for tgt := range p.Calls {
+		if tgt <= addr && (!found || tgt > best) {
+			best, found = tgt, true
+		}
+	}
+	return best, found
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// WriteReport writes a summary of the profile, listing functions by self
+// cost and the top addresses by execution count.
+func (p *Profile) WriteReport(w io.Writer, st symtab.SymTab, top int) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "total steps: %d\n\n", p.Steps)
+
+	fmt.Fprintf(bw, "%-30s %12s %6s %10s %12s %6s\n", "function", "self", "self%", "calls", "inclusive", "incl%")
+	for _, fs := range p.Funcs(st) {
+		fmt.Fprintf(bw, "%-30s %12d %6.2f %10d %12d %6.2f\n", fs.Name,
+			fs.Self, percent(fs.Self, p.Steps), fs.Calls,
+			fs.Inclusive, percent(fs.Inclusive, p.Steps))
+	}
+
+	addrs := []uint16{}
+	for addr, count := range p.Counts {
+		if count > 0 {
+			addrs = append(addrs, uint16(addr))
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		ci, cj := p.Counts[addrs[i]], p.Counts[addrs[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return addrs[i] < addrs[j]
+	})
+	if len(addrs) > top {
+		addrs = addrs[:top]
+	}
+
+	fmt.Fprintf(bw, "\n%-30s %12s %6s\n", "address", "count", "%")
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "%-30s %12d %6.2f\n", util.AddrToName(addr, st),
+			p.Counts[addr], percent(p.Counts[addr], p.Steps))
+	}
+
+	return bw.Flush()
+}
+
+func (p *Profile) WriteReportToPath(path string, st symtab.SymTab, top int) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := p.WriteReport(fp, st, top); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// Coverage maps executed addresses to their execution counts.
+type Coverage map[uint16]uint64
+
+func (p *Profile) Coverage() Coverage {
+	cov := Coverage{}
+	for addr, count := range p.Counts {
+		if count > 0 {
+			cov[uint16(addr)] = count
+		}
+	}
+	return cov
+}
+
+// Write writes the coverage map, one "addr count" pair per line, in
+// address order.
+func (c Coverage) Write(w io.Writer) error {
+	addrs := []uint16{}
+	for addr := range c {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	bw := bufio.NewWriter(w)
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "%d %d\n", addr, c[addr])
+	}
+	return bw.Flush()
+}
+
+func (c Coverage) WriteToPath(path string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func ReadCoverage(r io.Reader) (Coverage, error) {
+	cov := Coverage{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%d: parse fail", lineNum)
+		}
+
+		addr, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%d: bad addr: %v", lineNum, err)
+		}
+		count, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%d: bad count: %v", lineNum, err)
+		}
+
+		cov[uint16(addr)] = count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cov, nil
+}
+
+func ReadCoverageFromPath(path string) (Coverage, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return ReadCoverage(fp)
+}
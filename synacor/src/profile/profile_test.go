@@ -0,0 +1,100 @@
+package profile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"instruction"
+	"symtab"
+)
+
+func runProgram() *Profile {
+	p := New()
+	p.Record(0, instruction.OpCall, 10, 2)
+	p.Record(10, instruction.OpNop, 11, 1)
+	p.Record(11, instruction.OpRet, 2, 1)
+	p.Record(2, instruction.OpCall, 10, 2)
+	p.Record(10, instruction.OpNop, 11, 1)
+	p.Record(11, instruction.OpRet, 4, 1)
+	p.Record(4, instruction.OpCall, 20, 2)
+	p.Record(20, instruction.OpHlt, 21, 1)
+	p.Finish()
+	return p
+}
+
+func TestRecord(t *testing.T) {
+	p := runProgram()
+
+	if p.Steps != 8 {
+		t.Errorf("Steps = %v, want 8", p.Steps)
+	}
+	if p.Counts[10] != 2 {
+		t.Errorf("Counts[10] = %v, want 2", p.Counts[10])
+	}
+
+	expected := map[uint16]*CallStats{
+		10: &CallStats{Calls: 2, Inclusive: 4},
+		20: &CallStats{Calls: 1, Inclusive: 1},
+	}
+	if !reflect.DeepEqual(p.Calls, expected) {
+		t.Errorf("Calls = %v, want %v", p.Calls, expected)
+	}
+}
+
+func TestUnmatchedRet(t *testing.T) {
+	p := New()
+	p.Record(0, instruction.OpCall, 10, 2)
+	p.Record(10, instruction.OpCall, 20, 2)
+	p.Record(20, instruction.OpRet, 2, 1) // skips the frame for 20
+
+	if len(p.frames) != 0 {
+		t.Errorf("len(frames) = %v, want 0", len(p.frames))
+	}
+	if got := p.Calls[20].Inclusive; got != 1 {
+		t.Errorf("Calls[20].Inclusive = %v, want 1", got)
+	}
+
+	p.Record(2, instruction.OpRet, 100, 1) // matches nothing
+	if got := p.Calls[10].Inclusive; got != 2 {
+		t.Errorf("Calls[10].Inclusive = %v, want 2", got)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	p := runProgram()
+
+	st := symtab.New()
+	st.Add("main", 0, 9)
+	st.Add("sub", 10, 11)
+
+	expected := []*FuncStats{
+		&FuncStats{Name: "sub", Start: 10, Self: 4, Calls: 2, Inclusive: 4},
+		&FuncStats{Name: "main", Start: 0, Self: 3},
+		&FuncStats{Name: "20", Start: 20, Self: 1, Calls: 1, Inclusive: 1},
+	}
+	if got := p.Funcs(st); !reflect.DeepEqual(got, expected) {
+		for i := range got {
+			t.Logf("Funcs()[%d] = %+v", i, *got[i])
+		}
+		t.Errorf("Funcs() mismatch, want %+v, %+v, %+v", *expected[0], *expected[1], *expected[2])
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	cov := runProgram().Coverage()
+
+	var buf bytes.Buffer
+	if err := cov.Write(&buf); err != nil {
+		t.Fatalf("Write() = %v, want nil", err)
+	}
+
+	if got, want := buf.String(), "0 1\n2 1\n4 1\n10 2\n11 2\n20 1\n"; got != want {
+		t.Errorf("Write() wrote %q, want %q", got, want)
+	}
+
+	read, err := ReadCoverage(&buf)
+	if err != nil || !reflect.DeepEqual(read, cov) {
+		t.Errorf("ReadCoverage() = %v, %v, want %v, nil", read, err, cov)
+	}
+}
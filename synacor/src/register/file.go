@@ -8,8 +8,12 @@ import (
 	"strings"
 )
 
+// Num is the number of registers in a File. Register numbering starts at
+// 1 (r1..r8); r0 is never addressed by instructions.
+const Num = 9
+
 type File struct {
-	reg [9]uint16
+	reg [Num]uint16
 }
 
 func (f *File) Get(num uint) uint16 {
@@ -42,31 +46,39 @@ var (
 
 func InitFromSpec(specs string) (*File, error) {
 	rf := &File{}
+	if err := rf.ApplySpec(specs); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
 
+// ApplySpec sets the registers named in specs (r1=x,r2=y,...), leaving the
+// others unchanged.
+func (f *File) ApplySpec(specs string) error {
 	for _, spec := range strings.Split(specs, ",") {
 		parts := regPattern.FindStringSubmatch(spec)
 		if parts == nil {
-			return nil, fmt.Errorf("failed to parse spec %v", spec)
+			return fmt.Errorf("failed to parse spec %v", spec)
 		}
 
 		regNum, err := strconv.ParseUint(parts[1], 10, 8)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse reg num in spec %v: %v",
+			return fmt.Errorf("failed to parse reg num in spec %v: %v",
 				spec, err)
 		}
 
 		if regNum > 8 {
-			return nil, fmt.Errorf("illegal reg num %v in spec %v", regNum, spec)
+			return fmt.Errorf("illegal reg num %v in spec %v", regNum, spec)
 		}
 
 		val, err := strconv.ParseUint(parts[2], 10, 16)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse reg val in spec %v: %v",
+			return fmt.Errorf("failed to parse reg val in spec %v: %v",
 				spec, err)
 		}
 
-		rf.Set(uint(regNum), uint16(val))
+		f.Set(uint(regNum), uint16(val))
 	}
 
-	return rf, nil
+	return nil
 }
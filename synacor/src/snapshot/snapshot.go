@@ -0,0 +1,174 @@
+// Package snapshot saves and restores complete VM state.
+//
+// Snapshots are stored in a binary format. All numbers are little-endian,
+// matching the challenge binary:
+//
+//	magic     "SYNS"
+//	version   uint16
+//	pc        uint16
+//	registers register.Num uint16s
+//	stack     uint32 count, followed by that many uint16s (bottom first)
+//	input     uint32 count, followed by that many pending input bytes
+//	ram       len(memory.RAM) uint16s
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"memory"
+	"register"
+)
+
+const (
+	magic   = "SYNS"
+	version = 1
+
+	// Upper bounds on the stack and input lengths accepted by Read, so a
+	// corrupt snapshot can't make us allocate gigabytes. Both are far
+	// larger than anything the challenge binary produces.
+	maxStackLen = 1 << 20
+	maxInputLen = 1 << 20
+)
+
+type Snapshot struct {
+	RAM   memory.RAM
+	Regs  [register.Num]uint16
+	Stack []uint16
+	PC    uint16
+	Input []byte
+}
+
+// Capture makes a snapshot that copies the passed state.
+func Capture(ram *memory.RAM, regFile *register.File, stack *memory.Stack, pc uint16, input []byte) *Snapshot {
+	s := &Snapshot{
+		RAM:   *ram,
+		Stack: stack.Values(),
+		PC:    pc,
+		Input: append([]byte{}, input...),
+	}
+	for i := range s.Regs {
+		s.Regs[i] = regFile.Get(uint(i))
+	}
+	return s
+}
+
+// Restore returns new machine state initialized from the snapshot.
+func (s *Snapshot) Restore() (*memory.RAM, *register.File, *memory.Stack) {
+	ram := s.RAM
+	regFile := &register.File{}
+	for i, val := range s.Regs {
+		regFile.Set(uint(i), val)
+	}
+	return &ram, regFile, memory.NewStackFromValues(s.Stack)
+}
+
+func Write(w io.Writer, s *Snapshot) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(magic); err != nil {
+		return err
+	}
+
+	hdr := []interface{}{
+		uint16(version),
+		s.PC,
+		s.Regs,
+		uint32(len(s.Stack)),
+		s.Stack,
+		uint32(len(s.Input)),
+		s.Input,
+		s.RAM,
+	}
+	for _, v := range hdr {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func WriteToPath(path string, s *Snapshot) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := Write(fp, s); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func Read(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil {
+		return nil, fmt.Errorf("failed to read magic: %v", err)
+	}
+	if string(m) != magic {
+		return nil, fmt.Errorf("bad magic %q", m)
+	}
+
+	var ver uint16
+	if err := binary.Read(br, binary.LittleEndian, &ver); err != nil {
+		return nil, fmt.Errorf("failed to read version: %v", err)
+	}
+	if ver != version {
+		return nil, fmt.Errorf("unsupported version %v", ver)
+	}
+
+	s := &Snapshot{}
+	if err := binary.Read(br, binary.LittleEndian, &s.PC); err != nil {
+		return nil, fmt.Errorf("failed to read pc: %v", err)
+	}
+	if err := binary.Read(br, binary.LittleEndian, &s.Regs); err != nil {
+		return nil, fmt.Errorf("failed to read registers: %v", err)
+	}
+
+	var stackLen uint32
+	if err := binary.Read(br, binary.LittleEndian, &stackLen); err != nil {
+		return nil, fmt.Errorf("failed to read stack length: %v", err)
+	}
+	if stackLen > maxStackLen {
+		return nil, fmt.Errorf("stack length %v too large", stackLen)
+	}
+	s.Stack = make([]uint16, stackLen)
+	if err := binary.Read(br, binary.LittleEndian, s.Stack); err != nil {
+		return nil, fmt.Errorf("failed to read stack: %v", err)
+	}
+
+	var inputLen uint32
+	if err := binary.Read(br, binary.LittleEndian, &inputLen); err != nil {
+		return nil, fmt.Errorf("failed to read input length: %v", err)
+	}
+	if inputLen > maxInputLen {
+		return nil, fmt.Errorf("input length %v too large", inputLen)
+	}
+	s.Input = make([]byte, inputLen)
+	if _, err := io.ReadFull(br, s.Input); err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	if err := binary.Read(br, binary.LittleEndian, &s.RAM); err != nil {
+		return nil, fmt.Errorf("failed to read ram: %v", err)
+	}
+
+	return s, nil
+}
+
+func ReadFromPath(path string) (*Snapshot, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return Read(fp)
+}
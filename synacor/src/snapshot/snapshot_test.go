@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"memory"
+	"register"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ram := &memory.RAM{}
+	ram.Write(0, 21)
+	ram.Write(100, 12345)
+	ram.Write(32767, 7)
+
+	regFile, err := register.InitFromSpec("r1=4,r8=25734")
+	if err != nil {
+		t.Fatalf("InitFromSpec failed: %v", err)
+	}
+
+	stack := memory.NewStackFromValues([]uint16{1, 2, 3})
+	stack.Pop()
+
+	in := Capture(ram, regFile, stack, 2734, []byte("ook\n"))
+
+	var buf bytes.Buffer
+	if err := Write(&buf, in); err != nil {
+		t.Fatalf("Write() = %v, want nil", err)
+	}
+
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() = _, %v, want _, nil", err)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Read(Write(%+v)) = %+v", in, out)
+	}
+
+	outRAM, outRegFile, outStack := out.Restore()
+	if *outRAM != *ram {
+		t.Errorf("restored ram mismatch")
+	}
+	if !reflect.DeepEqual(outRegFile, regFile) {
+		t.Errorf("restored regs = %+v, want %+v", outRegFile, regFile)
+	}
+	if vals := outStack.Values(); !reflect.DeepEqual(vals, []uint16{1, 2}) {
+		t.Errorf("restored stack = %v, want [1 2]", vals)
+	}
+}
+
+func TestReadBadMagic(t *testing.T) {
+	if _, err := Read(bytes.NewReader([]byte("XXXX\x01\x00"))); err == nil {
+		t.Errorf("Read(bad magic) = _, nil, want _, non-nil")
+	}
+}
+
+func TestReadBadLengths(t *testing.T) {
+	hdr := func(stackLen, inputLen uint32) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(magic)
+		binary.Write(&buf, binary.LittleEndian, uint16(version))
+		binary.Write(&buf, binary.LittleEndian, uint16(0))
+		binary.Write(&buf, binary.LittleEndian, [register.Num]uint16{})
+		binary.Write(&buf, binary.LittleEndian, stackLen)
+		if stackLen == 0 {
+			binary.Write(&buf, binary.LittleEndian, inputLen)
+		}
+		return buf.Bytes()
+	}
+
+	for _, in := range [][]byte{hdr(0xffffffff, 0), hdr(0, 0xffffffff)} {
+		if _, err := Read(bytes.NewReader(in)); err == nil {
+			t.Errorf("Read(%x) = _, nil, want _, non-nil", in)
+		}
+	}
+}
@@ -1,3 +1,15 @@
+// Package symtab maps names to address ranges.
+//
+// Symbol table files have one symbol per line:
+//
+//	name  start[-end]  [kind]  [# comment]
+//
+// where kind is one of code (the default), data, string, or buffer. Blank
+// lines and lines starting with # are ignored, but are kept so that a table
+// read from a file can be written back with its comments intact.
+//
+// Symbols may nest (a buffer can contain named fields), but may not
+// otherwise overlap, and nested symbols may not share a start address.
 package symtab
 
 import (
@@ -12,9 +24,37 @@ import (
 	"github.com/HuKeping/rbtree"
 )
 
+type Kind int
+
+const (
+	KindCode Kind = iota
+	KindData
+	KindString
+	KindBuffer
+)
+
+var kindNames = []string{"code", "data", "string", "buffer"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind%d", int(k))
+}
+
+func ParseKind(str string) (Kind, error) {
+	for i, name := range kindNames {
+		if name == str {
+			return Kind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown kind %v", str)
+}
+
 type SymEnt struct {
 	Start, End uint
 	Name       string
+	Kind       Kind
 }
 
 func (e *SymEnt) Less(than rbtree.Item) bool {
@@ -28,21 +68,53 @@ func (e *SymEnt) OffStr(addr uint) string {
 	return fmt.Sprintf("%s+%d", e.Name, addr-e.Start)
 }
 
+func (e *SymEnt) rangeStr() string {
+	if e.Start == e.End {
+		return strconv.Itoa(int(e.Start))
+	}
+	return fmt.Sprintf("%d-%d", e.Start, e.End)
+}
+
+// String returns the entry in symbol table file format.
+func (e SymEnt) String() string {
+	str := fmt.Sprintf("%s\t\t%s", e.Name, e.rangeStr())
+	if e.Kind != KindCode {
+		str += "\t" + e.Kind.String()
+	}
+	return str
+}
+
 type SymTab interface {
+	// Add adds a code symbol.
 	Add(name string, start, end uint) error
+	AddEntry(ent SymEnt) error
+
 	LookupAddr(addr uint) (SymEnt, bool)
 	LookupName(name string) (SymEnt, bool)
+
+	// Entries returns the symbols in address order.
+	Entries() []SymEnt
 }
 
 type NoEntriesSymTab struct{}
 
 func (s *NoEntriesSymTab) Add(name string, start, end uint) error { return fmt.Errorf("unsupported") }
+func (s *NoEntriesSymTab) AddEntry(ent SymEnt) error              { return fmt.Errorf("unsupported") }
 func (s *NoEntriesSymTab) LookupAddr(addr uint) (SymEnt, bool)    { return SymEnt{}, false }
 func (s *NoEntriesSymTab) LookupName(name string) (SymEnt, bool)  { return SymEnt{}, false }
+func (s *NoEntriesSymTab) Entries() []SymEnt                      { return nil }
+
+// textLine is a line of the table's file form. Lines with entries are
+// printed verbatim if they were read from a file, and formatted otherwise.
+type textLine struct {
+	raw string
+	ent *SymEnt
+}
 
 type symTabImpl struct {
 	tree   *rbtree.Rbtree
 	byName map[string]*SymEnt
+	text   []textLine
 }
 
 func New() SymTab {
@@ -53,16 +125,17 @@ func New() SymTab {
 }
 
 var (
-	symtabPattern = regexp.MustCompile(`^(\w+)\s+([0-9]+)(?:-([0-9]+))?(?:\s*#.*)?$`)
+	symtabPattern = regexp.MustCompile(`^(\w+)\s+([0-9]+)(?:-([0-9]+))?(?:\s+(\w+))?(?:\s*#.*)?$`)
 )
 
 func Read(r io.Reader) (SymTab, error) {
-	st := New()
+	st := New().(*symTabImpl)
 
 	scanner := bufio.NewScanner(r)
 	for lineNum := 1; scanner.Scan(); lineNum++ {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
+			st.text = append(st.text, textLine{raw: scanner.Text()})
 			continue
 		}
 
@@ -87,7 +160,18 @@ func Read(r io.Reader) (SymTab, error) {
 			return nil, fmt.Errorf("%d: parse end fail: %v", lineNum, err)
 		}
 
-		st.Add(name, uint(start), uint(end))
+		kind := KindCode
+		if parts[4] != "" {
+			if kind, err = ParseKind(parts[4]); err != nil {
+				return nil, fmt.Errorf("%d: %v", lineNum, err)
+			}
+		}
+
+		ent := SymEnt{Name: name, Start: uint(start), End: uint(end), Kind: kind}
+		if err := st.AddEntry(ent); err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+		st.text[len(st.text)-1].raw = scanner.Text()
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
@@ -106,18 +190,97 @@ func ReadFromPath(path string) (SymTab, error) {
 	return Read(fp)
 }
 
+// Write writes st in file format. Tables read with Read are written back
+// with their comments and formatting, followed by any symbols added since.
+func Write(w io.Writer, st SymTab) error {
+	impl, ok := st.(*symTabImpl)
+	if !ok {
+		for _, ent := range st.Entries() {
+			if _, err := fmt.Fprintln(w, ent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, line := range impl.text {
+		str := line.raw
+		if line.ent != nil && str == "" {
+			str = line.ent.String()
+		}
+		if _, err := fmt.Fprintln(w, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteToPath(path string, st SymTab) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := Write(fp, st); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
 func (st *symTabImpl) Add(name string, start, end uint) error {
-	if _, found := st.byName[name]; found {
-		return fmt.Errorf("%v already exists in table", name)
+	return st.AddEntry(SymEnt{Name: name, Start: start, End: end})
+}
+
+// checkOverlap returns an error if e partially overlaps an existing
+// symbol, or shares its start address.
+func (st *symTabImpl) checkOverlap(e *SymEnt) error {
+	var err error
+	st.tree.Ascend(&SymEnt{}, func(i rbtree.Item) bool {
+		o := i.(*SymEnt)
+		if o.Start > e.End {
+			return false
+		}
+		if o.End < e.Start {
+			return true
+		}
+
+		switch {
+		case o.Start == e.Start:
+			err = fmt.Errorf("%v (%s) starts at the same address as %v (%s)",
+				e.Name, e.rangeStr(), o.Name, o.rangeStr())
+		case o.Start < e.Start && o.End >= e.End, e.Start < o.Start && e.End >= o.End:
+			return true // nested
+		default:
+			err = fmt.Errorf("%v (%s) overlaps %v (%s)",
+				e.Name, e.rangeStr(), o.Name, o.rangeStr())
+		}
+		return err == nil
+	})
+	return err
+}
+
+func (st *symTabImpl) AddEntry(ent SymEnt) error {
+	if _, found := st.byName[ent.Name]; found {
+		return fmt.Errorf("%v already exists in table", ent.Name)
+	}
+	if ent.End < ent.Start {
+		return fmt.Errorf("%v ends (%d) before it starts (%d)", ent.Name, ent.End, ent.Start)
+	}
+
+	e := &ent
+	if err := st.checkOverlap(e); err != nil {
+		return err
 	}
 
-	e := &SymEnt{start, end, name}
-	st.byName[name] = e
+	st.byName[e.Name] = e
 	st.tree.Insert(e)
+	st.text = append(st.text, textLine{ent: e})
 
 	return nil
 }
 
+// LookupAddr returns the innermost symbol containing addr.
 func (st *symTabImpl) LookupAddr(addr uint) (SymEnt, bool) {
 	pivot := &SymEnt{Start: addr}
 
@@ -126,8 +289,9 @@ func (st *symTabImpl) LookupAddr(addr uint) (SymEnt, bool) {
 		e := i.(*SymEnt)
 		if addr <= e.End {
 			match = e
+			return false
 		}
-		return false
+		return true
 	})
 
 	if match == nil {
@@ -144,3 +308,12 @@ func (st *symTabImpl) LookupName(name string) (SymEnt, bool) {
 
 	return *ent, true
 }
+
+func (st *symTabImpl) Entries() []SymEnt {
+	ents := []SymEnt{}
+	st.tree.Ascend(&SymEnt{}, func(i rbtree.Item) bool {
+		ents = append(ents, *i.(*SymEnt))
+		return true
+	})
+	return ents
+}
@@ -10,7 +10,9 @@ func TestRead(t *testing.T) {
 	in := `
 		# comment
 		a 4
-		b 8-10  # another comment`
+		b 8-10  # another comment
+		c 12-20 buffer
+		d 14 data`
 
 	st, err := Read(strings.NewReader(in))
 	if err != nil {
@@ -25,6 +27,8 @@ func TestRead(t *testing.T) {
 	}{
 		{"a", true, SymEnt{Name: "a", Start: 4, End: 4}},
 		{"b", true, SymEnt{Name: "b", Start: 8, End: 10}},
+		{"c", true, SymEnt{Name: "c", Start: 12, End: 20, Kind: KindBuffer}},
+		{"d", true, SymEnt{Name: "d", Start: 14, End: 14, Kind: KindData}},
 	}
 
 	for _, test := range tests {
@@ -46,12 +50,107 @@ func TestAdd(t *testing.T) {
 		t.Errorf(`st.Add("A", 9, 18) = %v, want non-nil`, err)
 		return
 	}
+
+	if err := st.AddEntry(SymEnt{Name: "buf", Start: 10, End: 30, Kind: KindBuffer}); err != nil {
+		t.Errorf(`st.AddEntry(buf) = %v, want nil`, err)
+	}
+	if err := st.Add("field", 12, 14); err != nil {
+		t.Errorf(`st.Add("field", 12, 14) = %v, want nil`, err)
+	}
+
+	bad := []SymEnt{
+		{Name: "B", Start: 4, End: 6},   // crosses A
+		{Name: "C", Start: 2, End: 3},   // starts with A
+		{Name: "D", Start: 13, End: 20}, // crosses field
+		{Name: "E", Start: 25, End: 40}, // crosses buf
+		{Name: "F", Start: 0, End: 12},  // crosses A and buf
+		{Name: "G", Start: 9, End: 8},
+	}
+	for _, ent := range bad {
+		if err := st.AddEntry(ent); err == nil {
+			t.Errorf("st.AddEntry(%+v) = nil, want non-nil", ent)
+		}
+	}
+
+	// Enclosing everything is fine.
+	if err := st.Add("all", 0, 100); err != nil {
+		t.Errorf(`st.Add("all", 0, 100) = %v, want nil`, err)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	st := New()
+	st.Add("b", 8, 10)
+	st.AddEntry(SymEnt{Name: "c", Start: 9, End: 9, Kind: KindString})
+	st.Add("a", 2, 5)
+
+	expected := []SymEnt{
+		{Name: "a", Start: 2, End: 5},
+		{Name: "b", Start: 8, End: 10},
+		{Name: "c", Start: 9, End: 9, Kind: KindString},
+	}
+	if ents := st.Entries(); !reflect.DeepEqual(ents, expected) {
+		t.Errorf("Entries() = %v, want %v", ents, expected)
+	}
+
+	if ents := (&NoEntriesSymTab{}).Entries(); len(ents) != 0 {
+		t.Errorf("NoEntriesSymTab.Entries() = %v, want none", ents)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	in := "# Code\n\nfoo\t\t2-5  # comment\n\n# Data\nbar 9 string\n"
+
+	st, err := Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Read() = %v, want nil", err)
+	}
+	if err := st.AddEntry(SymEnt{Name: "baz", Start: 12, End: 20, Kind: KindBuffer}); err != nil {
+		t.Fatal(err)
+	}
+	st.Add("qux", 30, 30)
+
+	var out strings.Builder
+	if err := Write(&out, st); err != nil {
+		t.Fatalf("Write() = %v, want nil", err)
+	}
+	want := in + "baz\t\t12-20\tbuffer\nqux\t\t30\n"
+	if out.String() != want {
+		t.Errorf("Write() wrote %q, want %q", out.String(), want)
+	}
+
+	// Rereading gets the same entries.
+	reread, err := Read(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Read(Write()) = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(reread.Entries(), st.Entries()) {
+		t.Errorf("Read(Write()) = %v, want %v", reread.Entries(), st.Entries())
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, in := range []string{
+		"a 4 nosuchkind",
+		"a 4-",
+		"a 4\na 6",
+		"a 4-8\nb 6-10",
+	} {
+		if _, err := Read(strings.NewReader(in)); err == nil {
+			t.Errorf("Read(%q) = nil, want error", in)
+		}
+	}
 }
 
 func TestLookupAddr(t *testing.T) {
 	st := New()
 	st.Add("a", 2, 5)
 	st.Add("b", 8, 10)
+	st.AddEntry(SymEnt{Name: "buf", Start: 20, End: 30, Kind: KindBuffer})
+	st.AddEntry(SymEnt{Name: "field", Start: 22, End: 23, Kind: KindData})
+
+	buf := SymEnt{Name: "buf", Start: 20, End: 30, Kind: KindBuffer}
+	field := SymEnt{Name: "field", Start: 22, End: 23, Kind: KindData}
 
 	tests := []struct {
 		addr  uint
@@ -68,6 +167,11 @@ func TestLookupAddr(t *testing.T) {
 		{addr: 9, found: true, ent: SymEnt{Name: "b", Start: 8, End: 10}},
 		{addr: 10, found: true, ent: SymEnt{Name: "b", Start: 8, End: 10}},
 		{addr: 11, found: false},
+		{addr: 21, found: true, ent: buf},
+		{addr: 22, found: true, ent: field},
+		{addr: 23, found: true, ent: field},
+		{addr: 24, found: true, ent: buf},
+		{addr: 31, found: false},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,153 @@
+// Package teleporter evaluates the confirmation routine the teleporter
+// runs (verify_r8 at 6027) and searches for the r8 value it accepts.
+//
+// The routine computes an Ackermann-like function of r1 and r2, with all
+// arithmetic mod 32768:
+//
+//	f(0, b) = b+1
+//	f(a, 0) = f(a-1, r8)
+//	f(a, b) = f(a-1, f(a, b-1))
+//
+// The game calls it with r1=4, r2=1, and wants 6 back. Run as written it
+// takes far too long, so here f is tabulated bottom-up: row a depends only
+// on row a-1, and there are only 32768 possible values of b.
+package teleporter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+const Modulus = 32768
+
+// Evaluator computes f. Its tables are reused between calls, so an
+// Evaluator must not be used concurrently.
+type Evaluator struct {
+	rows [][]uint16
+}
+
+func NewEvaluator() *Evaluator {
+	return &Evaluator{}
+}
+
+func (e *Evaluator) row(a int) []uint16 {
+	for len(e.rows) <= a {
+		e.rows = append(e.rows, make([]uint16, Modulus))
+	}
+	return e.rows[a]
+}
+
+// Eval returns f(a, b) for the given r8.
+func (e *Evaluator) Eval(a, b, r8 uint16) uint16 {
+	b %= Modulus
+	if a == 0 {
+		return (b + 1) % Modulus
+	}
+
+	// prev is row a-1, where row 0 is implicit.
+	prev := func(v uint16) uint16 { return (v + 1) % Modulus }
+	for level := 1; level <= int(a); level++ {
+		cur := e.row(level)
+
+		// Row a is only needed up to b; the rows below are needed in
+		// full.
+		last := Modulus - 1
+		if level == int(a) {
+			last = int(b)
+		}
+
+		cur[0] = prev(r8)
+		for i := 1; i <= last; i++ {
+			cur[i] = prev(cur[i-1])
+		}
+
+		prev = func(v uint16) uint16 { return cur[v] }
+	}
+
+	return e.rows[a][b]
+}
+
+type SearchOptions struct {
+	A, B uint16 // arguments to f
+	Want uint16 // the result being searched for
+
+	// The range of r8 values to try, inclusive. If both are zero, all
+	// non-zero values are tried.
+	First, Last uint16
+
+	// Defaults to the number of CPUs.
+	Workers int
+}
+
+// Search returns the lowest r8 in range for which f(A, B) == Want, or
+// false if there isn't one. Candidates are handed to workers in increasing
+// order, and the search stops as soon as a match has been found and every
+// lower candidate checked, or when ctx is done.
+func Search(ctx context.Context, opts SearchOptions) (uint16, bool, error) {
+	first, last := int(opts.First), int(opts.Last)
+	if first == 0 && last == 0 {
+		first, last = 1, Modulus-1
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		next  = first
+		found = -1
+	)
+
+	// take returns the next candidate, or false if there's nothing left
+	// worth trying.
+	take := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if next > last || found >= 0 || ctx.Err() != nil {
+			return 0, false
+		}
+		next++
+		return next - 1, true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			e := NewEvaluator()
+			for {
+				r8, ok := take()
+				if !ok {
+					return
+				}
+
+				if e.Eval(opts.A, opts.B, uint16(r8)) != opts.Want {
+					continue
+				}
+
+				mu.Lock()
+				if found < 0 || r8 < found {
+					found = r8
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Candidates below a match have all been taken before it, and taken
+	// candidates are always finished, so found is the lowest match.
+	if found >= 0 {
+		return uint16(found), true, nil
+	}
+	return 0, false, ctx.Err()
+}
@@ -0,0 +1,78 @@
+package teleporter
+
+import (
+	"context"
+	"testing"
+)
+
+// naive evaluates f by direct, memoized recursion.
+func naive(a, b, r8 uint16, memo map[[2]uint16]uint16) uint16 {
+	key := [2]uint16{a, b}
+	if v, found := memo[key]; found {
+		return v
+	}
+
+	var v uint16
+	switch {
+	case a == 0:
+		v = (b + 1) % Modulus
+	case b == 0:
+		v = naive(a-1, r8, r8, memo)
+	default:
+		v = naive(a-1, naive(a, b-1, r8, memo), r8, memo)
+	}
+	memo[key] = v
+	return v
+}
+
+func TestEval(t *testing.T) {
+	e := NewEvaluator()
+	for _, r8 := range []uint16{1, 2, 7, 100} {
+		for a := uint16(0); a <= 2; a++ {
+			for _, b := range []uint16{0, 1, 5, 300, 32767} {
+				want := naive(a, b, r8, map[[2]uint16]uint16{})
+				if got := e.Eval(a, b, r8); got != want {
+					t.Errorf("Eval(%v, %v, %v) = %v, want %v", a, b, r8, got, want)
+				}
+			}
+		}
+	}
+
+	if got := e.Eval(4, 1, 25734); got != 6 {
+		t.Errorf("Eval(4, 1, 25734) = %v, want 6", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		first, last uint16
+		workers     int
+		expected    uint16
+		found       bool
+	}{
+		{25700, 25800, 4, 25734, true},
+		{25734, 25734, 1, 25734, true},
+		{25735, 25760, 3, 0, false},
+	}
+
+	for _, test := range tests {
+		opts := SearchOptions{A: 4, B: 1, Want: 6, First: test.first, Last: test.last, Workers: test.workers}
+		r8, found, err := Search(context.Background(), opts)
+		if r8 != test.expected || found != test.found || err != nil {
+			t.Errorf("Search(%v-%v) = %v, %v, %v, want %v, %v, nil",
+				test.first, test.last, r8, found, err, test.expected, test.found)
+		}
+	}
+
+	// The answer is 6 for many r8 with smaller a.
+	opts := SearchOptions{A: 1, B: 1, Want: 6, First: 1, Last: 1000, Workers: 8}
+	if r8, found, err := Search(context.Background(), opts); r8 != 4 || !found || err != nil {
+		t.Errorf("Search(a=1) = %v, %v, %v, want 4, true, nil", r8, found, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, found, err := Search(ctx, SearchOptions{A: 4, B: 1, Want: 6}); found || err != context.Canceled {
+		t.Errorf("Search(cancelled) = _, %v, %v, want false, %v", found, err, context.Canceled)
+	}
+}
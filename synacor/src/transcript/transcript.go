@@ -0,0 +1,260 @@
+// Package transcript records and replays VM input and output.
+//
+// A transcript is a text file with one entry per line:
+//
+//	<RFC3339 timestamp> <type> <quoted text>
+//
+// where type is "in" for a line of input, "out" for output (one entry per
+// output line), or "cmd" for a script directive. Text is quoted with Go
+// quoting rules, so newlines and control characters survive intact.
+package transcript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"input"
+)
+
+type Type int
+
+const (
+	In Type = iota
+	Out
+	Cmd
+)
+
+func (t Type) String() string {
+	switch t {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	case Cmd:
+		return "cmd"
+	default:
+		panic("unknown")
+	}
+}
+
+func parseType(str string) (Type, error) {
+	switch str {
+	case "in":
+		return In, nil
+	case "out":
+		return Out, nil
+	case "cmd":
+		return Cmd, nil
+	default:
+		return 0, fmt.Errorf("unknown type %v", str)
+	}
+}
+
+type Entry struct {
+	Time time.Time
+	Type Type
+	Text string
+}
+
+// Writer records a transcript. It implements input.Recorder for input
+// and directives, and io.Writer for VM output. Output is buffered until a
+// newline is written or until input is read, so each out entry holds at
+// most one line.
+type Writer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	out []byte
+	err error
+	now func() time.Time
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   bufio.NewWriter(w),
+		now: time.Now,
+	}
+}
+
+func (tw *Writer) write(typ Type, text string) {
+	if tw.err != nil {
+		return
+	}
+	_, tw.err = fmt.Fprintf(tw.w, "%s %s %s\n",
+		tw.now().UTC().Format(time.RFC3339Nano), typ, strconv.Quote(text))
+}
+
+func (tw *Writer) flushOut() {
+	if len(tw.out) > 0 {
+		tw.write(Out, string(tw.out))
+		tw.out = tw.out[:0]
+	}
+}
+
+func (tw *Writer) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	for _, b := range p {
+		tw.out = append(tw.out, b)
+		if b == '\n' {
+			tw.flushOut()
+		}
+	}
+	return len(p), tw.err
+}
+
+func (tw *Writer) Input(line string) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.flushOut()
+	tw.write(In, line)
+}
+
+func (tw *Writer) Directive(d input.Directive) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.flushOut()
+	tw.write(Cmd, d.String())
+}
+
+// Flush writes any buffered output and returns the first error
+// encountered while writing the transcript.
+func (tw *Writer) Flush() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.flushOut()
+	if tw.err != nil {
+		return tw.err
+	}
+	return tw.w.Flush()
+}
+
+func Read(r io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%d: parse fail", lineNum)
+		}
+
+		ts, err := time.Parse(time.RFC3339Nano, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%d: bad time: %v", lineNum, err)
+		}
+		typ, err := parseType(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+		text, err := strconv.Unquote(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("%d: bad text: %v", lineNum, err)
+		}
+
+		entries = append(entries, Entry{Time: ts, Type: typ, Text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func ReadFromPath(path string) ([]Entry, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return Read(fp)
+}
+
+// Script returns a script which replays the input recorded in entries.
+// Only directives accepted by keep are included.
+func Script(entries []Entry, keep func(d input.Directive) bool) (*input.Script, error) {
+	script := &input.Script{}
+	for _, ent := range entries {
+		switch ent.Type {
+		case In:
+			script.Entries = append(script.Entries,
+				input.ScriptEntry{Line: strings.TrimSuffix(ent.Text, "\n")})
+		case Cmd:
+			d, err := input.ParseDirective(ent.Text)
+			if err != nil {
+				return nil, err
+			}
+			if keep(*d) {
+				script.Entries = append(script.Entries, input.ScriptEntry{Directive: d})
+			}
+		}
+	}
+	return script, nil
+}
+
+// Output returns the concatenated output recorded in entries.
+func Output(entries []Entry) string {
+	var sb strings.Builder
+	for _, ent := range entries {
+		if ent.Type == Out {
+			sb.WriteString(ent.Text)
+		}
+	}
+	return sb.String()
+}
+
+// Checker is an io.Writer that compares what's written to it against
+// expected output, passing the written output through to another writer.
+type Checker struct {
+	w        io.Writer
+	expected string
+	off      int
+	diverged bool
+}
+
+func NewChecker(expected string, w io.Writer) *Checker {
+	return &Checker{
+		w:        w,
+		expected: expected,
+	}
+}
+
+func (c *Checker) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if c.diverged {
+			break
+		}
+		if c.off >= len(c.expected) || c.expected[c.off] != b {
+			c.diverged = true
+			break
+		}
+		c.off++
+	}
+
+	return c.w.Write(p)
+}
+
+// Result returns the offset of the first mismatched output byte, or -1
+// if all output written so far matched. Output that stops short of the
+// expected output is not a mismatch.
+func (c *Checker) Result() int {
+	if c.diverged {
+		return c.off
+	}
+	return -1
+}
@@ -0,0 +1,90 @@
+package transcript
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"input"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+
+	start := time.Date(2018, 9, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	tw.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	tw.Write([]byte("== Foot"))
+	tw.Write([]byte("hills ==\nWhat do you do?"))
+	tw.Input("take tablet\n")
+	tw.Directive(input.Directive{Name: "set", Args: []string{"r8=1"}})
+	tw.Write([]byte("\nTaken.\n"))
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("Flush() = %v, want nil", err)
+	}
+
+	entries, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() = _, %v, want _, nil", err)
+	}
+
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	expected := []Entry{
+		Entry{at(1), Out, "== Foothills ==\n"},
+		Entry{at(2), Out, "What do you do?"},
+		Entry{at(3), In, "take tablet\n"},
+		Entry{at(4), Cmd, "set r8=1"},
+		Entry{at(5), Out, "\n"},
+		Entry{at(6), Out, "Taken.\n"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Read() = %+v, want %+v", entries, expected)
+	}
+
+	script, err := Script(entries, func(d input.Directive) bool { return true })
+	if err != nil {
+		t.Fatalf("Script() = _, %v, want _, nil", err)
+	}
+	expectedScript := &input.Script{
+		Entries: []input.ScriptEntry{
+			input.ScriptEntry{Line: "take tablet"},
+			input.ScriptEntry{Directive: &input.Directive{Name: "set", Args: []string{"r8=1"}}},
+		},
+	}
+	if !reflect.DeepEqual(script, expectedScript) {
+		t.Errorf("Script() = %+v, want %+v", script, expectedScript)
+	}
+
+	if out, want := Output(entries), "== Foothills ==\nWhat do you do?\nTaken.\n"; out != want {
+		t.Errorf("Output() = %q, want %q", out, want)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	tests := []struct {
+		written []string
+		result  int
+	}{
+		{[]string{"abc", "def"}, -1},
+		{[]string{"ab"}, -1},
+		{[]string{"abc", "dxf"}, 4},
+		{[]string{"abcdefg"}, 6},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		c := NewChecker("abcdef", &buf)
+		for _, w := range test.written {
+			c.Write([]byte(w))
+		}
+		if res := c.Result(); res != test.result {
+			t.Errorf("write %v: Result() = %v, want %v", test.written, res, test.result)
+		}
+	}
+}
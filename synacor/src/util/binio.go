@@ -3,6 +3,7 @@ package util
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 )
 
@@ -53,3 +54,28 @@ func (bio *BinIO) Close() {
 		panic(fmt.Sprintf("close fail: %v", err))
 	}
 }
+
+// ReadWordsFromPath reads an entire binary file.
+func ReadWordsFromPath(path string) ([]uint16, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]uint16, len(b)/2)
+	for i := range vals {
+		vals[i] = uint16(b[i*2]) | (uint16(b[i*2+1]) << 8)
+	}
+	return vals, nil
+}
+
+// WriteWordsToPath writes vals in binary format.
+func WriteWordsToPath(path string, vals []uint16) error {
+	b := make([]byte, len(vals)*2)
+	for i, v := range vals {
+		b[i*2] = byte(v & 0xff)
+		b[i*2+1] = byte((v >> 8) & 0xff)
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
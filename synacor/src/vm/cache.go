@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"instruction"
+	"memory"
+)
+
+// maxInstLen is the length in words of the longest instruction: an opcode
+// and three operands.
+const maxInstLen = 4
+
+type cacheEntry struct {
+	inst instruction.Inst
+	len  uint16
+	gen  uint32
+}
+
+// cache holds decoded instructions by address, so code that runs often
+// isn't decoded again on every step. Entries are valid only if they're from
+// the current generation, which lets flush drop them all at once.
+type cache struct {
+	gen     uint32
+	entries [memory.Size]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{gen: 1}
+}
+
+func (c *cache) get(addr uint16) (instruction.Inst, int, bool) {
+	if int(addr) >= len(c.entries) {
+		return nil, 0, false
+	}
+	e := &c.entries[addr]
+	if e.gen != c.gen {
+		return nil, 0, false
+	}
+	return e.inst, int(e.len), true
+}
+
+func (c *cache) put(addr uint16, inst instruction.Inst, numRead int) {
+	if int(addr) >= len(c.entries) {
+		return
+	}
+	c.entries[addr] = cacheEntry{inst: inst, len: uint16(numRead), gen: c.gen}
+}
+
+// invalidate drops any instruction that includes the word at addr.
+func (c *cache) invalidate(addr uint16) {
+	start := 0
+	if int(addr) >= maxInstLen {
+		start = int(addr) - maxInstLen + 1
+	}
+	for a := start; a <= int(addr) && a < len(c.entries); a++ {
+		if e := &c.entries[a]; e.gen == c.gen && a+int(e.len) > int(addr) {
+			e.gen = 0
+		}
+	}
+}
+
+func (c *cache) flush() {
+	c.gen++
+	if c.gen == 0 {
+		// Wrapped around, so old entries could look current again.
+		c.entries = [memory.Size]cacheEntry{}
+		c.gen = 1
+	}
+}
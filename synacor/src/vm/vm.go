@@ -0,0 +1,254 @@
+// Package vm runs the challenge's virtual machine without a terminal, so it
+// can be driven by commands, tools, and tests.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"instruction"
+	"memory"
+	"register"
+	"snapshot"
+	"util"
+)
+
+var (
+	// ErrHalted is returned by Step and Run once the machine has halted.
+	ErrHalted = errors.New("machine halted")
+
+	ErrImageTooLarge = errors.New("image larger than RAM")
+)
+
+// Step describes an executed instruction.
+type Step struct {
+	PC   uint16
+	Op   uint16
+	Inst instruction.Inst
+	Len  int // in words
+	CB   instruction.CB
+}
+
+// Machine is the VM state: memory, registers, stack, and pc.
+type Machine struct {
+	PC uint16
+
+	// BeforeExec, if set, is called with each instruction before it's
+	// executed. An in that's waiting for input is only reported once,
+	// however many times it's retried.
+	BeforeExec func(pc uint16, inst instruction.Inst)
+
+	// AfterStep, if set, is called with each completed Step, after the
+	// machine state has been updated. It isn't called for an in that's
+	// waiting for input.
+	AfterStep func(s *Step)
+
+	// NoCache disables the decoded instruction cache, so every instruction
+	// is decoded from RAM as it's executed.
+	NoCache bool
+
+	ctx    *instruction.Context
+	cache  *cache
+	halted bool
+	err    error
+
+	// waiting is set when the instruction at waitPC returned ErrNoInput,
+	// and so has already been passed to BeforeExec.
+	waiting bool
+	waitPC  uint16
+}
+
+// New returns a machine with zeroed RAM, doing I/O with dev.
+func New(dev instruction.Device) *Machine {
+	return &Machine{
+		ctx: &instruction.Context{
+			RAM:     &memory.RAM{},
+			RegFile: &register.File{},
+			Stack:   memory.NewStack(),
+			IO:      dev,
+		},
+		cache: newCache(),
+	}
+}
+
+// Context returns the context used to execute instructions, which may be
+// modified to add a journal, a watcher, or verbose output.
+func (m *Machine) Context() *instruction.Context { return m.ctx }
+
+// RAM returns the machine's memory. Writes made to it other than by wmem
+// aren't seen by the instruction cache, so must be followed by FlushCache.
+func (m *Machine) RAM() *memory.RAM { return m.ctx.RAM }
+
+func (m *Machine) RegFile() *register.File          { return m.ctx.RegFile }
+func (m *Machine) Stack() *memory.Stack             { return m.ctx.Stack }
+func (m *Machine) SetDevice(dev instruction.Device) { m.ctx.IO = dev }
+
+// Load copies image to the start of RAM.
+func (m *Machine) Load(image []uint16) error {
+	if len(image) > memory.Size {
+		return ErrImageTooLarge
+	}
+	copy(m.ctx.RAM[:], image)
+	m.FlushCache()
+	return nil
+}
+
+// LoadFromPath loads a binary image file, as with Load.
+func (m *Machine) LoadFromPath(path string) error {
+	image, err := util.ReadWordsFromPath(path)
+	if err != nil {
+		return err
+	}
+	return m.Load(image)
+}
+
+// ApplyRAMOverrides sets RAM values from a spec of the form
+// addr=val,addr=val,...
+func (m *Machine) ApplyRAMOverrides(spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("bad pair %v", pair)
+		}
+
+		addr, err := strconv.ParseUint(parts[0], 10, 15)
+		if err != nil {
+			return fmt.Errorf("bad addr %v in pair %v", parts[0], pair)
+		}
+
+		val, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return fmt.Errorf("bad val %v in pair %v", parts[1], pair)
+		}
+
+		m.ctx.RAM.Write(uint16(addr), uint16(val))
+		m.cache.invalidate(uint16(addr))
+	}
+
+	return nil
+}
+
+// Restore replaces the machine state with snap. The snapshot's pending
+// input is left to the caller.
+func (m *Machine) Restore(snap *snapshot.Snapshot) {
+	m.ctx.RAM, m.ctx.RegFile, m.ctx.Stack = snap.Restore()
+	m.PC = snap.PC
+	m.halted, m.err = false, nil
+	m.waiting = false
+	m.FlushCache()
+}
+
+// FlushCache drops all decoded instructions, for use after RAM has been
+// changed directly.
+func (m *Machine) FlushCache() { m.cache.flush() }
+
+// Snapshot captures the machine state, along with pending input.
+func (m *Machine) Snapshot(pending []byte) *snapshot.Snapshot {
+	return snapshot.Capture(m.ctx.RAM, m.ctx.RegFile, m.ctx.Stack, m.PC, pending)
+}
+
+// Halted returns true if the machine has stopped, either by executing hlt
+// or by failing.
+func (m *Machine) Halted() bool { return m.halted }
+
+// Err returns the reason the machine halted, or nil if it halted normally
+// or hasn't.
+func (m *Machine) Err() error { return m.err }
+
+func (m *Machine) halt(err error) {
+	m.halted, m.err = true, err
+}
+
+// Step executes the instruction at PC. It returns the instruction's error
+// if it halted the machine, or instruction.ErrNoInput if it's waiting for
+// input, in which case PC is unchanged and the instruction will be retried
+// by the next Step. Step returns a nil Step if nothing was executed.
+func (m *Machine) Step() (*Step, error) {
+	if m.halted {
+		return nil, ErrHalted
+	}
+
+	inst, numRead, err := m.fetch()
+	if err != nil {
+		err = fmt.Errorf("bad inst read at %v: %w", m.PC, err)
+		m.halt(err)
+		return nil, err
+	}
+
+	if m.BeforeExec != nil && !(m.waiting && m.waitPC == m.PC) {
+		m.BeforeExec(m.PC, inst)
+	}
+	m.waiting = false
+
+	s := &Step{
+		PC:   m.PC,
+		Op:   m.ctx.RAM.Read(m.PC),
+		Inst: inst,
+		Len:  numRead,
+		CB:   instruction.CB{NPC: m.PC + uint16(numRead)},
+	}
+
+	// The target must be found before the write, which may change the
+	// operand itself.
+	var target uint16
+	if s.Op == instruction.OpWmem {
+		target = m.ctx.RAM.Read(m.PC + 1)
+		if instruction.IsReg(target) {
+			target = m.ctx.RegFile.Get(instruction.RegNum(target))
+		}
+	}
+
+	inst.Exec(m.ctx, &s.CB)
+
+	if s.Op == instruction.OpWmem && !s.CB.Hlt {
+		m.cache.invalidate(target)
+	}
+
+	switch {
+	case errors.Is(s.CB.Err, instruction.ErrNoInput):
+		m.waiting, m.waitPC = true, m.PC
+		return s, s.CB.Err
+	case s.CB.Hlt:
+		m.halt(s.CB.Err)
+		err = s.CB.Err
+	default:
+		m.PC = s.CB.NPC
+	}
+
+	if m.AfterStep != nil {
+		m.AfterStep(s)
+	}
+	return s, err
+}
+
+// fetch returns the instruction at PC, from the cache if possible.
+func (m *Machine) fetch() (instruction.Inst, int, error) {
+	if !m.NoCache {
+		if inst, numRead, ok := m.cache.get(m.PC); ok {
+			return inst, numRead, nil
+		}
+	}
+
+	inst, numRead, err := instruction.Read(memory.NewRAMReader(m.ctx.RAM, m.PC))
+	if err == nil && !m.NoCache {
+		m.cache.put(m.PC, inst, numRead)
+	}
+	return inst, numRead, err
+}
+
+// Run steps until the machine halts, an instruction fails or waits for
+// input, or until returns true after a step. It returns nil if the machine
+// halted normally or until stopped it; use Halted to tell which.
+func (m *Machine) Run(until func(s *Step) bool) error {
+	for {
+		s, err := m.Step()
+		if err != nil {
+			return err
+		}
+		if m.halted || (until != nil && until(s)) {
+			return nil
+		}
+	}
+}
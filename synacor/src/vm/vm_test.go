@@ -0,0 +1,271 @@
+package vm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"device"
+	"instruction"
+	"memory"
+	"teleporter"
+	"util"
+)
+
+var (
+	r1 = instruction.RegArg(1)
+
+	// in r1; out r1; add r1 r1 1; jt r1 0
+	echo = []uint16{
+		instruction.OpIn, r1,
+		instruction.OpOut, r1,
+		instruction.OpAdd, r1, r1, 1,
+		instruction.OpJt, r1, 0,
+	}
+)
+
+func TestStep(t *testing.T) {
+	buf := device.NewBuffer("a")
+	m := New(buf)
+	if err := m.Load(echo); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	s, err := m.Step()
+	if err != nil || s.PC != 0 || s.Op != instruction.OpIn || s.Len != 2 || m.PC != 2 {
+		t.Errorf("Step() = %+v, %v, pc %v; want in at 0, nil, pc 2", s, err, m.PC)
+	}
+	if got := m.RegFile().Get(1); got != 'a' {
+		t.Errorf("r1 = %v, want 'a'", got)
+	}
+
+	first := s
+	if s, err := m.Step(); err != nil || s.CB.NPC != 4 || buf.Output() != "a" {
+		t.Errorf("Step() = %+v, %v, output %q; want out, nil, \"a\"", s, err, buf.Output())
+	}
+
+	// Earlier Steps aren't overwritten by later ones.
+	if first.PC != 0 || first.Op != instruction.OpIn {
+		t.Errorf("first Step after second = %+v, want in at 0", first)
+	}
+}
+
+func TestRun(t *testing.T) {
+	session := device.NewSession()
+	m := New(session)
+	m.Load(echo)
+
+	var pcs []uint16
+	m.BeforeExec = func(pc uint16, inst instruction.Inst) {
+		pcs = append(pcs, pc)
+	}
+
+	// Without input, the machine waits at the in instruction.
+	if err := m.Run(nil); !errors.Is(err, instruction.ErrNoInput) || m.PC != 0 || m.Halted() {
+		t.Fatalf("Run() = %v, pc %v, halted %v; want ErrNoInput, 0, false", err, m.PC, m.Halted())
+	}
+
+	session.Send("hi")
+	if err := m.Run(func(s *Step) bool { return s.Op == instruction.OpJt }); err != nil || m.PC != 0 {
+		t.Errorf("Run(until jt) = %v, pc %v; want nil, 0", err, m.PC)
+	}
+	if out := session.TakeOutput(); out != "h" {
+		t.Errorf("output = %q, want \"h\"", out)
+	}
+	// The in is only reported once, though it waited first.
+	if !reflect.DeepEqual(pcs, []uint16{0, 2, 4, 8}) {
+		t.Errorf("BeforeExec pcs = %v, want [0 2 4 8]", pcs)
+	}
+}
+
+func TestHalt(t *testing.T) {
+	m := New(device.NewBuffer(""))
+	m.Load([]uint16{instruction.OpNop, instruction.OpHlt})
+
+	if err := m.Run(nil); err != nil || !m.Halted() || m.Err() != nil || m.PC != 1 {
+		t.Errorf("Run() = %v, halted %v, err %v, pc %v; want nil, true, nil, 1",
+			err, m.Halted(), m.Err(), m.PC)
+	}
+	if _, err := m.Step(); err != ErrHalted {
+		t.Errorf("Step() after halt = _, %v, want ErrHalted", err)
+	}
+
+	// Failures halt with the reason.
+	m = New(device.NewBuffer(""))
+	m.Load([]uint16{instruction.OpPop, r1})
+	if err := m.Run(nil); !errors.Is(err, instruction.ErrEmptyStack) || !m.Halted() ||
+		!errors.Is(m.Err(), instruction.ErrEmptyStack) {
+		t.Errorf("Run(pop) = %v, halted %v, err %v; want ErrEmptyStack, true, ErrEmptyStack",
+			err, m.Halted(), m.Err())
+	}
+
+	m = New(device.NewBuffer(""))
+	m.Load([]uint16{99})
+	if s, err := m.Step(); s != nil || err == nil || !m.Halted() {
+		t.Errorf("Step(bad op) = %v, %v, halted %v; want nil, error, true", s, err, m.Halted())
+	}
+}
+
+func TestAfterStep(t *testing.T) {
+	m := New(device.NewBuffer(""))
+	m.Load([]uint16{instruction.OpNop, instruction.OpHlt})
+
+	var pcs []uint16
+	m.AfterStep = func(s *Step) {
+		pcs = append(pcs, s.PC)
+	}
+
+	// The hlt is reported too.
+	if err := m.Run(nil); err != nil || len(pcs) != 2 || pcs[0] != 0 || pcs[1] != 1 {
+		t.Errorf("Run() = %v, AfterStep pcs %v; want nil, [0 1]", err, pcs)
+	}
+	// An in waiting for input isn't.
+	session := device.NewSession()
+	m = New(session)
+	m.Load(echo)
+	pcs = nil
+	m.AfterStep = func(s *Step) {
+		pcs = append(pcs, s.PC)
+	}
+
+	m.Run(nil)
+	session.Send("a")
+	m.Run(func(s *Step) bool { return s.Op == instruction.OpOut })
+	if !reflect.DeepEqual(pcs, []uint16{0, 2}) {
+		t.Errorf("AfterStep pcs with waiting in = %v, want [0 2]", pcs)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	m := New(nil)
+	if err := m.Load(make([]uint16, memory.Size+1)); err != ErrImageTooLarge {
+		t.Errorf("Load(too large) = %v, want ErrImageTooLarge", err)
+	}
+
+	if err := m.ApplyRAMOverrides("5=21,32767=1"); err != nil {
+		t.Errorf("ApplyRAMOverrides() = %v", err)
+	}
+	if m.RAM().Read(5) != 21 || m.RAM().Read(32767) != 1 {
+		t.Errorf("RAM = %v, %v, want 21, 1", m.RAM().Read(5), m.RAM().Read(32767))
+	}
+
+	for _, spec := range []string{"5", "32768=1", "1=65536", "x=1"} {
+		if err := m.ApplyRAMOverrides(spec); err == nil {
+			t.Errorf("ApplyRAMOverrides(%q) = nil, want error", spec)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := New(device.NewBuffer(""))
+	m.Load([]uint16{instruction.OpPush, 7, instruction.OpHlt})
+	m.Run(nil)
+
+	snap := m.Snapshot([]byte("x"))
+	if snap.PC != 2 || string(snap.Input) != "x" {
+		t.Errorf("Snapshot() = pc %v, input %q, want 2, \"x\"", snap.PC, snap.Input)
+	}
+
+	other := New(nil)
+	other.Restore(snap)
+	if other.PC != 2 || other.Halted() || other.Stack().Len() != 1 || other.RAM().Read(1) != 7 {
+		t.Errorf("Restore() = pc %v, halted %v, stack %v; want 2, false, [7]",
+			other.PC, other.Halted(), other.Stack().Values())
+	}
+}
+
+func TestCache(t *testing.T) {
+	// out 'a'; jt r1 13; wmem 1 'b'; set r1 1; jmp 0; hlt
+	prog := []uint16{
+		instruction.OpOut, 'a',
+		instruction.OpJt, r1, 13,
+		instruction.OpWmem, 1, 'b',
+		instruction.OpSet, r1, 1,
+		instruction.OpJmp, 0,
+		instruction.OpHlt,
+	}
+
+	for _, noCache := range []bool{false, true} {
+		buf := device.NewBuffer("")
+		m := New(buf)
+		m.NoCache = noCache
+		m.Load(prog)
+
+		// The out at 0 is cached when the wmem rewrites its operand.
+		if err := m.Run(nil); err != nil || buf.Output() != "ab" {
+			t.Errorf("NoCache %v: Run() = %v, output %q; want nil, \"ab\"", noCache, err, buf.Output())
+		}
+	}
+
+	// out 'a'; in r1; jmp 0
+	session := device.NewSession()
+	m := New(session)
+	m.Load([]uint16{instruction.OpOut, 'a', instruction.OpIn, r1, instruction.OpJmp, 0})
+	m.Run(nil)
+
+	m.RAM().Write(1, 'c')
+	m.FlushCache()
+	session.AddInput("x")
+	m.Run(nil)
+	if out := session.TakeOutput(); out != "ac" {
+		t.Errorf("output after FlushCache = %q, want \"ac\"", out)
+	}
+}
+
+const verifyR8 = 6027
+
+// newVerifier returns a machine ready to run the teleporter's verify_r8
+// from challenge.bin. It halts when verify_r8 returns.
+func newVerifier(tb testing.TB, a, b, r8 uint16) *Machine {
+	image, err := util.ReadWordsFromPath("../../challenge.bin")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	m := New(device.NewBuffer(""))
+	m.Load(image)
+	m.PC = verifyR8
+	m.RegFile().Set(1, a)
+	m.RegFile().Set(2, b)
+	m.RegFile().Set(8, r8)
+	return m
+}
+
+func TestVerifyR8(t *testing.T) {
+	eval := teleporter.NewEvaluator()
+
+	for _, args := range [][3]uint16{{0, 5, 1}, {1, 3, 7}, {2, 2, 3}, {3, 1, 2}} {
+		want := eval.Eval(args[0], args[1], args[2])
+
+		for _, noCache := range []bool{false, true} {
+			m := newVerifier(t, args[0], args[1], args[2])
+			m.NoCache = noCache
+			if err := m.Run(nil); err != nil || !m.Halted() {
+				t.Errorf("NoCache %v: verify_r8%v = %v, halted %v; want nil, true", noCache, args, err, m.Halted())
+			}
+			if got := m.RegFile().Get(1); got != want {
+				t.Errorf("NoCache %v: verify_r8%v = %v, want %v", noCache, args, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkVerifyR8(b *testing.B) {
+	for _, noCache := range []bool{true, false} {
+		name := "cached"
+		if noCache {
+			name = "uncached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				m := newVerifier(b, 3, 1, 2)
+				m.NoCache = noCache
+				b.StartTimer()
+
+				m.Run(nil)
+			}
+		})
+	}
+}
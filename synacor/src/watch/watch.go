@@ -0,0 +1,215 @@
+// Package watch implements memory and register watchpoints. A Set is
+// installed as the instruction.Context Watcher, and collects hits as
+// instructions execute.
+package watch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"symtab"
+	"util"
+)
+
+type Action int
+
+const (
+	Log Action = iota
+	Pause
+)
+
+func (a Action) String() string {
+	switch a {
+	case Log:
+		return "log"
+	case Pause:
+		return "pause"
+	default:
+		panic("unknown")
+	}
+}
+
+// Watchpoint watches either a range of memory or a register. Register
+// watchpoints only trigger on writes which change the value.
+type Watchpoint struct {
+	Num         int
+	Start, End  uint16 // inclusive memory range
+	Reg         uint   // register number, or 0 for memory
+	Read, Write bool
+	Action      Action
+}
+
+func (w *Watchpoint) String() string {
+	if w.Reg != 0 {
+		return fmt.Sprintf("r%d", w.Reg)
+	}
+
+	mode := ""
+	if w.Read {
+		mode += "r"
+	}
+	if w.Write {
+		mode += "w"
+	}
+
+	if w.Start == w.End {
+		return fmt.Sprintf("%s:%d", mode, w.Start)
+	}
+	return fmt.Sprintf("%s:%d-%d", mode, w.Start, w.End)
+}
+
+// Hit is a triggered watchpoint.
+type Hit struct {
+	WP       *Watchpoint
+	Addr     uint16 // memory address, if a memory watchpoint
+	Write    bool
+	Old, Val uint16 // Old is only set for writes
+}
+
+func (h Hit) String() string {
+	if h.WP.Reg != 0 {
+		return fmt.Sprintf("watch %d: r%d %d -> %d", h.WP.Num, h.WP.Reg, h.Old, h.Val)
+	}
+	if h.Write {
+		return fmt.Sprintf("watch %d: write %d: %d -> %d", h.WP.Num, h.Addr, h.Old, h.Val)
+	}
+	return fmt.Sprintf("watch %d: read %d: %d", h.WP.Num, h.Addr, h.Val)
+}
+
+// Set implements instruction.Watcher.
+type Set struct {
+	wps     []*Watchpoint
+	nextNum int
+	hits    []Hit
+}
+
+func NewSet() *Set {
+	return &Set{nextNum: 1}
+}
+
+func (s *Set) add(wp *Watchpoint) *Watchpoint {
+	wp.Num = s.nextNum
+	s.nextNum++
+	s.wps = append(s.wps, wp)
+	return wp
+}
+
+// AddMem watches [start, end] for reads and/or writes.
+func (s *Set) AddMem(start, end uint16, read, write bool, action Action) *Watchpoint {
+	return s.add(&Watchpoint{Start: start, End: end, Read: read, Write: write, Action: action})
+}
+
+// AddReg watches for changes to register num.
+func (s *Set) AddReg(num uint, action Action) *Watchpoint {
+	return s.add(&Watchpoint{Reg: num, Write: true, Action: action})
+}
+
+// Delete removes watchpoint num, returning false if it doesn't exist.
+func (s *Set) Delete(num int) bool {
+	for i, wp := range s.wps {
+		if wp.Num == num {
+			s.wps = append(s.wps[:i], s.wps[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Watchpoints returns the active watchpoints, in creation order.
+func (s *Set) Watchpoints() []*Watchpoint {
+	return s.wps
+}
+
+func (s *Set) RegWrite(num uint, old, val uint16) {
+	if old == val {
+		return
+	}
+	for _, wp := range s.wps {
+		if wp.Reg == num {
+			s.hits = append(s.hits, Hit{WP: wp, Write: true, Old: old, Val: val})
+		}
+	}
+}
+
+func (s *Set) MemRead(addr, val uint16) {
+	for _, wp := range s.wps {
+		if wp.Reg == 0 && wp.Read && addr >= wp.Start && addr <= wp.End {
+			s.hits = append(s.hits, Hit{WP: wp, Addr: addr, Val: val})
+		}
+	}
+}
+
+func (s *Set) MemWrite(addr, old, val uint16) {
+	for _, wp := range s.wps {
+		if wp.Reg == 0 && wp.Write && addr >= wp.Start && addr <= wp.End {
+			s.hits = append(s.hits, Hit{WP: wp, Addr: addr, Write: true, Old: old, Val: val})
+		}
+	}
+}
+
+// Hits returns the hits since the last call to Hits, and whether any of
+// them asked for a pause.
+func (s *Set) Hits() (hits []Hit, pause bool) {
+	hits, s.hits = s.hits, nil
+	for _, h := range hits {
+		if h.WP.Action == Pause {
+			pause = true
+		}
+	}
+	return hits, pause
+}
+
+// Add parses and adds a watchpoint spec. Specs are either a register
+// (r1-r8), or an optional mode (r, w, or rw; default w), a colon, and a
+// location or location range, as in w:937 or rw:foo-foo+4.
+func (s *Set) Add(spec string, action Action, st symtab.SymTab) (*Watchpoint, error) {
+	if num, err := parseReg(spec); err == nil {
+		return s.AddReg(num, action), nil
+	}
+
+	mode, loc := "w", spec
+	if parts := strings.SplitN(spec, ":", 2); len(parts) == 2 {
+		mode, loc = parts[0], parts[1]
+	}
+
+	var read, write bool
+	switch mode {
+	case "r":
+		read = true
+	case "w":
+		write = true
+	case "rw", "wr":
+		read, write = true, true
+	default:
+		return nil, fmt.Errorf("bad mode %v", mode)
+	}
+
+	locs := strings.SplitN(loc, "-", 2)
+	start, err := util.NameToAddr(locs[0], st)
+	if err != nil {
+		return nil, fmt.Errorf("bad location %v: %v", locs[0], err)
+	}
+	end := start
+	if len(locs) == 2 {
+		if end, err = util.NameToAddr(locs[1], st); err != nil {
+			return nil, fmt.Errorf("bad location %v: %v", locs[1], err)
+		}
+		if end < start {
+			return nil, fmt.Errorf("bad range %v", loc)
+		}
+	}
+
+	return s.AddMem(start, end, read, write, action), nil
+}
+
+func parseReg(str string) (uint, error) {
+	if !strings.HasPrefix(str, "r") {
+		return 0, fmt.Errorf("not a register")
+	}
+	num, err := strconv.ParseUint(str[1:], 10, 8)
+	if err != nil || num < 1 || num > 8 {
+		return 0, fmt.Errorf("bad register %v", str)
+	}
+	return uint(num), nil
+}
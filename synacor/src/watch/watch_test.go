@@ -0,0 +1,104 @@
+package watch
+
+import (
+	"reflect"
+	"testing"
+
+	"instruction"
+	"memory"
+	"register"
+	"symtab"
+)
+
+func TestAdd(t *testing.T) {
+	st := symtab.New()
+	st.Add("foo", 100, 110)
+
+	type testCase struct {
+		spec     string
+		expected Watchpoint
+	}
+
+	tests := []testCase{
+		{"r8", Watchpoint{Num: 1, Reg: 8, Write: true}},
+		{"937", Watchpoint{Num: 2, Start: 937, End: 937, Write: true}},
+		{"r:foo-foo+4", Watchpoint{Num: 3, Start: 100, End: 104, Read: true}},
+		{"rw:5-6", Watchpoint{Num: 4, Start: 5, End: 6, Read: true, Write: true}},
+	}
+
+	s := NewSet()
+	for _, test := range tests {
+		wp, err := s.Add(test.spec, Log, st)
+		if err != nil || !reflect.DeepEqual(*wp, test.expected) {
+			t.Errorf("Add(%v) = %+v, %v, want %+v, nil", test.spec, wp, err, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"r9", "x:5", "bar", "6-5"} {
+		if wp, err := s.Add(spec, Log, st); err == nil {
+			t.Errorf("Add(%v) = %+v, nil, want _, non-nil", spec, wp)
+		}
+	}
+}
+
+func TestHits(t *testing.T) {
+	ram := &memory.RAM{}
+	regFile := &register.File{}
+	s := NewSet()
+
+	ctx := &instruction.Context{
+		RAM:     ram,
+		RegFile: regFile,
+		Stack:   memory.NewStack(),
+		Watcher: s,
+	}
+
+	regWP := s.AddReg(1, Log)
+	writeWP := s.AddMem(10, 11, false, true, Pause)
+	readWP := s.AddMem(11, 11, true, false, Log)
+
+	ram.Write(11, 42)
+	prog := []uint16{
+		16, 10, 5, // wmem 10 5
+		15, 32768, 11, // rmem r1 11
+		1, 32768, 42, // set r1 42 (no change)
+	}
+	for i, v := range prog {
+		ram.Write(uint16(100+i), v)
+	}
+
+	exec := func(pc uint16) {
+		inst, _, err := instruction.Read(memory.NewRAMReader(ram, pc))
+		if err != nil {
+			t.Fatalf("Read(%v) = _, _, %v", pc, err)
+		}
+		inst.Exec(ctx, &instruction.CB{})
+	}
+
+	exec(100)
+	hits, pause := s.Hits()
+	if expected := []Hit{{WP: writeWP, Addr: 10, Write: true, Old: 0, Val: 5}}; !reflect.DeepEqual(hits, expected) || !pause {
+		t.Errorf("after wmem, Hits() = %v, %v, want %v, true", hits, pause, expected)
+	}
+
+	exec(103)
+	hits, pause = s.Hits()
+	expected := []Hit{
+		{WP: readWP, Addr: 11, Val: 42},
+		{WP: regWP, Write: true, Old: 0, Val: 42},
+	}
+	if !reflect.DeepEqual(hits, expected) || pause {
+		t.Errorf("after rmem, Hits() = %v, %v, want %v, false", hits, pause, expected)
+	}
+
+	exec(106)
+	if hits, _ := s.Hits(); len(hits) != 0 {
+		t.Errorf("after set, Hits() = %v, want none", hits)
+	}
+
+	s.Delete(writeWP.Num)
+	exec(100)
+	if hits, _ := s.Hits(); len(hits) != 0 {
+		t.Errorf("after Delete, Hits() = %v, want none", hits)
+	}
+}